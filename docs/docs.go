@@ -17,7 +17,12 @@ const docTemplate = `{
     "paths": {
         "/events": {
             "get": {
-                "description": "list composition events",
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "list composition events, most recent first",
                 "produces": [
                     "application/json"
                 ],
@@ -35,6 +40,101 @@ const docTemplate = `{
                         "description": "Max number of events",
                         "name": "limit",
                         "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Token returned by the previous page request",
+                        "name": "continue",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only events happened after this time (RFC3339 or duration, i.e. 15m)",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only events happened before this time (RFC3339 or duration, i.e. 15m)",
+                        "name": "until",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Event types (Normal, Warning)",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Event reasons",
+                        "name": "reason",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Event namespaces",
+                        "name": "namespace",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Kinds of the involved objects",
+                        "name": "involvedObject.kind",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Names of the involved objects",
+                        "name": "involvedObject.name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Components that reported the events",
+                        "name": "source.component",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "v1",
+                            "events.k8s.io/v1",
+                            "cloudevents"
+                        ],
+                        "type": "string",
+                        "description": "Events representation: core/v1 (default), events.k8s.io/v1 or CloudEvents",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bearer token, for clients that cannot set headers (when authentication is enabled)",
+                        "name": "token",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -45,6 +145,24 @@ const docTemplate = `{
                             "items": {
                                 "$ref": "#/definitions/types.Event"
                             }
+                        },
+                        "headers": {
+                            "X-Continue": {
+                                "type": "string",
+                                "description": "Token to fetch the next page, if any"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or invalid bearer token (when authentication is enabled)",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "The user cannot get the composition (when authorization is enabled)",
+                        "schema": {
+                            "type": "string"
                         }
                     }
                 }
@@ -71,12 +189,110 @@ const docTemplate = `{
         },
         "/notifications": {
             "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
                 "description": "Get available events notifications",
                 "produces": [
                     "application/json"
                 ],
                 "summary": "SSE Endpoint",
                 "operationId": "notifications",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Resume the stream after this event id",
+                        "name": "Last-Event-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Resume the stream after this event id (for clients that cannot set headers)",
+                        "name": "lastEventId",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Composition identifiers",
+                        "name": "composition",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Event namespaces",
+                        "name": "namespace",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Kinds of the involved objects",
+                        "name": "involvedKind",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Event reasons",
+                        "name": "reason",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Event types (Normal, Warning)",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only events happened after this time (RFC3339 or duration, i.e. 15m)",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Send the last N stored events before the live ones",
+                        "name": "backfill",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "v1",
+                            "events.k8s.io/v1",
+                            "cloudevents"
+                        ],
+                        "type": "string",
+                        "description": "Events representation: core/v1 (default), events.k8s.io/v1 or CloudEvents",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bearer token, for clients that cannot set headers (when authentication is enabled)",
+                        "name": "token",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -86,12 +302,120 @@ const docTemplate = `{
                                 "$ref": "#/definitions/types.Event"
                             }
                         }
+                    },
+                    "401": {
+                        "description": "Missing or invalid bearer token (when authentication is enabled)",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "The user cannot get the compositions (when authorization is enabled)",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/notifications/stats": {
+            "get": {
+                "description": "List the active SSE streams with their queued and dropped events",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "SSE Streams Statistics",
+                "operationId": "notifications-stats",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/broker.Stats"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/ready": {
+            "get": {
+                "description": "Checks the store connectivity, the broker liveness and the shutdown state",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Readiness Endpoint",
+                "operationId": "ready",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/health.ReadyResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/health.ReadyResponse"
+                        }
                     }
                 }
             }
         }
     },
     "definitions": {
+        "broker.Stats": {
+            "type": "object",
+            "properties": {
+                "delivered": {
+                    "type": "integer"
+                },
+                "dropped": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "queued": {
+                    "type": "integer"
+                }
+            }
+        },
+        "health.CheckResult": {
+            "type": "object",
+            "properties": {
+                "duration": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "ok": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "health.ReadyResponse": {
+            "type": "object",
+            "properties": {
+                "checks": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/health.CheckResult"
+                    }
+                },
+                "ready": {
+                    "type": "boolean"
+                }
+            }
+        },
         "types.Event": {
             "type": "object",
             "properties": {
@@ -258,6 +582,14 @@ const docTemplate = `{
                 }
             }
         }
+    },
+    "securityDefinitions": {
+        "BearerAuth": {
+            "description": "OIDC token as 'Bearer \u003ctoken\u003e', when the authentication is enabled",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
+        }
     }
 }`
 
@@ -0,0 +1,58 @@
+// Package authz decides which compositions the authenticated
+// users can read the events of: a user can read the events of
+// the compositions they can 'get' in Kubernetes.
+package authz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/krateoplatformops/eventsse/internal/labels"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	// ErrUnauthenticated is returned when the request has no user.
+	ErrUnauthenticated = errors.New("unauthenticated request")
+	// ErrForbidden is returned when the user cannot read
+	// the events of the composition.
+	ErrForbidden = errors.New("cannot read the events of the composition")
+)
+
+// Authorizer decides whether the user of the request
+// can read the events of a composition.
+type Authorizer interface {
+	Allowed(ctx context.Context, compositionID string) (bool, error)
+}
+
+// Match returns a function selecting the events, belonging
+// to a composition, that the user of the request can read;
+// the errors deny the access.
+func Match(ctx context.Context, a Authorizer) func(ev *corev1.Event) bool {
+	return func(ev *corev1.Event) bool {
+		cid := labels.CompositionID(ev)
+		if len(cid) == 0 {
+			return false
+		}
+
+		ok, err := a.Allowed(ctx, cid)
+		return err == nil && ok
+	}
+}
+
+// Check returns nil if the user of the request can read the
+// events of the composition; otherwise the error, together
+// with the HTTP status to respond with.
+func Check(ctx context.Context, a Authorizer, compositionID string) (int, error) {
+	ok, err := a.Allowed(ctx, compositionID)
+	switch {
+	case errors.Is(err, ErrUnauthenticated):
+		return http.StatusUnauthorized, err
+	case err != nil:
+		return http.StatusInternalServerError, err
+	case !ok:
+		return http.StatusForbidden, ErrForbidden
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,220 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	// DefaultGroup is the API group of the Krateo compositions.
+	DefaultGroup = "composition.krateo.io"
+
+	// minResync is the min interval between two scans
+	// of the compositions caused by unknown ids.
+	minResync = 10 * time.Second
+
+	// listTimeout bounds a scan of the compositions.
+	listTimeout = 30 * time.Second
+)
+
+// ErrUnknownComposition is returned when no composition has the given id.
+var ErrUnknownComposition = errors.New("unknown composition")
+
+// Composition is the Kubernetes resource of a composition.
+type Composition struct {
+	Group     string
+	Version   string
+	Resource  string
+	Namespace string
+	Name      string
+}
+
+// ResolverOptions configures a Resolver.
+type ResolverOptions struct {
+	Discovery discovery.DiscoveryInterface
+	Dynamic   dynamic.Interface
+	// Group is the API group of the compositions.
+	Group string
+	// Resync is how long the compositions found are cached.
+	Resync time.Duration
+}
+
+// Resolver finds the composition with a given id (its uid),
+// among all the resources of the compositions API group.
+//
+// All the compositions are listed at once and cached: they are
+// listed again after the resync interval, or when asked for an
+// unknown id (i.e. a new composition).
+type Resolver struct {
+	disc   discovery.DiscoveryInterface
+	dyn    dynamic.Interface
+	group  string
+	resync time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	all     map[string]Composition
+	synced  time.Time
+	running *listCall // The scan in progress, if any.
+}
+
+// listCall is a scan of the compositions shared by all
+// the lookups that need it while in progress.
+type listCall struct {
+	done chan struct{}
+	all  map[string]Composition
+	err  error
+}
+
+// NewResolver returns a Resolver.
+func NewResolver(opts ResolverOptions) *Resolver {
+	r := &Resolver{
+		disc:   opts.Discovery,
+		dyn:    opts.Dynamic,
+		group:  opts.Group,
+		resync: opts.Resync,
+		now:    time.Now,
+	}
+	if len(r.group) == 0 {
+		r.group = DefaultGroup
+	}
+	if r.resync <= 0 {
+		r.resync = time.Minute
+	}
+	return r
+}
+
+// Resolve returns the composition with the given id.
+//
+// The compositions are listed without holding the lock, so that a
+// slow API server does not hold back the lookups of the cached ones.
+func (r *Resolver) Resolve(ctx context.Context, id string) (Composition, error) {
+	r.mu.Lock()
+	now := r.now()
+	comp, ok := r.all[id]
+	if age := now.Sub(r.synced); r.all != nil && age < r.resync && (ok || age < minResync) {
+		r.mu.Unlock()
+		if !ok {
+			return Composition{}, ErrUnknownComposition
+		}
+		return comp, nil
+	}
+	call := r.sync(now)
+	r.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return Composition{}, ctx.Err()
+	}
+	if call.err != nil {
+		return Composition{}, call.err
+	}
+
+	comp, ok = call.all[id]
+	if !ok {
+		return Composition{}, ErrUnknownComposition
+	}
+	return comp, nil
+}
+
+// sync starts listing the compositions, unless a scan is already
+// in progress; the returned call is done when they are listed.
+//
+// It must be called holding the lock.
+func (r *Resolver) sync(now time.Time) *listCall {
+	if r.running != nil {
+		return r.running
+	}
+
+	call := &listCall{done: make(chan struct{})}
+	r.running = call
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), listTimeout)
+		defer cancel()
+
+		all, err := r.list(ctx)
+
+		r.mu.Lock()
+		if err == nil {
+			r.all, r.synced = all, now
+		}
+		r.running = nil
+		r.mu.Unlock()
+
+		call.all, call.err = all, err
+		close(call.done)
+	}()
+
+	return call
+}
+
+// list returns all the compositions by id.
+func (r *Resolver) list(ctx context.Context) (map[string]Composition, error) {
+	groups, err := r.disc.ServerGroups()
+	if err != nil {
+		return nil, fmt.Errorf("discovering API groups: %w", err)
+	}
+
+	res := map[string]Composition{}
+	for _, grp := range groups.Groups {
+		if grp.Name != r.group {
+			continue
+		}
+
+		for _, ver := range grp.Versions {
+			rl, err := r.disc.ServerResourcesForGroupVersion(ver.GroupVersion)
+			if err != nil {
+				return nil, fmt.Errorf("discovering %s resources: %w", ver.GroupVersion, err)
+			}
+
+			for _, el := range rl.APIResources {
+				// Skip the subresources (i.e. status).
+				if !el.Namespaced || strings.Contains(el.Name, "/") || !canList(el.Verbs) {
+					continue
+				}
+
+				gvr := schema.GroupVersionResource{Group: grp.Name, Version: ver.Version, Resource: el.Name}
+				all, err := r.dyn.Resource(gvr).List(ctx, metav1.ListOptions{})
+				if err != nil {
+					return nil, fmt.Errorf("listing %s: %w", gvr, err)
+				}
+
+				for _, obj := range all.Items {
+					// The same object is listed once for each version.
+					if _, ok := res[string(obj.GetUID())]; ok && ver.Version != grp.PreferredVersion.Version {
+						continue
+					}
+					res[string(obj.GetUID())] = Composition{
+						Group:     grp.Name,
+						Version:   ver.Version,
+						Resource:  el.Name,
+						Namespace: obj.GetNamespace(),
+						Name:      obj.GetName(),
+					}
+				}
+			}
+		}
+	}
+
+	return res, nil
+}
+
+func canList(verbs metav1.Verbs) bool {
+	for _, el := range verbs {
+		if el == "list" {
+			return true
+		}
+	}
+	return false
+}
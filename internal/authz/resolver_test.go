@@ -0,0 +1,182 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var fireworksApps = schema.GroupVersionResource{
+	Group:    DefaultGroup,
+	Version:  "v1-1-0",
+	Resource: "fireworksapps",
+}
+
+func newComposition(uid, namespace, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(fireworksApps.GroupVersion().String())
+	obj.SetKind("FireworksApp")
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	obj.SetUID(types.UID(uid))
+	return obj
+}
+
+// newFakes returns the fake clientsets serving the
+// compositions API group with the given compositions.
+func newFakes(objs ...runtime.Object) (*fake.Clientset, *dynamicfake.FakeDynamicClient) {
+	cs := fake.NewSimpleClientset()
+	cs.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: fireworksApps.GroupVersion().String(),
+			APIResources: []metav1.APIResource{
+				{Name: "fireworksapps", Namespaced: true, Kind: "FireworksApp", Verbs: metav1.Verbs{"get", "list"}},
+				{Name: "fireworksapps/status", Namespaced: true, Kind: "FireworksApp", Verbs: metav1.Verbs{"get"}},
+			},
+		},
+		{
+			GroupVersion: "apps/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "deployments", Namespaced: true, Kind: "Deployment", Verbs: metav1.Verbs{"get", "list"}},
+			},
+		},
+	}
+
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{fireworksApps: "FireworksAppList"}, objs...)
+
+	return cs, dyn
+}
+
+func TestResolve(t *testing.T) {
+	cs, dyn := newFakes(
+		newComposition("uid-1", "demo-system", "app-1"),
+		newComposition("uid-2", "other", "app-2"),
+	)
+
+	r := NewResolver(ResolverOptions{Discovery: cs.Discovery(), Dynamic: dyn})
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	got, err := r.Resolve(context.Background(), "uid-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := Composition{Group: DefaultGroup, Version: "v1-1-0", Resource: "fireworksapps", Namespace: "other", Name: "app-2"}
+	if got != exp {
+		t.Fatalf("got %+v, expected %+v", got, exp)
+	}
+
+	lists := func() int {
+		n := 0
+		for _, el := range dyn.Actions() {
+			if el.GetVerb() == "list" {
+				n++
+			}
+		}
+		return n
+	}
+
+	// Cached.
+	if _, err := r.Resolve(context.Background(), "uid-1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := lists(); got != 1 {
+		t.Fatalf("expected 1 list, got %d", got)
+	}
+
+	// Unknown ids do not cause a scan more than once every minResync.
+	if _, err := r.Resolve(context.Background(), "uid-3"); !errors.Is(err, ErrUnknownComposition) {
+		t.Fatalf("expected %v, got %v", ErrUnknownComposition, err)
+	}
+	if got := lists(); got != 1 {
+		t.Fatalf("expected 1 list, got %d", got)
+	}
+
+	// A new composition is found after minResync.
+	err = dyn.Tracker().Add(newComposition("uid-3", "demo-system", "app-3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(minResync)
+	if _, err := r.Resolve(context.Background(), "uid-3"); err != nil {
+		t.Fatal(err)
+	}
+	if got := lists(); got != 2 {
+		t.Fatalf("expected 2 lists, got %d", got)
+	}
+}
+
+func TestResolveError(t *testing.T) {
+	cs, dyn := newFakes()
+	dyn.PrependReactor("list", "fireworksapps", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("boom")
+	})
+
+	r := NewResolver(ResolverOptions{Discovery: cs.Discovery(), Dynamic: dyn})
+	if _, err := r.Resolve(context.Background(), "uid-1"); err == nil || errors.Is(err, ErrUnknownComposition) {
+		t.Fatalf("expected a list error, got %v", err)
+	}
+}
+
+func TestResolveConcurrent(t *testing.T) {
+	cs, dyn := newFakes(
+		newComposition("uid-1", "demo-system", "app-1"),
+		newComposition("uid-2", "other", "app-2"),
+	)
+
+	var lists atomic.Int32
+	release := make(chan struct{})
+	dyn.PrependReactor("list", "fireworksapps", func(k8stesting.Action) (bool, runtime.Object, error) {
+		lists.Add(1)
+		<-release
+		return false, nil, nil
+	})
+
+	r := NewResolver(ResolverOptions{Discovery: cs.Discovery(), Dynamic: dyn})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			_, err := r.Resolve(context.Background(), id)
+			errs <- err
+		}([]string{"uid-1", "uid-2"}[i%2])
+	}
+
+	// The scan in progress does not hold the resolver back.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := r.Resolve(ctx, "uid-1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := lists.Load(); got != 1 {
+		t.Fatalf("expected the lookups to share 1 list, got %d", got)
+	}
+}
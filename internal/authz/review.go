@@ -0,0 +1,128 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/krateoplatformops/eventsse/internal/cache"
+	"github.com/krateoplatformops/eventsse/internal/middlewares/auth"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultCacheTTL is how long the decisions are cached by default.
+const DefaultCacheTTL = time.Minute
+
+// ReviewerOptions configures a Reviewer.
+type ReviewerOptions struct {
+	Client   kubernetes.Interface
+	Resolver *Resolver
+	// UsernameClaim is the token claim holding the user name.
+	UsernameClaim string
+	// GroupsClaim is the token claim holding the user groups.
+	GroupsClaim string
+	// UsernamePrefix and GroupsPrefix are prepended to the
+	// claims, the same way the Kubernetes API server does
+	// (--oidc-username-prefix, --oidc-groups-prefix).
+	UsernamePrefix string
+	GroupsPrefix   string
+	// CacheTTL is how long the decisions are cached.
+	CacheTTL time.Duration
+}
+
+// Reviewer is an Authorizer asking Kubernetes, with a
+// SubjectAccessReview, whether the user of the request
+// can 'get' the composition.
+type Reviewer struct {
+	client   kubernetes.Interface
+	resolver *Resolver
+	opts     ReviewerOptions
+	cache    *cache.TTLCache[string, bool]
+}
+
+var _ Authorizer = (*Reviewer)(nil)
+
+// NewReviewer returns a Reviewer.
+func NewReviewer(opts ReviewerOptions) *Reviewer {
+	if len(opts.UsernameClaim) == 0 {
+		opts.UsernameClaim = "sub"
+	}
+	if len(opts.GroupsClaim) == 0 {
+		opts.GroupsClaim = "groups"
+	}
+	if opts.CacheTTL <= 0 {
+		opts.CacheTTL = DefaultCacheTTL
+	}
+
+	return &Reviewer{
+		client:   opts.Client,
+		resolver: opts.Resolver,
+		opts:     opts,
+		cache:    cache.NewTTL[string, bool](),
+	}
+}
+
+// Allowed reports whether the user of the request can 'get' the
+// composition; the unknown compositions are denied.
+func (r *Reviewer) Allowed(ctx context.Context, compositionID string) (bool, error) {
+	claims, ok := auth.ClaimsFrom(ctx)
+	if !ok {
+		return false, ErrUnauthenticated
+	}
+
+	user := claims.String(r.opts.UsernameClaim)
+	if len(user) == 0 {
+		return false, ErrUnauthenticated
+	}
+	user = r.opts.UsernamePrefix + user
+
+	groups := claims.Strings(r.opts.GroupsClaim)
+	for i := range groups {
+		groups[i] = r.opts.GroupsPrefix + groups[i]
+	}
+
+	key := strings.Join(append([]string{compositionID, user}, groups...), "\x00")
+	if res, ok := r.cache.Get(key); ok {
+		return res, nil
+	}
+
+	comp, err := r.resolver.Resolve(ctx, compositionID)
+	if errors.Is(err, ErrUnknownComposition) {
+		r.cache.Set(key, false, r.opts.CacheTTL)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	sar := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user,
+			Groups: groups,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: comp.Namespace,
+				Verb:      "get",
+				Group:     comp.Group,
+				Resource:  comp.Resource,
+				Name:      comp.Name,
+			},
+		},
+	}
+
+	res, err := r.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, sar, metav1.CreateOptions{})
+	if err != nil {
+		return false, fmt.Errorf("reviewing access to %s/%s: %w", comp.Namespace, comp.Name, err)
+	}
+
+	r.cache.Set(key, res.Status.Allowed, r.opts.CacheTTL)
+	return res.Status.Allowed, nil
+}
+
+// Cached returns the number of cached decisions.
+func (r *Reviewer) Cached() int {
+	return r.cache.Len()
+}
@@ -0,0 +1,151 @@
+package authz
+
+import (
+	"context"
+	"testing"
+
+	"github.com/krateoplatformops/eventsse/internal/middlewares/auth"
+	"github.com/krateoplatformops/eventsse/internal/oidc"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// withClaims returns a context carrying the claims of an
+// authenticated request, as the auth middleware does.
+func withClaims(t *testing.T, claims oidc.Claims) context.Context {
+	t.Helper()
+	return auth.WithClaims(context.Background(), claims)
+}
+
+// allowReviews makes the fake clientset allow the given
+// user to get the compositions with the given names.
+func allowReviews(cs *fake.Clientset, user string, names ...string) *[]authorizationv1.SubjectAccessReviewSpec {
+	var reviews []authorizationv1.SubjectAccessReviewSpec
+	cs.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		sar := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		reviews = append(reviews, sar.Spec)
+
+		for _, el := range names {
+			if sar.Spec.User == user && sar.Spec.ResourceAttributes.Name == el {
+				sar.Status.Allowed = true
+			}
+		}
+		return true, sar, nil
+	})
+	return &reviews
+}
+
+func TestReviewer(t *testing.T) {
+	cs, dyn := newFakes(
+		newComposition("uid-1", "demo-system", "app-1"),
+		newComposition("uid-2", "other", "app-2"),
+	)
+	reviews := allowReviews(cs, "oidc:cyberjoker", "app-1")
+
+	r := NewReviewer(ReviewerOptions{
+		Client:         cs,
+		Resolver:       NewResolver(ResolverOptions{Discovery: cs.Discovery(), Dynamic: dyn}),
+		UsernameClaim:  "email",
+		UsernamePrefix: "oidc:",
+		GroupsPrefix:   "oidc:",
+	})
+
+	ctx := withClaims(t, oidc.Claims{
+		"sub":    "1234",
+		"email":  "cyberjoker",
+		"groups": []any{"devs"},
+	})
+
+	tests := []struct {
+		id       string
+		expected bool
+	}{
+		{"uid-1", true},
+		{"uid-2", false},
+		{"unknown", false},
+		// Cached.
+		{"uid-1", true},
+		{"uid-2", false},
+	}
+	for _, tt := range tests {
+		got, err := r.Allowed(ctx, tt.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.expected {
+			t.Errorf("%s: got %t, expected %t", tt.id, got, tt.expected)
+		}
+	}
+
+	if len(*reviews) != 2 {
+		t.Fatalf("expected 2 reviews, got %d", len(*reviews))
+	}
+
+	exp := authorizationv1.SubjectAccessReviewSpec{
+		User:   "oidc:cyberjoker",
+		Groups: []string{"oidc:devs"},
+		ResourceAttributes: &authorizationv1.ResourceAttributes{
+			Namespace: "demo-system",
+			Verb:      "get",
+			Group:     DefaultGroup,
+			Resource:  "fireworksapps",
+			Name:      "app-1",
+		},
+	}
+	got := (*reviews)[0]
+	if got.User != exp.User || len(got.Groups) != 1 || got.Groups[0] != exp.Groups[0] ||
+		*got.ResourceAttributes != *exp.ResourceAttributes {
+		t.Fatalf("got %+v, expected %+v", got, exp)
+	}
+
+	// Another user gets its own decisions.
+	other := withClaims(t, oidc.Claims{"email": "someone"})
+	if ok, _ := r.Allowed(other, "uid-1"); ok {
+		t.Fatal("expected the access to be denied")
+	}
+}
+
+func TestReviewerUnauthenticated(t *testing.T) {
+	cs, dyn := newFakes()
+	r := NewReviewer(ReviewerOptions{
+		Client:   cs,
+		Resolver: NewResolver(ResolverOptions{Discovery: cs.Discovery(), Dynamic: dyn}),
+	})
+
+	if _, err := r.Allowed(context.Background(), "uid-1"); err != ErrUnauthenticated {
+		t.Fatalf("expected %v, got %v", ErrUnauthenticated, err)
+	}
+
+	if _, err := r.Allowed(withClaims(t, oidc.Claims{"email": "x"}), "uid-1"); err != ErrUnauthenticated {
+		t.Fatalf("missing username claim: expected %v, got %v", ErrUnauthenticated, err)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	cs, dyn := newFakes(newComposition("uid-1", "demo-system", "app-1"))
+	allowReviews(cs, "cyberjoker", "app-1")
+
+	r := NewReviewer(ReviewerOptions{
+		Client:   cs,
+		Resolver: NewResolver(ResolverOptions{Discovery: cs.Discovery(), Dynamic: dyn}),
+	})
+	match := Match(withClaims(t, oidc.Claims{"sub": "cyberjoker"}), r)
+
+	event := func(cid string) *corev1.Event {
+		ev := &corev1.Event{}
+		if len(cid) > 0 {
+			ev.ObjectMeta = metav1.ObjectMeta{Labels: map[string]string{"krateo.io/composition-id": cid}}
+		}
+		return ev
+	}
+
+	for cid, exp := range map[string]bool{"uid-1": true, "uid-2": false, "": false} {
+		if got := match(event(cid)); got != exp {
+			t.Errorf("%q: got %t, expected %t", cid, got, exp)
+		}
+	}
+}
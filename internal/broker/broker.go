@@ -0,0 +1,334 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	defaultBufferSize = 64
+)
+
+var (
+	// ErrClosed is returned by Live once the broker has been closed.
+	ErrClosed = errors.New("broker closed")
+	// ErrNotFed is returned by Live when no store feeds the broker.
+	ErrNotFed = errors.New("broker not fed by the store")
+)
+
+// Message is a notification delivered to every subscriber.
+type Message struct {
+	// ID is the store revision of the event; it is
+	// monotonically increasing so it orders the messages.
+	ID int64
+	// Key is the store key of the event.
+	Key string
+	// Event is the Kubernetes event carried by the message.
+	Event corev1.Event
+	// Updated is true if the event is a new version of
+	// an already notified one (i.e. its count increased).
+	Updated bool
+	// More is true if other messages with the same
+	// ID follow (i.e. events written in a batch).
+	More bool
+}
+
+// Policy tells what to do when a subscription queue is full.
+type Policy int
+
+const (
+	// DropOldest discards the oldest queued message to make
+	// room for the new one.
+	DropOldest Policy = iota
+	// DropNewest discards the new message.
+	DropNewest
+	// Disconnect terminates the subscription.
+	Disconnect
+)
+
+var policyNames = map[Policy]string{
+	DropOldest: "drop-oldest",
+	DropNewest: "drop-newest",
+	Disconnect: "disconnect",
+}
+
+func (p Policy) String() string {
+	if s, ok := policyNames[p]; ok {
+		return s
+	}
+	return fmt.Sprintf("Policy(%d)", int(p))
+}
+
+// ParsePolicy returns the Policy with the given name.
+func ParsePolicy(name string) (Policy, error) {
+	for p, s := range policyNames {
+		if s == name {
+			return p, nil
+		}
+	}
+	return DropOldest, fmt.Errorf("unknown overflow policy %q", name)
+}
+
+// Options are the options for the broker.
+type Options struct {
+	// BufferSize is the size of the queue of each subscription.
+	// Optional (64 by default).
+	BufferSize int
+	// Policy applies when a subscription queue is full.
+	// Optional (DropOldest by default).
+	Policy Policy
+}
+
+// Broker is an in-process publish/subscribe hub that fans out
+// every published message to all the active subscriptions.
+//
+// Publishing never blocks: each subscription has its own bounded
+// queue, so a slow subscriber cannot hold back the others.
+type Broker struct {
+	subs       map[*Subscription]struct{} // The set of active subscriptions.
+	closed     bool                       // True once the broker has been closed.
+	mu         sync.RWMutex               // Mutex for controlling concurrent access to the subscriptions.
+	lastID     atomic.Uint64              // Last assigned subscription identifier.
+	dropped    atomic.Uint64              // Messages dropped by all the subscriptions.
+	feeding    atomic.Bool                // True while Feed is running.
+	bufferSize int
+	policy     Policy
+}
+
+// New creates a new Broker instance.
+func New(opts Options) *Broker {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = defaultBufferSize
+	}
+
+	return &Broker{
+		subs:       make(map[*Subscription]struct{}),
+		bufferSize: opts.BufferSize,
+		policy:     opts.Policy,
+	}
+}
+
+// Subscribe registers a new subscription that will receive every
+// message published from now on; name is a human readable label.
+//
+// You must call Unsubscribe when you're done with it.
+func (b *Broker) Subscribe(name string) *Subscription {
+	s := &Subscription{
+		id:     b.lastID.Add(1),
+		name:   name,
+		policy: b.policy,
+		ch:     make(chan Message, b.bufferSize),
+		done:   make(chan struct{}),
+		total:  &b.dropped,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		s.close()
+		return s
+	}
+
+	b.subs[s] = struct{}{}
+	return s
+}
+
+// Unsubscribe removes the subscription from the broker.
+func (b *Broker) Unsubscribe(s *Subscription) {
+	s.close()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subs, s)
+}
+
+// Publish delivers the message to all the active subscriptions.
+func (b *Broker) Publish(msg Message) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for s := range b.subs {
+		s.offer(msg)
+	}
+}
+
+// Len returns the number of active subscriptions.
+func (b *Broker) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return len(b.subs)
+}
+
+// Live returns nil if the broker can deliver the events:
+// it is not closed and it is fed by the store.
+func (b *Broker) Live() error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+	if !b.feeding.Load() {
+		return ErrNotFed
+	}
+	return nil
+}
+
+// Dropped returns the number of messages discarded by
+// all the subscriptions, since the broker was created.
+func (b *Broker) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+// Stats describes the state of a subscription.
+type Stats struct {
+	ID        uint64 `json:"id"`
+	Name      string `json:"name"`
+	Queued    int    `json:"queued"`
+	Delivered uint64 `json:"delivered"`
+	Dropped   uint64 `json:"dropped"`
+}
+
+// Stats returns the state of all the active subscriptions.
+func (b *Broker) Stats() []Stats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	all := make([]Stats, 0, len(b.subs))
+	for s := range b.subs {
+		all = append(all, Stats{
+			ID:        s.id,
+			Name:      s.name,
+			Queued:    len(s.ch),
+			Delivered: s.Delivered(),
+			Dropped:   s.Dropped(),
+		})
+	}
+
+	return all
+}
+
+// Close terminates all the active subscriptions; no more
+// subscriptions will be accepted afterwards.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for s := range b.subs {
+		s.close()
+		delete(b.subs, s)
+	}
+}
+
+// Subscription receives the messages published to a Broker.
+type Subscription struct {
+	id         uint64
+	name       string
+	policy     Policy
+	ch         chan Message
+	done       chan struct{}
+	once       sync.Once
+	mu         sync.Mutex // Serializes the producers.
+	dropped    atomic.Uint64
+	delivered  atomic.Uint64
+	total      *atomic.Uint64 // The dropped messages of the broker.
+	overflowed atomic.Bool
+}
+
+// ID returns the unique identifier of the subscription.
+func (s *Subscription) ID() uint64 {
+	return s.id
+}
+
+// C returns the channel on which the messages are delivered.
+func (s *Subscription) C() <-chan Message {
+	return s.ch
+}
+
+// Done returns a channel that is closed when the subscription
+// has been terminated.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Dropped returns the number of messages that have
+// been discarded because the queue was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// MarkDelivered records that a message received
+// from the channel has been sent to the client.
+func (s *Subscription) MarkDelivered() {
+	s.delivered.Add(1)
+}
+
+// Delivered returns the number of messages
+// that have been sent to the client.
+func (s *Subscription) Delivered() uint64 {
+	return s.delivered.Load()
+}
+
+// Overflowed reports whether the subscription has been
+// terminated because its queue was full.
+func (s *Subscription) Overflowed() bool {
+	return s.overflowed.Load()
+}
+
+func (s *Subscription) offer(msg Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+
+	for {
+		select {
+		case s.ch <- msg:
+			return
+		default:
+		}
+
+		switch s.policy {
+		case DropNewest:
+			s.drop()
+			return
+		case Disconnect:
+			s.drop()
+			s.overflowed.Store(true)
+			s.close()
+			return
+		default:
+			// Make room and try again.
+			select {
+			case <-s.ch:
+				s.drop()
+			default:
+			}
+		}
+	}
+}
+
+func (s *Subscription) drop() {
+	s.dropped.Add(1)
+	if s.total != nil {
+		s.total.Add(1)
+	}
+}
+
+func (s *Subscription) close() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
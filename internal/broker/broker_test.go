@@ -0,0 +1,178 @@
+package broker
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBrokerFanOut(t *testing.T) {
+	b := New(Options{})
+	defer b.Close()
+
+	s1 := b.Subscribe("s1")
+	defer b.Unsubscribe(s1)
+	s2 := b.Subscribe("s2")
+	defer b.Unsubscribe(s2)
+
+	if l := b.Len(); l != 2 {
+		t.Fatalf("Found: %d subscriptions, expected: 2", l)
+	}
+
+	b.Publish(Message{
+		ID:  1,
+		Key: "event1",
+		Event: corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{Name: "event1"},
+		},
+	})
+
+	for i, s := range []*Subscription{s1, s2} {
+		select {
+		case msg := <-s.C():
+			if msg.ID != 1 {
+				t.Fatalf("subscription %d: got %d, expected %d", i, msg.ID, 1)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("subscription %d: message not delivered", i)
+		}
+	}
+}
+
+func TestBrokerUnsubscribe(t *testing.T) {
+	b := New(Options{})
+	defer b.Close()
+
+	s := b.Subscribe("s")
+	b.Unsubscribe(s)
+
+	if l := b.Len(); l != 0 {
+		t.Fatalf("Found: %d subscriptions, expected: 0", l)
+	}
+
+	select {
+	case <-s.Done():
+	default:
+		t.Fatal("subscription should be done")
+	}
+
+	// Publishing without subscribers must not block.
+	b.Publish(Message{ID: 1, Key: "event1"})
+}
+
+func TestBrokerClose(t *testing.T) {
+	b := New(Options{})
+
+	s := b.Subscribe("s")
+	b.Close()
+
+	select {
+	case <-s.Done():
+	default:
+		t.Fatal("subscription should be done")
+	}
+
+	s = b.Subscribe("s")
+	select {
+	case <-s.Done():
+	default:
+		t.Fatal("subscription to a closed broker should be done")
+	}
+}
+
+func TestBrokerOverflow(t *testing.T) {
+	tests := []struct {
+		policy     Policy
+		expected   []int64
+		dropped    uint64
+		overflowed bool
+	}{
+		{policy: DropOldest, expected: []int64{3, 4}, dropped: 2},
+		{policy: DropNewest, expected: []int64{1, 2}, dropped: 2},
+		{policy: Disconnect, expected: []int64{1, 2}, dropped: 1, overflowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			b := New(Options{BufferSize: 2, Policy: tt.policy})
+			defer b.Close()
+
+			s := b.Subscribe("slow")
+			defer b.Unsubscribe(s)
+
+			for i := int64(1); i <= 4; i++ {
+				b.Publish(Message{ID: i})
+			}
+
+			if got := s.Dropped(); got != tt.dropped {
+				t.Fatalf("dropped: got %d, expected %d", got, tt.dropped)
+			}
+
+			if got := s.Overflowed(); got != tt.overflowed {
+				t.Fatalf("overflowed: got %t, expected %t", got, tt.overflowed)
+			}
+
+			for _, id := range tt.expected {
+				if msg := <-s.C(); msg.ID != id {
+					t.Fatalf("got %d, expected %d", msg.ID, id)
+				}
+			}
+		})
+	}
+}
+
+func TestBrokerStats(t *testing.T) {
+	b := New(Options{BufferSize: 1, Policy: DropNewest})
+	defer b.Close()
+
+	s := b.Subscribe("slow")
+	defer b.Unsubscribe(s)
+
+	b.Publish(Message{ID: 1})
+	b.Publish(Message{ID: 2})
+
+	all := b.Stats()
+	if len(all) != 1 {
+		t.Fatalf("Found: %d stats, expected: 1", len(all))
+	}
+
+	exp := Stats{ID: s.ID(), Name: "slow", Queued: 1, Dropped: 1}
+	if all[0] != exp {
+		t.Fatalf("got %+v, expected %+v", all[0], exp)
+	}
+
+	<-s.C()
+	s.MarkDelivered()
+	if got := b.Stats()[0].Delivered; got != 1 {
+		t.Fatalf("Found: %d delivered, expected: 1", got)
+	}
+
+	// The dropped messages of the closed subscriptions are still counted.
+	b.Unsubscribe(s)
+	other := b.Subscribe("other")
+	defer b.Unsubscribe(other)
+	b.Publish(Message{ID: 3})
+	b.Publish(Message{ID: 4})
+
+	if got := b.Dropped(); got != 2 {
+		t.Fatalf("Found: %d dropped, expected: 2", got)
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for _, p := range []Policy{DropOldest, DropNewest, Disconnect} {
+		got, err := ParsePolicy(p.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != p {
+			t.Fatalf("got %v, expected %v", got, p)
+		}
+	}
+
+	if _, err := ParsePolicy("block"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
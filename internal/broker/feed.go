@@ -0,0 +1,24 @@
+package broker
+
+import (
+	"context"
+
+	"github.com/krateoplatformops/eventsse/internal/store"
+)
+
+// Feed publishes every event written to the store, no matter
+// which replica wrote it, until the context is done.
+func (b *Broker) Feed(ctx context.Context, w store.Watcher) {
+	b.feeding.Store(true)
+	defer b.feeding.Store(false)
+
+	for el := range w.Watch(ctx, 0) {
+		b.Publish(Message{
+			ID:      el.Revision,
+			Key:     el.Key,
+			Event:   el.Event,
+			Updated: el.Updated,
+			More:    el.More,
+		})
+	}
+}
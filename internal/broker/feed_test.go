@@ -0,0 +1,111 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/eventsse/internal/store"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ store.Watcher = (*mockWatcher)(nil)
+
+type mockWatcher struct {
+	data []store.Record
+}
+
+func (m *mockWatcher) Watch(ctx context.Context, rev int64) <-chan store.Record {
+	out := make(chan store.Record)
+	go func() {
+		defer close(out)
+		for _, el := range m.data {
+			select {
+			case out <- el:
+			case <-ctx.Done():
+				return
+			}
+		}
+		<-ctx.Done()
+	}()
+	return out
+}
+
+func TestBrokerFeed(t *testing.T) {
+	b := New(Options{})
+	defer b.Close()
+
+	s := b.Subscribe("s")
+	defer b.Unsubscribe(s)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.Feed(ctx, &mockWatcher{
+			data: []store.Record{
+				{Key: "events/comp-abc/1", Revision: 7, Event: corev1.Event{
+					ObjectMeta: metav1.ObjectMeta{Name: "event1"},
+				}},
+				{Key: "events/comp-abc/2", Revision: 9, Event: corev1.Event{
+					ObjectMeta: metav1.ObjectMeta{Name: "event2"},
+				}},
+			},
+		})
+	}()
+
+	for _, exp := range []int64{7, 9} {
+		select {
+		case msg := <-s.C():
+			if msg.ID != exp {
+				t.Fatalf("got %d, expected %d", msg.ID, exp)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("message %d not delivered", exp)
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the feed to stop")
+	}
+}
+
+func TestBrokerLive(t *testing.T) {
+	b := New(Options{})
+
+	if err := b.Live(); err != ErrNotFed {
+		t.Fatalf("got %v, expected %v", err, ErrNotFed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.Feed(ctx, &mockWatcher{})
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for b.Live() != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %v, expected the broker to be live", b.Live())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The feed stops with the context.
+	cancel()
+	<-done
+	if err := b.Live(); err != ErrNotFed {
+		t.Fatalf("got %v, expected %v", err, ErrNotFed)
+	}
+
+	b.Close()
+	if err := b.Live(); err != ErrClosed {
+		t.Fatalf("got %v, expected %v", err, ErrClosed)
+	}
+}
@@ -114,6 +114,15 @@ func (c *TTLCache[K, V]) Pop(key K) (V, bool) {
 	return item.value, true
 }
 
+// Len returns the number of items in the cache,
+// including the expired ones not yet removed.
+func (c *TTLCache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.items)
+}
+
 func (c *TTLCache[K, V]) Keys() []K {
 	c.mu.Lock()
 	defer c.mu.Unlock()
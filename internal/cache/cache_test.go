@@ -20,6 +20,10 @@ func TestTTLCache(t *testing.T) {
 		t.Fatalf("Found: %d keys, expected: 3", l)
 	}
 
+	if l := c.Len(); l != 3 {
+		t.Fatalf("Found: %d items, expected: 3", l)
+	}
+
 	// Wait for a while to allow some items to expire
 	time.Sleep(3 * time.Second)
 
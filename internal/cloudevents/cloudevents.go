@@ -0,0 +1,181 @@
+// Package cloudevents maps Kubernetes events to and from
+// CloudEvents 1.0 (https://github.com/cloudevents/spec),
+// in the JSON format, both in structured and binary mode.
+package cloudevents
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/krateoplatformops/eventsse/internal/events"
+	"github.com/krateoplatformops/eventsse/internal/filter"
+	"github.com/krateoplatformops/eventsse/internal/labels"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// SpecVersion is the supported CloudEvents specification version.
+	SpecVersion = "1.0"
+	// ContentType is the media type of a structured mode CloudEvent.
+	ContentType = "application/cloudevents+json"
+	// BatchContentType is the media type of a list of CloudEvents.
+	BatchContentType = "application/cloudevents-batch+json"
+	// Format is the value of the 'format' query parameter
+	// that asks for CloudEvents.
+	Format = "cloudevents"
+
+	// TypePrefix is the prefix of the type of the CloudEvents
+	// emitted by eventsse, followed by the lowercase event type
+	// (i.e. io.krateo.eventsse.warning).
+	TypePrefix = "io.krateo.eventsse."
+	// DefaultSource is the source of the emitted CloudEvents
+	// whose Kubernetes event does not tell the reporter.
+	DefaultSource = "eventsse"
+
+	headerPrefix = "Ce-"
+)
+
+// Event is a CloudEvent carrying a Kubernetes event in its data.
+type Event struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            *time.Time      `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+}
+
+// Validate checks the required attributes.
+func (ce *Event) Validate() error {
+	switch {
+	case ce.SpecVersion != SpecVersion:
+		return fmt.Errorf("unsupported CloudEvents specversion %q", ce.SpecVersion)
+	case len(ce.ID) == 0:
+		return errors.New("CloudEvent id is required")
+	case len(ce.Source) == 0:
+		return errors.New("CloudEvent source is required")
+	case len(ce.Type) == 0:
+		return errors.New("CloudEvent type is required")
+	case len(ce.Data) == 0:
+		return errors.New("CloudEvent data is required")
+	}
+
+	if ct := ce.DataContentType; len(ct) > 0 && !strings.HasPrefix(ct, "application/json") {
+		return fmt.Errorf("unsupported CloudEvent datacontenttype %q", ct)
+	}
+
+	return nil
+}
+
+// IsBinary reports whether the request carries a binary mode CloudEvent.
+func IsBinary(h http.Header) bool {
+	return len(h.Get(headerPrefix+"Specversion")) > 0
+}
+
+// FromBinary returns the binary mode CloudEvent made of
+// the given request headers and body.
+func FromBinary(h http.Header, body []byte) (Event, error) {
+	ce := Event{
+		SpecVersion:     h.Get(headerPrefix + "Specversion"),
+		ID:              h.Get(headerPrefix + "Id"),
+		Source:          h.Get(headerPrefix + "Source"),
+		Type:            h.Get(headerPrefix + "Type"),
+		Subject:         h.Get(headerPrefix + "Subject"),
+		DataContentType: h.Get("Content-Type"),
+		Data:            body,
+	}
+
+	if v := h.Get(headerPrefix + "Time"); len(v) > 0 {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return ce, fmt.Errorf("invalid CloudEvent time %q", v)
+		}
+		ce.Time = &t
+	}
+
+	return ce, ce.Validate()
+}
+
+// Decode returns the Kubernetes event carried by the CloudEvent,
+// either core/v1 or events.k8s.io/v1;
+// the CloudEvent id and time fill the missing uid and timestamps.
+func Decode(ce *Event) (corev1.Event, error) {
+	if err := ce.Validate(); err != nil {
+		return corev1.Event{}, err
+	}
+
+	ev, err := events.Decode(ce.Data)
+	if err != nil {
+		return ev, fmt.Errorf("CloudEvent data is not a Kubernetes event: %w", err)
+	}
+
+	if len(ev.UID) == 0 {
+		ev.UID = types.UID(ce.ID)
+	}
+	if ce.Time != nil && ev.FirstTimestamp.IsZero() && ev.LastTimestamp.IsZero() && ev.EventTime.IsZero() {
+		ev.FirstTimestamp = metav1.NewTime(*ce.Time)
+		ev.LastTimestamp = metav1.NewTime(*ce.Time)
+	}
+
+	return ev, nil
+}
+
+// id tells apart the versions of the same event, so that
+// the consumers deduplicating by id do not drop the updates:
+// the uid followed by the resource version, or by the count
+// if the event has no resource version.
+func id(ev *corev1.Event) string {
+	if len(ev.ResourceVersion) > 0 {
+		return fmt.Sprintf("%s-%s", ev.UID, ev.ResourceVersion)
+	}
+	if ev.Count > 0 {
+		return fmt.Sprintf("%s-%d", ev.UID, ev.Count)
+	}
+	return string(ev.UID)
+}
+
+// Encode returns the CloudEvent carrying the Kubernetes event.
+func Encode(ev *corev1.Event) (Event, error) {
+	dat, err := json.Marshal(ev)
+	if err != nil {
+		return Event{}, err
+	}
+
+	typ := "normal"
+	if len(ev.Type) > 0 {
+		typ = strings.ToLower(ev.Type)
+	}
+
+	source := DefaultSource
+	switch {
+	case len(ev.ReportingController) > 0:
+		source = ev.ReportingController
+	case len(ev.Source.Component) > 0:
+		source = ev.Source.Component
+	}
+
+	ce := Event{
+		SpecVersion:     SpecVersion,
+		ID:              id(ev),
+		Source:          source,
+		Type:            TypePrefix + typ,
+		Subject:         labels.CompositionID(ev),
+		DataContentType: "application/json",
+		Data:            dat,
+	}
+
+	if ts := filter.Timestamp(ev); !ts.IsZero() {
+		ts = ts.UTC()
+		ce.Time = &ts
+	}
+
+	return ce, nil
+}
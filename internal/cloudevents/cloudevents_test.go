@@ -0,0 +1,180 @@
+package cloudevents
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	ts := time.Date(2024, 7, 5, 7, 33, 9, 0, time.UTC)
+
+	ev := corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "fake-event-1",
+			UID:             "uid-1",
+			ResourceVersion: "100",
+			Labels:          map[string]string{"krateo.io/composition-id": "ABCDE12345"},
+		},
+		Type:          "Warning",
+		Source:        corev1.EventSource{Component: "composition-controller"},
+		LastTimestamp: metav1.NewTime(ts),
+	}
+
+	ce, err := Encode(&ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := Event{
+		SpecVersion:     "1.0",
+		ID:              "uid-1-100",
+		Source:          "composition-controller",
+		Type:            "io.krateo.eventsse.warning",
+		Subject:         "ABCDE12345",
+		Time:            &ts,
+		DataContentType: "application/json",
+	}
+	got := ce
+	got.Data = nil
+	if a, b := toJSON(t, got), toJSON(t, exp); a != b {
+		t.Fatalf("got %s, expected %s", a, b)
+	}
+
+	back, err := Decode(&ce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.Name != ev.Name || back.UID != ev.UID || !back.LastTimestamp.Equal(&ev.LastTimestamp) {
+		t.Fatalf("got %+v, expected %+v", back, ev)
+	}
+}
+
+func TestEncodeID(t *testing.T) {
+	tests := []struct {
+		name     string
+		rv       string
+		count    int32
+		expected string
+	}{
+		{name: "resource version", rv: "100", count: 2, expected: "uid-1-100"},
+		{name: "count", count: 2, expected: "uid-1-2"},
+		{name: "uid only", expected: "uid-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := corev1.Event{
+				ObjectMeta: metav1.ObjectMeta{UID: "uid-1", ResourceVersion: tt.rv},
+				Count:      tt.count,
+			}
+
+			ce, err := Encode(&ev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ce.ID != tt.expected {
+				t.Errorf("id: got %q, expected %q", ce.ID, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDecodeDefaults(t *testing.T) {
+	ts := time.Date(2024, 7, 5, 7, 33, 9, 0, time.UTC)
+
+	ce := Event{
+		SpecVersion: "1.0",
+		ID:          "ce-1",
+		Source:      "test",
+		Type:        "test",
+		Time:        &ts,
+		Data:        json.RawMessage(`{"metadata":{"name":"fake-event-1"},"type":"Normal"}`),
+	}
+
+	ev, err := Decode(&ce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.UID != "ce-1" {
+		t.Errorf("uid: got %q, expected %q", ev.UID, "ce-1")
+	}
+	if !ev.FirstTimestamp.Time.Equal(ts) || !ev.LastTimestamp.Time.Equal(ts) {
+		t.Errorf("timestamps: got %v and %v, expected %v", ev.FirstTimestamp, ev.LastTimestamp, ts)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	valid := Event{
+		SpecVersion: "1.0",
+		ID:          "ce-1",
+		Source:      "test",
+		Type:        "test",
+		Data:        json.RawMessage(`{"type":"Normal"}`),
+	}
+
+	tests := map[string]func(ce *Event){
+		"specversion":     func(ce *Event) { ce.SpecVersion = "0.3" },
+		"id":              func(ce *Event) { ce.ID = "" },
+		"source":          func(ce *Event) { ce.Source = "" },
+		"type":            func(ce *Event) { ce.Type = "" },
+		"data":            func(ce *Event) { ce.Data = nil },
+		"datacontenttype": func(ce *Event) { ce.DataContentType = "text/xml" },
+		"unknown field":   func(ce *Event) { ce.Data = json.RawMessage(`{"unknown":1}`) },
+	}
+
+	for name, change := range tests {
+		t.Run(name, func(t *testing.T) {
+			ce := valid
+			change(&ce)
+			if _, err := Decode(&ce); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestFromBinary(t *testing.T) {
+	h := http.Header{}
+	h.Set("ce-specversion", "1.0")
+	h.Set("ce-id", "ce-1")
+	h.Set("ce-source", "test")
+	h.Set("ce-type", "test")
+	h.Set("ce-time", "2024-07-05T07:33:09Z")
+	h.Set("Content-Type", "application/json")
+
+	if !IsBinary(h) {
+		t.Fatal("expected a binary mode CloudEvent")
+	}
+
+	ce, err := FromBinary(h, []byte(`{"type":"Normal"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ce.ID != "ce-1" || ce.Time == nil || string(ce.Data) != `{"type":"Normal"}` {
+		t.Fatalf("unexpected CloudEvent: %+v", ce)
+	}
+
+	h.Set("ce-time", "yesterday")
+	if _, err := FromBinary(h, []byte(`{}`)); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if IsBinary(http.Header{}) {
+		t.Fatal("expected no binary mode CloudEvent")
+	}
+}
+
+func toJSON(t *testing.T, v any) string {
+	t.Helper()
+
+	dat, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(dat)
+}
@@ -0,0 +1,115 @@
+// Package events normalizes the Kubernetes events, either
+// core/v1 or events.k8s.io/v1, to the core/v1 Event used
+// internally, and converts them back.
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+)
+
+const (
+	// APIVersionV1 is the apiVersion of the events.k8s.io/v1 events.
+	APIVersionV1 = "events.k8s.io/v1"
+)
+
+// Decode reads a Kubernetes event, telling the two representations
+// apart by the apiVersion; unknown fields are rejected.
+func Decode(data []byte) (corev1.Event, error) {
+	var meta struct {
+		APIVersion string `json:"apiVersion"`
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return corev1.Event{}, err
+	}
+
+	if meta.APIVersion == APIVersionV1 {
+		var ev eventsv1.Event
+		if err := strictUnmarshal(data, &ev); err != nil {
+			return corev1.Event{}, err
+		}
+		return FromV1(&ev), nil
+	}
+
+	var ev corev1.Event
+	if err := strictUnmarshal(data, &ev); err != nil {
+		return corev1.Event{}, err
+	}
+	return ev, nil
+}
+
+func strictUnmarshal(data []byte, dst any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return fmt.Errorf("invalid event: %w", err)
+	}
+	return nil
+}
+
+// FromV1 converts an events.k8s.io/v1 event to core/v1,
+// the same way the Kubernetes API server does.
+func FromV1(in *eventsv1.Event) corev1.Event {
+	out := corev1.Event{
+		ObjectMeta:          *in.ObjectMeta.DeepCopy(),
+		InvolvedObject:      in.Regarding,
+		Related:             in.Related.DeepCopy(),
+		Reason:              in.Reason,
+		Message:             in.Note,
+		Source:              in.DeprecatedSource,
+		FirstTimestamp:      in.DeprecatedFirstTimestamp,
+		LastTimestamp:       in.DeprecatedLastTimestamp,
+		Count:               in.DeprecatedCount,
+		Type:                in.Type,
+		EventTime:           in.EventTime,
+		Action:              in.Action,
+		ReportingController: in.ReportingController,
+		ReportingInstance:   in.ReportingInstance,
+	}
+	out.APIVersion = "v1"
+	out.Kind = "Event"
+
+	if in.Series != nil {
+		out.Series = &corev1.EventSeries{
+			Count:            in.Series.Count,
+			LastObservedTime: in.Series.LastObservedTime,
+		}
+	}
+
+	return out
+}
+
+// ToV1 converts a core/v1 event to events.k8s.io/v1.
+func ToV1(in *corev1.Event) eventsv1.Event {
+	out := eventsv1.Event{
+		ObjectMeta:               *in.ObjectMeta.DeepCopy(),
+		EventTime:                in.EventTime,
+		ReportingController:      in.ReportingController,
+		ReportingInstance:        in.ReportingInstance,
+		Action:                   in.Action,
+		Reason:                   in.Reason,
+		Regarding:                in.InvolvedObject,
+		Related:                  in.Related.DeepCopy(),
+		Note:                     in.Message,
+		Type:                     in.Type,
+		DeprecatedSource:         in.Source,
+		DeprecatedFirstTimestamp: in.FirstTimestamp,
+		DeprecatedLastTimestamp:  in.LastTimestamp,
+		DeprecatedCount:          in.Count,
+	}
+	out.APIVersion = APIVersionV1
+	out.Kind = "Event"
+
+	if in.Series != nil {
+		out.Series = &eventsv1.EventSeries{
+			Count:            in.Series.Count,
+			LastObservedTime: in.Series.LastObservedTime,
+		}
+	}
+
+	return out
+}
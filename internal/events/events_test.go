@@ -0,0 +1,77 @@
+package events
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDecodeV1(t *testing.T) {
+	dat, err := os.ReadFile("../../testdata/event.sample.eventsv1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ev, err := Decode(dat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ev.APIVersion != "v1" || ev.Kind != "Event" {
+		t.Errorf("type: got %s %s, expected v1 Event", ev.APIVersion, ev.Kind)
+	}
+	if ev.InvolvedObject.Name != "fake-service-3" {
+		t.Errorf("involvedObject.name: got %q, expected %q", ev.InvolvedObject.Name, "fake-service-3")
+	}
+	if ev.ReportingController != "krateo.io/composition-dynamic-controller" {
+		t.Errorf("reportingComponent: got %q", ev.ReportingController)
+	}
+	if ev.Message == "" || ev.Type != "Warning" || ev.Reason != "ReconcileError" {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+	if ev.Series == nil || ev.Series.Count != 3 {
+		t.Errorf("series: got %+v, expected count 3", ev.Series)
+	}
+	if ev.EventTime.IsZero() {
+		t.Error("eventTime: expected a value")
+	}
+
+	// And back.
+	v1 := ToV1(&ev)
+	back := FromV1(&v1)
+	if diff := cmp.Diff(ev, back); diff != "" {
+		t.Errorf("round trip (-want +got):\n%s", diff)
+	}
+	if v1.APIVersion != APIVersionV1 || v1.Note != ev.Message || v1.Regarding != ev.InvolvedObject {
+		t.Errorf("unexpected events.k8s.io/v1 event: %+v", v1)
+	}
+}
+
+func TestDecodeCoreV1(t *testing.T) {
+	dat, err := os.ReadFile("../../testdata/event.sample1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ev, err := Decode(dat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Name != "fake-event-1" || ev.InvolvedObject.Name != "fake-service-1" {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, s := range []string{
+		`{malformed json`,
+		`{"apiVersion":"v1","unknown":1}`,
+		// A core/v1 field in an events.k8s.io/v1 event.
+		`{"apiVersion":"events.k8s.io/v1","message":"hello"}`,
+	} {
+		if _, err := Decode([]byte(s)); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
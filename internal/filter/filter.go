@@ -0,0 +1,187 @@
+package filter
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/krateoplatformops/eventsse/internal/labels"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Filter selects events by composition, namespace, involved
+// object, reason, type, source component and time.
+//
+// The values given for the same field are OR-ed, while
+// the different fields are AND-ed; an empty field matches
+// any event.
+type Filter struct {
+	Compositions     []string
+	Namespaces       []string
+	InvolvedKinds    []string
+	InvolvedNames    []string
+	Reasons          []string
+	Types            []string
+	SourceComponents []string
+	// Since excludes the events that happened before it.
+	Since time.Time
+	// Until excludes the events that happened after it.
+	Until time.Time
+}
+
+// FromQuery builds a Filter from the URL query parameters.
+//
+// Every parameter can be repeated (?type=Normal&type=Warning)
+// or hold a comma separated list of values (?type=Normal,Warning).
+//
+// The involved object and source fields can also be given with
+// their field path (?involvedObject.kind=Pod&source.component=kubelet).
+//
+// The since and until parameters are either an RFC3339 time or
+// a duration relative to now (?since=15m).
+func FromQuery(q url.Values) (Filter, error) {
+	res := Filter{
+		Compositions:     values(q, "composition"),
+		Namespaces:       values(q, "namespace"),
+		InvolvedKinds:    values(q, "involvedKind", "involvedObject.kind"),
+		InvolvedNames:    values(q, "involvedName", "involvedObject.name"),
+		Reasons:          values(q, "reason"),
+		Types:            values(q, "type"),
+		SourceComponents: values(q, "source.component"),
+	}
+
+	var err error
+	res.Since, err = timeValue(q, "since")
+	if err != nil {
+		return res, err
+	}
+
+	res.Until, err = timeValue(q, "until")
+	if err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// IsEmpty reports whether the filter matches any event.
+func (f *Filter) IsEmpty() bool {
+	return len(f.Compositions) == 0 &&
+		len(f.Namespaces) == 0 &&
+		len(f.InvolvedKinds) == 0 &&
+		len(f.InvolvedNames) == 0 &&
+		len(f.Reasons) == 0 &&
+		len(f.Types) == 0 &&
+		len(f.SourceComponents) == 0 &&
+		f.Since.IsZero() &&
+		f.Until.IsZero()
+}
+
+// Fields returns the names of the query parameters
+// the filter is made of, in a fixed order.
+func (f *Filter) Fields() []string {
+	var res []string
+	for _, el := range []struct {
+		name string
+		set  bool
+	}{
+		{"composition", len(f.Compositions) > 0},
+		{"namespace", len(f.Namespaces) > 0},
+		{"involvedKind", len(f.InvolvedKinds) > 0},
+		{"involvedName", len(f.InvolvedNames) > 0},
+		{"reason", len(f.Reasons) > 0},
+		{"type", len(f.Types) > 0},
+		{"source.component", len(f.SourceComponents) > 0},
+		{"since", !f.Since.IsZero()},
+		{"until", !f.Until.IsZero()},
+	} {
+		if el.set {
+			res = append(res, el.name)
+		}
+	}
+	return res
+}
+
+// Match reports whether the event satisfies the filter.
+func (f *Filter) Match(obj *corev1.Event) bool {
+	if !f.Since.IsZero() && Timestamp(obj).Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && Timestamp(obj).After(f.Until) {
+		return false
+	}
+
+	return matchAny(f.Compositions, labels.CompositionID(obj)) &&
+		matchAny(f.Namespaces, obj.Namespace) &&
+		matchAny(f.InvolvedKinds, obj.InvolvedObject.Kind) &&
+		matchAny(f.InvolvedNames, obj.InvolvedObject.Name) &&
+		matchAny(f.Reasons, obj.Reason) &&
+		matchAny(f.Types, obj.Type) &&
+		matchAny(f.SourceComponents, obj.Source.Component)
+}
+
+// Timestamp returns the time of the most recent occurrence of the event.
+func Timestamp(obj *corev1.Event) time.Time {
+	switch {
+	case !obj.LastTimestamp.IsZero():
+		return obj.LastTimestamp.Time
+	case !obj.EventTime.IsZero():
+		return obj.EventTime.Time
+	case !obj.FirstTimestamp.IsZero():
+		return obj.FirstTimestamp.Time
+	default:
+		return obj.CreationTimestamp.Time
+	}
+}
+
+func timeValue(q url.Values, key string) (time.Time, error) {
+	v := strings.TrimSpace(q.Get(key))
+	if len(v) == 0 {
+		return time.Time{}, nil
+	}
+
+	t, err := parseTime(v)
+	if err != nil {
+		return t, fmt.Errorf("invalid '%s' parameter: %w", key, err)
+	}
+	return t, nil
+}
+
+func parseTime(v string) (time.Time, error) {
+	if d, err := time.ParseDuration(v); err == nil {
+		return time.Now().Add(-d.Abs()), nil
+	}
+
+	return time.Parse(time.RFC3339, v)
+}
+
+func matchAny(want []string, got string) bool {
+	if len(want) == 0 {
+		return true
+	}
+
+	for _, x := range want {
+		if strings.EqualFold(x, got) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// values collects the values of all the given keys.
+func values(q url.Values, keys ...string) []string {
+	var res []string
+	for _, key := range keys {
+		for _, el := range q[key] {
+			for _, x := range strings.Split(el, ",") {
+				x = strings.TrimSpace(x)
+				if len(x) > 0 {
+					res = append(res, x)
+				}
+			}
+		}
+	}
+	return res
+}
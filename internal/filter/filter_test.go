@@ -0,0 +1,196 @@
+package filter
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFromQuery(t *testing.T) {
+	q, err := url.ParseQuery("composition=abc&type=Normal,Warning&type=&reason=Synced&reason=Failed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := Filter{
+		Compositions: []string{"abc"},
+		Reasons:      []string{"Synced", "Failed"},
+		Types:        []string{"Normal", "Warning"},
+	}
+
+	got, err := FromQuery(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(exp, got); len(diff) > 0 {
+		t.Fatal(diff)
+	}
+
+	if diff := cmp.Diff([]string{"composition", "reason", "type"}, got.Fields()); len(diff) > 0 {
+		t.Fatal(diff)
+	}
+
+	empty, err := FromQuery(url.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !empty.IsEmpty() {
+		t.Fatal("expected an empty filter")
+	}
+	if got := empty.Fields(); len(got) > 0 {
+		t.Fatalf("expected no fields, got %v", got)
+	}
+}
+
+func TestFromQueryFieldPaths(t *testing.T) {
+	q, err := url.ParseQuery("involvedKind=Pod&involvedObject.kind=Service&involvedObject.name=web&source.component=kubelet")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := Filter{
+		InvolvedKinds:    []string{"Pod", "Service"},
+		InvolvedNames:    []string{"web"},
+		SourceComponents: []string{"kubelet"},
+	}
+
+	got, err := FromQuery(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(exp, got); len(diff) > 0 {
+		t.Fatal(diff)
+	}
+}
+
+func TestFromQuerySince(t *testing.T) {
+	got, err := FromQuery(url.Values{"since": {"2024-06-28T10:00:00Z"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := time.Date(2024, 6, 28, 10, 0, 0, 0, time.UTC); !got.Since.Equal(exp) {
+		t.Fatalf("since: got %v, expected %v", got.Since, exp)
+	}
+
+	got, err = FromQuery(url.Values{"since": {"15m"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(got.Since); d < 15*time.Minute || d > 16*time.Minute {
+		t.Fatalf("since: got %v, expected about 15 minutes ago", got.Since)
+	}
+
+	if _, err := FromQuery(url.Values{"since": {"yesterday"}}); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if _, err := FromQuery(url.Values{"until": {"tomorrow"}}); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-event",
+			Namespace: "demo-system",
+			Labels: map[string]string{
+				"krateo.io/composition-id": "abc",
+			},
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind: "FireworksApp",
+			Name: "fireworksapp-tgz",
+		},
+		Source: corev1.EventSource{
+			Component: "krateo",
+		},
+		Reason:        "CreatedExternalResource",
+		Type:          "Warning",
+		LastTimestamp: metav1.NewTime(time.Date(2024, 6, 28, 10, 0, 0, 0, time.UTC)),
+	}
+
+	tests := []struct {
+		name     string
+		filter   Filter
+		expected bool
+	}{
+		{
+			name:     "Empty filter",
+			filter:   Filter{},
+			expected: true,
+		},
+		{
+			name:     "Composition",
+			filter:   Filter{Compositions: []string{"abc"}},
+			expected: true,
+		},
+		{
+			name:     "Other composition",
+			filter:   Filter{Compositions: []string{"xyz"}},
+			expected: false,
+		},
+		{
+			name:     "Any of the values",
+			filter:   Filter{Types: []string{"Normal", "warning"}},
+			expected: true,
+		},
+		{
+			name: "All of the fields",
+			filter: Filter{
+				Namespaces:    []string{"demo-system"},
+				InvolvedKinds: []string{"FireworksApp"},
+				Reasons:       []string{"CreatedExternalResource"},
+			},
+			expected: true,
+		},
+		{
+			name:     "Happened after",
+			filter:   Filter{Since: time.Date(2024, 6, 28, 9, 0, 0, 0, time.UTC)},
+			expected: true,
+		},
+		{
+			name:     "Happened before",
+			filter:   Filter{Since: time.Date(2024, 6, 28, 11, 0, 0, 0, time.UTC)},
+			expected: false,
+		},
+		{
+			name:     "Happened before the end",
+			filter:   Filter{Until: time.Date(2024, 6, 28, 11, 0, 0, 0, time.UTC)},
+			expected: true,
+		},
+		{
+			name:     "Happened after the end",
+			filter:   Filter{Until: time.Date(2024, 6, 28, 9, 0, 0, 0, time.UTC)},
+			expected: false,
+		},
+		{
+			name: "Involved object and source",
+			filter: Filter{
+				InvolvedNames:    []string{"fireworksapp-tgz"},
+				SourceComponents: []string{"krateo", "kubelet"},
+			},
+			expected: true,
+		},
+		{
+			name: "One field does not match",
+			filter: Filter{
+				Namespaces: []string{"demo-system"},
+				Types:      []string{"Normal"},
+			},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(event); got != tt.expected {
+				t.Errorf("Match() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
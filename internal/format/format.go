@@ -0,0 +1,58 @@
+// Package format renders the stored events in
+// the representation asked by the readers.
+package format
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/krateoplatformops/eventsse/internal/cloudevents"
+	"github.com/krateoplatformops/eventsse/internal/events"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Format is an events representation.
+type Format string
+
+const (
+	// CoreV1 renders core/v1 events (the default).
+	CoreV1 Format = "v1"
+	// EventsV1 renders events.k8s.io/v1 events.
+	EventsV1 Format = events.APIVersionV1
+	// CloudEvents renders CloudEvents carrying core/v1 events.
+	CloudEvents Format = cloudevents.Format
+)
+
+// FromQuery returns the Format asked with the 'format' query parameter.
+func FromQuery(q url.Values) (Format, error) {
+	switch f := Format(q.Get("format")); f {
+	case "", CoreV1:
+		return CoreV1, nil
+	case EventsV1, CloudEvents:
+		return f, nil
+	default:
+		return CoreV1, fmt.Errorf("invalid 'format' parameter: %q", f)
+	}
+}
+
+// Convert returns the event in this representation.
+func (f Format) Convert(ev *corev1.Event) (any, error) {
+	switch f {
+	case EventsV1:
+		res := events.ToV1(ev)
+		return &res, nil
+	case CloudEvents:
+		res, err := cloudevents.Encode(ev)
+		return &res, err
+	default:
+		return ev, nil
+	}
+}
+
+// ListContentType is the media type of a list of events in this representation.
+func (f Format) ListContentType() string {
+	if f == CloudEvents {
+		return cloudevents.BatchContentType
+	}
+	return "application/json"
+}
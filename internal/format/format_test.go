@@ -0,0 +1,60 @@
+package format
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/krateoplatformops/eventsse/internal/cloudevents"
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+)
+
+func TestFromQuery(t *testing.T) {
+	tests := map[string]Format{
+		"":                 CoreV1,
+		"v1":               CoreV1,
+		"events.k8s.io/v1": EventsV1,
+		"cloudevents":      CloudEvents,
+	}
+
+	for val, exp := range tests {
+		got, err := FromQuery(url.Values{"format": []string{val}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != exp {
+			t.Errorf("%q: got %v, expected %v", val, got, exp)
+		}
+	}
+
+	if _, err := FromQuery(url.Values{"format": []string{"xml"}}); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	ev := corev1.Event{Message: "hello"}
+
+	for f, check := range map[Format]func(any) bool{
+		CoreV1: func(v any) bool {
+			x, ok := v.(*corev1.Event)
+			return ok && x.Message == "hello"
+		},
+		EventsV1: func(v any) bool {
+			x, ok := v.(*eventsv1.Event)
+			return ok && x.Note == "hello"
+		},
+		CloudEvents: func(v any) bool {
+			x, ok := v.(*cloudevents.Event)
+			return ok && x.SpecVersion == cloudevents.SpecVersion
+		},
+	} {
+		got, err := f.Convert(&ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !check(got) {
+			t.Errorf("%s: unexpected conversion %#v", f, got)
+		}
+	}
+}
@@ -1,24 +1,37 @@
 package getter
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"os"
-	"sort"
 	"strconv"
+	"strings"
 
+	"github.com/krateoplatformops/eventsse/internal/authz"
+	"github.com/krateoplatformops/eventsse/internal/filter"
+	"github.com/krateoplatformops/eventsse/internal/format"
 	"github.com/krateoplatformops/eventsse/internal/store"
 	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
 )
 
 const (
 	defaultLimit = 100
+
+	// ContinueHeader carries the token to fetch the next page.
+	ContinueHeader = "X-Continue"
 )
 
-func Events(storage store.Store, limit int) http.Handler {
+// Events returns the handler listing the stored events; if the
+// authorizer is not nil, only the events of the compositions
+// the user can read are listed.
+func Events(storage store.Store, limit int, authorizer authz.Authorizer) http.Handler {
 	h := &handler{
-		storage:  storage,
-		maxLimit: limit,
+		storage:    storage,
+		maxLimit:   limit,
+		authorizer: authorizer,
 	}
 
 	if h.maxLimit < 0 || h.maxLimit > defaultLimit {
@@ -31,8 +44,9 @@ func Events(storage store.Store, limit int) http.Handler {
 var _ http.Handler = (*handler)(nil)
 
 type handler struct {
-	storage  store.Store
-	maxLimit int
+	storage    store.Store
+	maxLimit   int
+	authorizer authz.Authorizer
 }
 
 // @title EventSSE API
@@ -42,12 +56,27 @@ type handler struct {
 
 // Events godoc
 // @Summary List all events related to a composition
-// @Description list composition events
+// @Description list composition events, most recent first
 // @ID events
 // @Produce  json
 // @Param composition path string false "Composition Identifier"
 // @Param limit query int false "Max number of events"
+// @Param continue query string false "Token returned by the previous page request"
+// @Param since query string false "Only events happened after this time (RFC3339 or duration, i.e. 15m)"
+// @Param until query string false "Only events happened before this time (RFC3339 or duration, i.e. 15m)"
+// @Param type query []string false "Event types (Normal, Warning)" collectionFormat(multi)
+// @Param reason query []string false "Event reasons" collectionFormat(multi)
+// @Param namespace query []string false "Event namespaces" collectionFormat(multi)
+// @Param involvedObject.kind query []string false "Kinds of the involved objects" collectionFormat(multi)
+// @Param involvedObject.name query []string false "Names of the involved objects" collectionFormat(multi)
+// @Param source.component query []string false "Components that reported the events" collectionFormat(multi)
+// @Param format query string false "Events representation: core/v1 (default), events.k8s.io/v1 or CloudEvents" Enums(v1, events.k8s.io/v1, cloudevents)
 // @Success 200 {array} types.Event
+// @Header 200 {string} X-Continue "Token to fetch the next page, if any"
+// @Param token query string false "Bearer token, for clients that cannot set headers (when authentication is enabled)"
+// @Failure 401 {string} string "Missing or invalid bearer token (when authentication is enabled)"
+// @Failure 403 {string} string "The user cannot get the composition (when authorization is enabled)"
+// @Security BearerAuth
 // @Router /events [get]
 func (r *handler) ServeHTTP(wri http.ResponseWriter, req *http.Request) {
 	log := zerolog.New(os.Stdout).With().
@@ -59,7 +88,15 @@ func (r *handler) ServeHTTP(wri http.ResponseWriter, req *http.Request) {
 	if len(comp) == 0 {
 		comp = req.URL.Query().Get("composition")
 	}
-	key := r.storage.PrepareKey("", comp)
+	key := r.storage.PreparePrefix(comp)
+
+	if r.authorizer != nil && len(comp) > 0 {
+		if status, err := authz.Check(req.Context(), r.authorizer, comp); err != nil {
+			log.Warn().Str("composition", comp).Msg(err.Error())
+			http.Error(wri, err.Error(), status)
+			return
+		}
+	}
 
 	limit := r.maxLimit
 	if v := req.URL.Query().Get("limit"); len(v) > 0 {
@@ -70,17 +107,60 @@ func (r *handler) ServeHTTP(wri http.ResponseWriter, req *http.Request) {
 	}
 
 	max := min(r.maxLimit, defaultLimit)
-	if limit < 0 || limit > max {
+	if limit <= 0 || limit > max {
 		limit = max
 	}
 
+	sel, err := filter.FromQuery(req.URL.Query())
+	if err != nil {
+		log.Error().Msg(err.Error())
+		http.Error(wri, err.Error(), http.StatusBadRequest)
+		return
+	}
+	out, err := format.FromQuery(req.URL.Query())
+	if err != nil {
+		log.Error().Msg(err.Error())
+		http.Error(wri, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The composition is already selected by the key prefix.
+	sel.Compositions = nil
+
+	opts := store.GetOptions{
+		// Ask for one more item to know if there is a next page.
+		Limit: limit + 1,
+	}
+	if !sel.IsEmpty() {
+		opts.Match = sel.Match
+	}
+	if r.authorizer != nil && len(comp) == 0 {
+		allowed := authz.Match(req.Context(), r.authorizer)
+		if match := opts.Match; match != nil {
+			opts.Match = func(ev *corev1.Event) bool { return match(ev) && allowed(ev) }
+		} else {
+			opts.Match = allowed
+		}
+	}
+
+	if v := req.URL.Query().Get("continue"); len(v) > 0 {
+		tok, err := decodeContinue(v)
+		if err == nil && tok.Prefix != key {
+			err = errors.New("continue token does not match the request")
+		}
+		if err != nil {
+			log.Error().Msg(err.Error())
+			http.Error(wri, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts.EndKey = tok.Start
+	}
+
 	log.Info().
 		Int("limit", limit).
 		Str("key", key).Msg("request received")
 
-	all, ok, err := r.storage.Get(key, store.GetOptions{
-		Limit: limit,
-	})
+	all, ok, err := r.storage.Get(key, opts)
 	if err != nil {
 		log.Error().Msg(err.Error())
 		http.Error(wri, err.Error(), http.StatusInternalServerError)
@@ -94,29 +174,70 @@ func (r *handler) ServeHTTP(wri http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	sort.Slice(all, func(i, j int) bool {
-		return all[i].LastTimestamp.Time.After(all[j].LastTimestamp.Time)
-	})
+	if len(all) > limit {
+		all = all[:limit]
+		wri.Header().Set(ContinueHeader, encodeContinue(continueToken{
+			Prefix: key,
+			Start:  all[len(all)-1].Key,
+		}))
+	}
+
+	res := make([]corev1.Event, len(all))
+	for i, el := range all {
+		res[i] = el.Event
+	}
 
 	log.Info().
 		Int("limit", limit).
-		Str("key", key).Msgf("[%d] events found", len(all))
-
-	wri.Header().Set("Access-Control-Allow-Origin", "*")
-	wri.Header().Set("Access-Control-Allow-Methods", "GET,OPTIONS")
-	wri.Header().Set("Access-Control-Expose-Headers", "Authorization,Content-Type")
-	wri.Header().Set("Access-Control-Allow-Headers", "Authorization,Content-Type")
-	wri.Header().Set("Access-Control-Allow-Credentials", "true")
-	wri.Header().Set("Content-Type", "application/json")
+		Str("key", key).Msgf("[%d] events found", len(res))
+
+	items := make([]any, len(res))
+	for i := range res {
+		if items[i], err = out.Convert(&res[i]); err != nil {
+			log.Error().Msg(err.Error())
+			http.Error(wri, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	wri.Header().Set("Content-Type", out.ListContentType())
 	wri.WriteHeader(http.StatusOK)
 
-	if err := json.NewEncoder(wri).Encode(all); err != nil {
+	if err := json.NewEncoder(wri).Encode(items); err != nil {
 		log.Error().Msg(err.Error())
 		http.Error(wri, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
+// continueToken resumes a listing, like the Kubernetes
+// list continuation: the next page is made of the keys
+// of the same prefix that come after Start.
+type continueToken struct {
+	Prefix string `json:"prefix"`
+	Start  string `json:"start"`
+}
+
+func encodeContinue(tok continueToken) string {
+	dat, _ := json.Marshal(&tok)
+	return base64.RawURLEncoding.EncodeToString(dat)
+}
+
+func decodeContinue(s string) (continueToken, error) {
+	var tok continueToken
+
+	dat, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return tok, errors.New("continue token is not valid")
+	}
+
+	if err := json.Unmarshal(dat, &tok); err != nil || len(tok.Start) == 0 {
+		return tok, errors.New("continue token is not valid")
+	}
+
+	return tok, nil
+}
+
 func min(a, b int) int {
 	if a > b {
 		return b
@@ -1,11 +1,17 @@
 package getter
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sort"
+	"strings"
 	"testing"
 
+	"github.com/krateoplatformops/eventsse/internal/cloudevents"
+	"github.com/krateoplatformops/eventsse/internal/labels"
 	"github.com/krateoplatformops/eventsse/internal/store"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -19,24 +25,67 @@ type MockStore struct {
 	data map[string]corev1.Event
 }
 
-func (m *MockStore) PrepareKey(uid, compositionID string) string {
-	return compositionID
+func (m *MockStore) PrepareKey(ev *corev1.Event) string {
+	return labels.CompositionID(ev)
 }
 
-func (m *MockStore) Set(key string, event *corev1.Event) error {
+func (m *MockStore) PreparePrefix(compositionID string) string {
+	if len(compositionID) == 0 {
+		return ""
+	}
+	return compositionID + "/"
+}
+
+func (m *MockStore) Set(key string, event *corev1.Event) (int64, error) {
 	if m.data == nil {
 		m.data = make(map[string]corev1.Event)
 	}
 	m.data[key] = *event
-	return nil
+	return int64(len(m.data)), nil
 }
 
-func (m *MockStore) Get(key string, opts store.GetOptions) (data []corev1.Event, found bool, err error) {
-	event, exists := m.data[key]
-	if !exists {
-		return nil, false, nil
+func (m *MockStore) Get(key string, opts store.GetOptions) (data []store.Record, found bool, err error) {
+	keys := []string{}
+	for k := range m.data {
+		if !strings.HasPrefix(k, key) {
+			continue
+		}
+		if len(opts.EndKey) > 0 && k >= opts.EndKey {
+			continue
+		}
+		keys = append(keys, k)
 	}
-	return []corev1.Event{event}, true, nil
+	sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+
+	for _, k := range keys {
+		if opts.Limit > 0 && len(data) == opts.Limit {
+			break
+		}
+		event := m.data[k]
+		if opts.Match != nil && !opts.Match(&event) {
+			continue
+		}
+		data = append(data, store.Record{Key: k, Event: event})
+	}
+
+	return data, len(data) > 0, nil
+}
+
+func (m *MockStore) Since(rev int64, limit int) ([]store.Record, error) {
+	return nil, nil
+}
+
+func (m *MockStore) Revision() (int64, error) {
+	return 0, nil
+}
+
+func (m *MockStore) Watch(ctx context.Context, rev int64) <-chan store.Record {
+	out := make(chan store.Record)
+	go func() {
+		defer close(out)
+		<-ctx.Done()
+	}()
+	return out
 }
 
 func (m *MockStore) Delete(key string) error {
@@ -44,6 +93,12 @@ func (m *MockStore) Delete(key string) error {
 	return nil
 }
 
+func (m *MockStore) DeleteRevision(key string, _ int64) (bool, error) {
+	_, ok := m.data[key]
+	delete(m.data, key)
+	return ok, nil
+}
+
 func (m *MockStore) SetTTL(_ int) {}
 
 func (m *MockStore) Close() error {
@@ -53,7 +108,7 @@ func (m *MockStore) Close() error {
 func TestEventsHandler(t *testing.T) {
 	handler := Events(&MockStore{
 		data: map[string]corev1.Event{
-			"comp1": {
+			"comp1/001": {
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "test-event-2",
 					Namespace: "demo-system",
@@ -61,7 +116,7 @@ func TestEventsHandler(t *testing.T) {
 				},
 				Message: "Test Event 1",
 			},
-			"comp2": {
+			"comp2/001": {
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "test-event-2",
 					Namespace: "demo-system",
@@ -70,7 +125,7 @@ func TestEventsHandler(t *testing.T) {
 				Message: "Test Event 2",
 			},
 		},
-	}, 10)
+	}, 10, nil)
 
 	t.Run("Valid request", func(t *testing.T) {
 		req, err := http.NewRequest(http.MethodGet, "/events?composition=comp1", nil)
@@ -109,3 +164,264 @@ func TestEventsHandler(t *testing.T) {
 		}
 	})
 }
+
+func TestEventsPagination(t *testing.T) {
+	sto := &MockStore{}
+	for _, k := range []string{"comp1/001", "comp1/002", "comp1/003", "comp1/004", "comp1/005", "comp2/001"} {
+		sto.Set(k, &corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{Name: k},
+		})
+	}
+
+	handler := Events(sto, 10, nil)
+
+	names := []string{}
+	cont := ""
+	for page := 0; page < 5; page++ {
+		url := "/events?composition=comp1&limit=2"
+		if len(cont) > 0 {
+			url += "&continue=" + cont
+		}
+
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 OK, got %v", rr.Code)
+		}
+
+		var events []corev1.Event
+		if err := json.NewDecoder(rr.Body).Decode(&events); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		for _, el := range events {
+			names = append(names, el.Name)
+		}
+
+		cont = rr.Header().Get("X-Continue")
+		if len(cont) == 0 {
+			break
+		}
+	}
+
+	exp := "comp1/005,comp1/004,comp1/003,comp1/002,comp1/001"
+	if got := strings.Join(names, ","); got != exp {
+		t.Fatalf("expected %s, got %s", exp, got)
+	}
+
+	t.Run("Continue token of another request", func(t *testing.T) {
+		tok := encodeContinue(continueToken{Prefix: "comp2/", Start: "comp2/001"})
+		req, err := http.NewRequest(http.MethodGet, "/events?composition=comp1&continue="+tok, nil)
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 Bad Request, got %v", rr.Code)
+		}
+	})
+
+	t.Run("Malformed continue token", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/events?continue=%21%21", nil)
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 Bad Request, got %v", rr.Code)
+		}
+	})
+}
+
+func TestEventsFilters(t *testing.T) {
+	sto := &MockStore{}
+	for i, typ := range []string{"Warning", "Normal", "Normal", "Warning", "Normal", "Warning"} {
+		k := fmt.Sprintf("comp1/%03d", i)
+		sto.Set(k, &corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{Name: k},
+			Type:       typ,
+		})
+	}
+
+	handler := Events(sto, 10, nil)
+
+	req, err := http.NewRequest(http.MethodGet, "/events?composition=comp1&limit=2&type=Warning", nil)
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 OK, got %v", rr.Code)
+	}
+
+	var events []corev1.Event
+	if err := json.NewDecoder(rr.Body).Decode(&events); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	for _, el := range events {
+		if el.Type != "Warning" {
+			t.Errorf("expected only warnings, got %s (%s)", el.Type, el.Name)
+		}
+	}
+
+	t.Run("Invalid time range", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/events?until=tomorrow", nil)
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 Bad Request, got %v", rr.Code)
+		}
+	})
+}
+
+func TestEventsCloudEvents(t *testing.T) {
+	sto := &MockStore{}
+	sto.Set("comp1/001", &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "event1",
+			UID:    "uid-1",
+			Labels: map[string]string{"krateo.io/composition-id": "comp1"},
+		},
+		Type: "Normal",
+	})
+
+	handler := Events(sto, 10, nil)
+
+	req, err := http.NewRequest(http.MethodGet, "/events?composition=comp1&format=cloudevents", nil)
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 OK, got %v", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/cloudevents-batch+json" {
+		t.Errorf("expected CloudEvents batch content type, got %q", ct)
+	}
+
+	var events []cloudevents.Event
+	if err := json.NewDecoder(rr.Body).Decode(&events); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if len(events) != 1 || events[0].ID != "uid-1" || events[0].Subject != "comp1" {
+		t.Fatalf("unexpected CloudEvents: %+v", events)
+	}
+
+	t.Run("events.k8s.io/v1", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/events?composition=comp1&format=events.k8s.io/v1", nil)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		var events []map[string]any
+		if err := json.NewDecoder(rr.Body).Decode(&events); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if len(events) != 1 || events[0]["apiVersion"] != "events.k8s.io/v1" {
+			t.Fatalf("unexpected events: %+v", events)
+		}
+	})
+
+	t.Run("Invalid format", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/events?format=xml", nil)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 Bad Request, got %v", rr.Code)
+		}
+	})
+}
+
+// MockAuthorizer allows the listed compositions only.
+type MockAuthorizer map[string]bool
+
+func (m MockAuthorizer) Allowed(_ context.Context, compositionID string) (bool, error) {
+	return m[compositionID], nil
+}
+
+func TestEventsAuthorization(t *testing.T) {
+	sto := &MockStore{}
+	for _, k := range []string{"comp1/001", "comp2/001", "comp1/002"} {
+		cid, _, _ := strings.Cut(k, "/")
+		sto.Set(k, &corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   k,
+				Labels: map[string]string{"krateo.io/composition-id": cid},
+			},
+		})
+	}
+	// Events not belonging to a composition are never listed.
+	sto.Set("other/001", &corev1.Event{ObjectMeta: metav1.ObjectMeta{Name: "other/001"}})
+
+	handler := Events(sto, 10, MockAuthorizer{"comp1": true})
+
+	tests := []struct {
+		url      string
+		status   int
+		expected []string
+	}{
+		{url: "/events?composition=comp1", status: http.StatusOK, expected: []string{"comp1/001", "comp1/002"}},
+		{url: "/events?composition=comp2", status: http.StatusForbidden},
+		{url: "/events", status: http.StatusOK, expected: []string{"comp1/001", "comp1/002"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+			if err != nil {
+				t.Fatalf("could not create request: %v", err)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, rr.Code)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var events []corev1.Event
+			if err := json.NewDecoder(rr.Body).Decode(&events); err != nil {
+				t.Fatalf("could not decode response: %v", err)
+			}
+			names := []string{}
+			for _, el := range events {
+				names = append(names, el.Name)
+			}
+			sort.Strings(names)
+			if fmt.Sprint(names) != fmt.Sprint(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, names)
+			}
+		})
+	}
+}
@@ -6,10 +6,18 @@ import (
 	"sync/atomic"
 )
 
-func Check(healthy *int32, serviceName string) http.Handler {
+// Build identifies the build of the service; it is
+// injected at build time with the linker flags.
+type Build struct {
+	Version string
+	Commit  string
+}
+
+func Check(healthy *int32, serviceName string, build Build) http.Handler {
 	return &handler{
 		healthy:     healthy,
 		serviceName: serviceName,
+		build:       build,
 	}
 }
 
@@ -18,6 +26,7 @@ var _ http.Handler = (*handler)(nil)
 type handler struct {
 	healthy     *int32
 	serviceName string
+	build       Build
 }
 
 // @title EventSSE API
@@ -41,8 +50,9 @@ func (r *handler) ServeHTTP(wri http.ResponseWriter, req *http.Request) {
 
 	if atomic.LoadInt32(r.healthy) == 1 {
 		data := map[string]string{
-			"name": r.serviceName,
-			//"version": r.version,
+			"name":    r.serviceName,
+			"version": r.build.Version,
+			"commit":  r.build.Commit,
 		}
 
 		wri.Header().Set("Content-Type", "application/json")
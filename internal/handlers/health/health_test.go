@@ -13,7 +13,7 @@ func TestHealthCheckHandler(t *testing.T) {
 	var healthy int32
 
 	// Configura il handler con lo stato di salute
-	handler := Check(&healthy, serviceName)
+	handler := Check(&healthy, serviceName, Build{Version: "v1.2.3", Commit: "abc1234"})
 
 	t.Run("Method Not Allowed", func(t *testing.T) {
 		req, err := http.NewRequest(http.MethodPost, "/health", nil)
@@ -54,6 +54,9 @@ func TestHealthCheckHandler(t *testing.T) {
 		if response["name"] != serviceName {
 			t.Errorf("expected service name %q, got %q", serviceName, response["name"])
 		}
+		if response["version"] != "v1.2.3" || response["commit"] != "abc1234" {
+			t.Errorf("expected the build v1.2.3 (abc1234), got %s (%s)", response["version"], response["commit"])
+		}
 	})
 
 	t.Run("Service Unhealthy", func(t *testing.T) {
@@ -0,0 +1,144 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultReadyTimeout bounds each readiness probe by default.
+const DefaultReadyTimeout = 2 * time.Second
+
+// errShuttingDown is reported once the shutdown has started.
+var errShuttingDown = errors.New("shutting down")
+
+// Probe checks a dependency the service needs to serve the requests.
+type Probe struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// ReadyOptions configures the readiness endpoint.
+type ReadyOptions struct {
+	// Healthy is 0 before the start and once the shutdown has started.
+	Healthy *int32
+	// Probes are run concurrently at each request.
+	Probes []Probe
+	// Timeout bounds each probe.
+	// Optional (DefaultReadyTimeout by default).
+	Timeout time.Duration
+}
+
+// CheckResult is the outcome of a readiness probe.
+type CheckResult struct {
+	Name     string `json:"name"`
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// ReadyResponse is the breakdown of the readiness probes.
+type ReadyResponse struct {
+	Ready  bool          `json:"ready"`
+	Checks []CheckResult `json:"checks"`
+}
+
+func Ready(opts ReadyOptions) http.Handler {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultReadyTimeout
+	}
+
+	return &readyHandler{
+		healthy: opts.Healthy,
+		probes:  opts.Probes,
+		timeout: opts.Timeout,
+	}
+}
+
+var _ http.Handler = (*readyHandler)(nil)
+
+type readyHandler struct {
+	healthy *int32
+	probes  []Probe
+	timeout time.Duration
+}
+
+// Ready godoc
+// @Summary Readiness Endpoint
+// @Description Checks the store connectivity, the broker liveness and the shutdown state
+// @ID ready
+// @Produce  json
+// @Success 200 {object} health.ReadyResponse
+// @Failure 503 {object} health.ReadyResponse
+// @Router /ready [get]
+func (r *readyHandler) ServeHTTP(wri http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		wri.Header().Set("Allow", "GET")
+		http.Error(wri, "405 method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	probes := append([]Probe{{
+		Name: "shutdown",
+		Check: func(context.Context) error {
+			if atomic.LoadInt32(r.healthy) != 1 {
+				return errShuttingDown
+			}
+			return nil
+		},
+	}}, r.probes...)
+
+	res := ReadyResponse{Ready: true, Checks: make([]CheckResult, len(probes))}
+
+	var wg sync.WaitGroup
+	for i := range probes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res.Checks[i] = r.run(req.Context(), probes[i])
+		}(i)
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	for _, el := range res.Checks {
+		if !el.OK {
+			res.Ready = false
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	wri.Header().Set("Content-Type", "application/json")
+	wri.WriteHeader(status)
+	json.NewEncoder(wri).Encode(&res)
+}
+
+// run runs the probe, giving up when the timeout expires
+// even if the probe does not honor the context.
+func (r *readyHandler) run(ctx context.Context, p Probe) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	res := CheckResult{Name: p.Name, OK: err == nil, Duration: time.Since(start).String()}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res
+}
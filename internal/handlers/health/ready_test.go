@@ -0,0 +1,116 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReady(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	fail := func(context.Context) error { return errors.New("boom") }
+	// hang ignores the context: the timeout must bound it anyway.
+	hang := func(context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}
+
+	tests := []struct {
+		name     string
+		healthy  int32
+		probes   []Probe
+		status   int
+		expected map[string]string
+	}{
+		{
+			name:     "ready",
+			healthy:  1,
+			probes:   []Probe{{"store", ok}, {"broker", ok}},
+			status:   http.StatusOK,
+			expected: map[string]string{"shutdown": "", "store": "", "broker": ""},
+		},
+		{
+			name:     "shutting down",
+			healthy:  0,
+			probes:   []Probe{{"store", ok}},
+			status:   http.StatusServiceUnavailable,
+			expected: map[string]string{"shutdown": "shutting down", "store": ""},
+		},
+		{
+			name:     "failing probe",
+			healthy:  1,
+			probes:   []Probe{{"store", fail}, {"broker", ok}},
+			status:   http.StatusServiceUnavailable,
+			expected: map[string]string{"shutdown": "", "store": "boom", "broker": ""},
+		},
+		{
+			name:     "timeout",
+			healthy:  1,
+			probes:   []Probe{{"store", hang}},
+			status:   http.StatusServiceUnavailable,
+			expected: map[string]string{"shutdown": "", "store": context.DeadlineExceeded.Error()},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			healthy := tt.healthy
+			handler := Ready(ReadyOptions{
+				Healthy: &healthy,
+				Probes:  tt.probes,
+				Timeout: 50 * time.Millisecond,
+			})
+
+			req, err := http.NewRequest(http.MethodGet, "/ready", nil)
+			if err != nil {
+				t.Fatalf("could not create request: %v", err)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, rr.Code)
+			}
+
+			var res ReadyResponse
+			if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+				t.Fatalf("could not parse response body: %v", err)
+			}
+			if res.Ready != (tt.status == http.StatusOK) {
+				t.Errorf("expected ready %t, got %t", tt.status == http.StatusOK, res.Ready)
+			}
+			if len(res.Checks) != len(tt.expected) {
+				t.Fatalf("expected %d checks, got %+v", len(tt.expected), res.Checks)
+			}
+			for _, el := range res.Checks {
+				exp, found := tt.expected[el.Name]
+				if !found {
+					t.Fatalf("unexpected check %q", el.Name)
+				}
+				if el.Error != exp || el.OK != (exp == "") {
+					t.Errorf("%s: expected error %q, got %+v", el.Name, exp, el)
+				}
+			}
+		})
+	}
+}
+
+func TestReadyMethodNotAllowed(t *testing.T) {
+	healthy := int32(1)
+	handler := Ready(ReadyOptions{Healthy: &healthy})
+
+	req, err := http.NewRequest(http.MethodPost, "/ready", nil)
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405 Method Not Allowed, got %v", rr.Code)
+	}
+}
@@ -0,0 +1,125 @@
+package publisher
+
+import (
+	"context"
+	"time"
+
+	"github.com/krateoplatformops/eventsse/internal/broker"
+	"github.com/krateoplatformops/eventsse/internal/labels"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// gateQueue is the number of live events of a stream
+	// queued while their authorization is being decided.
+	gateQueue = 64
+
+	// decisionTTL is how long a stream reuses the authorization
+	// of a composition; the authorizer caches it too, this only
+	// spares a call for each event.
+	decisionTTL = 10 * time.Second
+)
+
+// decision is the authorization of the events of a composition,
+// done once it has been decided.
+type decision struct {
+	done chan struct{}
+	ok   bool
+	at   time.Time
+}
+
+// queued is a live event of a stream, together with the
+// authorization it is waiting for (nil if none).
+type queued struct {
+	msg broker.Message
+	dec *decision
+}
+
+// allowed waits for the authorization of the event.
+func (q *queued) allowed(ctx context.Context) bool {
+	if q.dec == nil {
+		return true
+	}
+
+	select {
+	case <-q.dec.done:
+		return q.dec.ok
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// gate queues the live events of the subscription selected by match,
+// written after the given revision; the authorization is decided for
+// each composition as its events are queued, out of the write loop,
+// so that a slow decision does not hold back the subscription.
+//
+// The events keep their order: a stream resumes from the last
+// event id it received.
+func (r *handler) gate(ctx context.Context, sub *broker.Subscription, match func(*corev1.Event) bool, sent int64) <-chan queued {
+	out := make(chan queued, gateQueue)
+
+	go func() {
+		decisions := map[string]*decision{}
+
+		for {
+			var msg broker.Message
+			select {
+			case <-ctx.Done():
+				return
+			case <-sub.Done():
+				return
+			case msg = <-sub.C():
+			}
+
+			if msg.ID <= sent {
+				// Already delivered by the replay.
+				continue
+			}
+			if !match(&msg.Event) {
+				continue
+			}
+
+			q := queued{msg: msg}
+			if r.authorizer != nil {
+				q.dec = r.decide(ctx, decisions, labels.CompositionID(&msg.Event))
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-sub.Done():
+				return
+			case out <- q:
+			}
+		}
+	}()
+
+	return out
+}
+
+// decide returns the authorization of the events of the composition,
+// starting to decide it unless it is known or already in progress.
+func (r *handler) decide(ctx context.Context, decisions map[string]*decision, cid string) *decision {
+	now := time.Now()
+	if dec, ok := decisions[cid]; ok && now.Sub(dec.at) < decisionTTL {
+		return dec
+	}
+
+	dec := &decision{done: make(chan struct{}), at: now}
+	decisions[cid] = dec
+
+	if len(cid) == 0 {
+		// Only the events of the compositions are authorized.
+		close(dec.done)
+		return dec
+	}
+
+	go func() {
+		ok, err := r.authorizer.Allowed(ctx, cid)
+		dec.ok = err == nil && ok
+		close(dec.done)
+	}()
+
+	return dec
+}
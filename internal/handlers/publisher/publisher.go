@@ -2,26 +2,63 @@ package publisher
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/krateoplatformops/eventsse/internal/cache"
+	"github.com/krateoplatformops/eventsse/internal/authz"
+	"github.com/krateoplatformops/eventsse/internal/broker"
+	"github.com/krateoplatformops/eventsse/internal/filter"
+	"github.com/krateoplatformops/eventsse/internal/format"
 	"github.com/krateoplatformops/eventsse/internal/labels"
+	"github.com/krateoplatformops/eventsse/internal/metrics"
+	"github.com/krateoplatformops/eventsse/internal/store"
 	"github.com/rs/zerolog"
 	corev1 "k8s.io/api/core/v1"
 )
 
-func SSE(ttlCache *cache.TTLCache[string, corev1.Event]) http.Handler {
+const (
+	// historyPageSize is the number of events fetched
+	// from the store at each step of a replay.
+	historyPageSize = 100
+)
+
+type SSEOptions struct {
+	Broker *broker.Broker
+	Store  store.Store
+	// KeepAlive is the interval between two keepalive comments
+	// sent to idle clients; zero disables them.
+	KeepAlive time.Duration
+	// Retry is the reconnection time advertised to the clients;
+	// zero leaves the client default.
+	Retry time.Duration
+	// Authorizer, if set, selects the events of the
+	// compositions the user can read.
+	Authorizer authz.Authorizer
+}
+
+func SSE(opts SSEOptions) http.Handler {
 	return &handler{
-		ttlCache: ttlCache,
+		broker:     opts.Broker,
+		store:      opts.Store,
+		keepAlive:  opts.KeepAlive,
+		retry:      opts.Retry,
+		authorizer: opts.Authorizer,
 	}
 }
 
 var _ http.Handler = (*handler)(nil)
 
 type handler struct {
-	ttlCache *cache.TTLCache[string, corev1.Event]
+	broker     *broker.Broker
+	store      store.Store
+	keepAlive  time.Duration
+	retry      time.Duration
+	authorizer authz.Authorizer
 }
 
 // @title EventSSE API
@@ -34,7 +71,21 @@ type handler struct {
 // @Description Get available events notifications
 // @ID notifications
 // @Produce  json
+// @Param Last-Event-ID header int false "Resume the stream after this event id"
+// @Param lastEventId query int false "Resume the stream after this event id (for clients that cannot set headers)"
+// @Param composition query []string false "Composition identifiers" collectionFormat(multi)
+// @Param namespace query []string false "Event namespaces" collectionFormat(multi)
+// @Param involvedKind query []string false "Kinds of the involved objects" collectionFormat(multi)
+// @Param reason query []string false "Event reasons" collectionFormat(multi)
+// @Param type query []string false "Event types (Normal, Warning)" collectionFormat(multi)
+// @Param since query string false "Only events happened after this time (RFC3339 or duration, i.e. 15m)"
+// @Param backfill query int false "Send the last N stored events before the live ones"
+// @Param format query string false "Events representation: core/v1 (default), events.k8s.io/v1 or CloudEvents" Enums(v1, events.k8s.io/v1, cloudevents)
 // @Success 200 {array} types.Event
+// @Param token query string false "Bearer token, for clients that cannot set headers (when authentication is enabled)"
+// @Failure 401 {string} string "Missing or invalid bearer token (when authentication is enabled)"
+// @Failure 403 {string} string "The user cannot get the compositions (when authorization is enabled)"
+// @Security BearerAuth
 // @Router /notifications [get]
 func (r *handler) ServeHTTP(wri http.ResponseWriter, req *http.Request) {
 	log := zerolog.New(os.Stdout).With().
@@ -50,54 +101,284 @@ func (r *handler) ServeHTTP(wri http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	wri.Header().Set("Access-Control-Allow-Origin", "*")
-	wri.Header().Set("Access-Control-Allow-Methods", "GET,OPTIONS")
-	wri.Header().Set("Access-Control-Expose-Headers", "Authorization,Content-Type")
-	wri.Header().Set("Access-Control-Allow-Headers", "Authorization,Content-Type")
-	wri.Header().Set("Access-Control-Allow-Credentials", "true")
+	sel, err := filter.FromQuery(req.URL.Query())
+	if err != nil {
+		log.Error().Msg(err.Error())
+		http.Error(wri, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	backfill := 0
+	if v := req.URL.Query().Get("backfill"); len(v) > 0 {
+		backfill, err = strconv.Atoi(v)
+		if err != nil || backfill < 0 {
+			msg := fmt.Sprintf("invalid 'backfill' parameter: %q", v)
+			log.Error().Msg(msg)
+			http.Error(wri, msg, http.StatusBadRequest)
+			return
+		}
+	}
+
+	out, err := format.FromQuery(req.URL.Query())
+	if err != nil {
+		log.Error().Msg(err.Error())
+		http.Error(wri, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	match := sel.Match
+	if r.authorizer != nil {
+		for _, cid := range sel.Compositions {
+			if status, err := authz.Check(req.Context(), r.authorizer, cid); err != nil {
+				log.Warn().Str("composition", cid).Msg(err.Error())
+				http.Error(wri, err.Error(), status)
+				return
+			}
+		}
+
+		allowed := authz.Match(req.Context(), r.authorizer)
+		match = func(ev *corev1.Event) bool { return sel.Match(ev) && allowed(ev) }
+	}
 
 	wri.Header().Set("X-Accel-Buffering", "no")
 	wri.Header().Set("Content-Type", "text/event-stream")
 	wri.Header().Set("Cache-Control", "no-cache")
 	wri.Header().Set("Connection", "keep-alive")
 
-	ctx := req.Context()
+	// Streams are long-lived: lift the server wide deadlines.
+	rc := http.NewResponseController(wri)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Warn().Err(err).Msg("Clearing read deadline")
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Warn().Err(err).Msg("Clearing write deadline")
+	}
 
-	select {
-	case <-ctx.Done():
-		f.Flush()
-		return
-	default:
-		for _, k := range r.ttlCache.Keys() {
-			obj, ok := r.ttlCache.Get(k)
-			if !ok {
-				log.Warn().Str("key", k).Msg("Event not found in cache, maybe expired?")
+	// Subscribe before reading the history, so that no event
+	// written in the meantime gets lost.
+	sub := r.broker.Subscribe(req.RemoteAddr)
+	conns := metrics.SSEConnections.WithLabelValues(metrics.FilterLabel(sel.Fields()))
+	conns.Inc()
+	defer func() {
+		conns.Dec()
+		r.broker.Unsubscribe(sub)
+		log.Debug().
+			Uint64("subscription", sub.ID()).
+			Uint64("dropped", sub.Dropped()).
+			Msg("SSE subscription closed")
+	}()
+
+	wri.WriteHeader(http.StatusOK)
+	if r.retry > 0 {
+		fmt.Fprintf(wri, "retry: %d\n\n", r.retry.Milliseconds())
+	}
+	f.Flush()
+
+	log.Debug().Msg("SSE client connected")
+
+	// Events up to this id have already been dealt with.
+	var sent int64
+
+	id, resume := lastEventID(req)
+	if resume {
+		// An id ahead of the store (i.e. the memory store restarted, or
+		// the client comes from a replica with another store) would hold
+		// back every live event: the client subscribes afresh instead.
+		cur, err := r.store.Revision()
+		if err != nil || id > cur {
+			log.Warn().Err(err).
+				Int64("lastEventId", id).
+				Int64("revision", cur).
+				Msg("Last event id not found in the store, ignoring it")
+			resume = false
+		}
+	}
+
+	var all []broker.Message
+	if resume {
+		log.Info().Int64("lastEventId", id).Msg("Replaying missed events")
+		all, sent, err = r.history(id, match)
+	} else if backfill > 0 || !sel.Since.IsZero() {
+		log.Info().Int("backfill", backfill).Msg("Backfilling stored events")
+		prefix := r.store.PreparePrefix("")
+		if len(sel.Compositions) == 1 {
+			prefix = r.store.PreparePrefix(sel.Compositions[0])
+		}
+		all, sent, err = r.recent(prefix, backfill, match)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Reading stored events")
+	}
+
+	for _, msg := range all {
+		if err := send(wri, msg, out); err != nil {
+			log.Error().Err(err).Str("key", msg.Key).Msg("Sending SSE")
+			continue
+		}
+		sub.MarkDelivered()
+		metrics.SSEDelivered.Inc()
+	}
+	f.Flush()
+
+	var tick <-chan time.Time
+	if r.keepAlive > 0 {
+		ticker := time.NewTicker(r.keepAlive)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	ctx := req.Context()
+	live := r.gate(ctx, sub, sel.Match, sent)
+	for {
+		select {
+		case <-tick:
+			fmt.Fprint(wri, ":keepalive\n\n")
+			f.Flush()
+		case <-ctx.Done():
+			log.Debug().Msg("SSE client disconnected")
+			return
+		case <-sub.Done():
+			if sub.Overflowed() {
+				log.Warn().
+					Uint64("subscription", sub.ID()).
+					Msg("SSE client too slow, disconnecting")
+				// Clients can reconnect with the last received id
+				// to get the missed events.
+				fmt.Fprintf(wri, "event: overflow\ndata: {\"dropped\":%d}\n\n", sub.Dropped())
+				f.Flush()
+				return
+			}
+			log.Debug().Msg("SSE subscription terminated")
+			return
+		case q := <-live:
+			if !q.allowed(ctx) {
 				continue
 			}
 
-			dat, err := json.Marshal(&obj)
-			if err != nil {
-				log.Error().Str("key", k).Msg("Encoding Event as JSON string")
+			msg := q.msg
+			if err := send(wri, msg, out); err != nil {
+				log.Error().Err(err).Str("key", msg.Key).Msg("Sending SSE")
 				continue
 			}
+			f.Flush()
+			sub.MarkDelivered()
+			metrics.SSEDelivered.Inc()
 
-			log.Info().Str("key", k).Msg("Sending SSE")
+			log.Info().Str("key", msg.Key).Int64("id", msg.ID).Msg("SSE Done")
+		}
+	}
+}
 
-			fmt.Fprintln(wri, "event: krateo")
-			fmt.Fprintf(wri, "id: %s\n", k)
-			fmt.Fprintf(wri, "data: %s\n\n", string(dat))
+// history returns, oldest first, the stored events written after the
+// given revision selected by match.
+//
+// The highest revision read from the store is returned too.
+func (r *handler) history(rev int64, match func(*corev1.Event) bool) ([]broker.Message, int64, error) {
+	var res []broker.Message
+	for {
+		all, err := r.store.Since(rev, historyPageSize)
+		if err != nil {
+			return res, rev, err
+		}
 
-			cid := labels.CompositionID(&obj)
-			if len(cid) > 0 {
-				fmt.Fprintf(wri, "event: %s\n", cid)
-				fmt.Fprintf(wri, "id: %s\n", k)
-				fmt.Fprintf(wri, "data: %s\n\n", string(dat))
+		for _, el := range all {
+			rev = el.Revision
+			if !match(&el.Event) {
+				continue
 			}
 
-			f.Flush()
+			res = append(res, broker.Message{ID: el.Revision, Key: el.Key, Event: el.Event, Updated: el.Updated, More: el.More})
+		}
 
-			r.ttlCache.Remove(k)
-			log.Info().Str("key", k).Msg("SSE Done")
+		if len(all) < historyPageSize {
+			return res, rev, nil
 		}
 	}
 }
+
+// recent returns, oldest first, the last stored events under the
+// given prefix selected by match (all of them if last is zero);
+// they are read newest first from the time index, so that only
+// the requested events are fetched.
+//
+// The revision of the store before the read is returned too: the
+// events written up to it are either returned or older than them.
+func (r *handler) recent(prefix string, last int, match func(*corev1.Event) bool) ([]broker.Message, int64, error) {
+	rev, err := r.store.Revision()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	all, _, err := r.store.Get(prefix, store.GetOptions{Limit: last, Match: match})
+	if err != nil {
+		return nil, rev, err
+	}
+
+	res := make([]broker.Message, len(all))
+	for i, el := range all {
+		res[len(all)-1-i] = broker.Message{ID: el.Revision, Key: el.Key, Event: el.Event, Updated: el.Updated, More: el.More}
+		rev = max(rev, el.Revision)
+	}
+	return res, rev, nil
+}
+
+// lastEventID returns the id of the last event received by a
+// reconnecting client, if any.
+func lastEventID(req *http.Request) (int64, bool) {
+	val := req.Header.Get("Last-Event-ID")
+	if len(val) == 0 {
+		val = req.URL.Query().Get("lastEventId")
+	}
+	if len(val) == 0 {
+		return 0, false
+	}
+
+	id, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64)
+	if err != nil || id < 0 {
+		return 0, false
+	}
+
+	return id, true
+}
+
+func send(wri http.ResponseWriter, msg broker.Message, out format.Format) error {
+	obj, err := out.Convert(&msg.Event)
+	if err != nil {
+		return err
+	}
+
+	dat, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+
+	// Resuming from the id of an event followed by others with the
+	// same revision would skip them: such an event gets the previous
+	// id, so that the whole transaction is replayed instead.
+	id := msg.ID
+	if msg.More {
+		id--
+	}
+
+	// A new version of an already notified event (i.e. with an
+	// increased count and lastTimestamp) is not a new notification.
+	if msg.Updated {
+		fmt.Fprintln(wri, "event: updated")
+		fmt.Fprintf(wri, "id: %d\n", id)
+		fmt.Fprintf(wri, "data: %s\n\n", string(dat))
+		return nil
+	}
+
+	fmt.Fprintln(wri, "event: krateo")
+	fmt.Fprintf(wri, "id: %d\n", id)
+	fmt.Fprintf(wri, "data: %s\n\n", string(dat))
+
+	cid := labels.CompositionID(&msg.Event)
+	if len(cid) > 0 {
+		fmt.Fprintf(wri, "event: %s\n", cid)
+		fmt.Fprintf(wri, "id: %d\n", id)
+		fmt.Fprintf(wri, "data: %s\n\n", string(dat))
+	}
+
+	return nil
+}
@@ -1,58 +1,853 @@
 package publisher
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
-	"github.com/krateoplatformops/eventsse/internal/cache"
+	"github.com/krateoplatformops/eventsse/internal/broker"
+	"github.com/krateoplatformops/eventsse/internal/cloudevents"
+	"github.com/krateoplatformops/eventsse/internal/labels"
+	"github.com/krateoplatformops/eventsse/internal/store"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+var _ store.Store = (*MockStore)(nil)
+
+// MockStore is a store mock that keeps the events ordered by revision.
+type MockStore struct {
+	data []store.Record
+	mu   sync.Mutex
+	// sinceCalls counts the reads of the history.
+	sinceCalls int
+}
+
+func (m *MockStore) PrepareKey(ev *corev1.Event) string {
+	return string(ev.UID) + ":" + labels.CompositionID(ev)
+}
+
+func (m *MockStore) PreparePrefix(compositionID string) string {
+	return ":" + compositionID
+}
+
+func (m *MockStore) Set(key string, event *corev1.Event) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rev := int64(len(m.data) + 1)
+	m.data = append(m.data, store.Record{Key: key, Revision: rev, Event: *event})
+	return rev, nil
+}
+
+// Get returns the records newest first, whatever the key.
+func (m *MockStore) Get(key string, opts store.GetOptions) (data []store.Record, found bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.data) - 1; i >= 0; i-- {
+		if opts.Match != nil && !opts.Match(&m.data[i].Event) {
+			continue
+		}
+		data = append(data, m.data[i])
+		if opts.Limit > 0 && len(data) == opts.Limit {
+			break
+		}
+	}
+	return data, len(data) > 0, nil
+}
+
+func (m *MockStore) Since(rev int64, limit int) ([]store.Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sinceCalls++
+	var res []store.Record
+	for _, el := range m.data {
+		if el.Revision <= rev {
+			continue
+		}
+		if limit > 0 && len(res) == limit {
+			break
+		}
+		res = append(res, el)
+	}
+	return res, nil
+}
+
+func (m *MockStore) Revision() (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.data) == 0 {
+		return 0, nil
+	}
+	return m.data[len(m.data)-1].Revision, nil
+}
+
+func (m *MockStore) Watch(ctx context.Context, rev int64) <-chan store.Record {
+	out := make(chan store.Record)
+	go func() {
+		defer close(out)
+		<-ctx.Done()
+	}()
+	return out
+}
+
+func (m *MockStore) Delete(key string) error {
+	return nil
+}
+
+func (m *MockStore) DeleteRevision(key string, _ int64) (bool, error) {
+	return false, nil
+}
+
+func (m *MockStore) SetTTL(_ int) {}
+
+func (m *MockStore) Close() error {
+	return nil
+}
+
 func TestServeHTTP(t *testing.T) {
 
-	t.Run("Send events from cache", func(t *testing.T) {
+	t.Run("Broadcast events to every client", func(t *testing.T) {
 		const exp = `event: krateo
-id: event1
+id: 1
 data: {"metadata":{"name":"event1","namespace":"demo-system","creationTimestamp":null},"involvedObject":{},"source":{},"firstTimestamp":null,"lastTimestamp":null,"eventTime":null,"reportingComponent":"","reportingInstance":""}
 
 `
 
-		ttlCache := cache.NewTTL[string, corev1.Event]()
-		defer func() {
-			ttlCache.Clear()
+		brk := broker.New(broker.Options{})
+		defer brk.Close()
+
+		srv := httptest.NewServer(SSE(SSEOptions{Broker: brk, Store: &MockStore{}}))
+		defer srv.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		clients := make([]*bufio.Reader, 2)
+		for i := range clients {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/notifications", nil)
+			if err != nil {
+				t.Fatalf("could not create request: %v", err)
+			}
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("could not send request: %v", err)
+			}
+			defer res.Body.Close()
+
+			if res.StatusCode != http.StatusOK {
+				t.Errorf("expected status 200 OK, got %v", res.StatusCode)
+			}
+
+			contentType := res.Header.Get("Content-Type")
+			if contentType != "text/event-stream" {
+				t.Errorf("expected Content-Type 'text/event-stream', got %v", contentType)
+			}
+
+			clients[i] = bufio.NewReader(res.Body)
+		}
+
+		for brk.Len() != len(clients) {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		brk.Publish(broker.Message{
+			ID:  1,
+			Key: "event1",
+			Event: corev1.Event{
+				ObjectMeta: v1.ObjectMeta{
+					Name: "event1", Namespace: "demo-system",
+				},
+			},
+		})
+
+		for i, rd := range clients {
+			got, err := readFrame(rd)
+			if err != nil {
+				t.Fatalf("client %d: could not read frame: %v", i, err)
+			}
+			if got != exp {
+				t.Errorf("client %d: expected response body %v, got %v", i, exp, got)
+			}
+		}
+	})
+
+	t.Run("Stream ends when the broker is closed", func(t *testing.T) {
+		brk := broker.New(broker.Options{})
+
+		req, err := http.NewRequest(http.MethodGet, "/notifications", nil)
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			SSE(SSEOptions{Broker: brk, Store: &MockStore{}}).ServeHTTP(httptest.NewRecorder(), req)
 		}()
-		ttlCache.Set("event1", corev1.Event{
+
+		for brk.Len() != 1 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		brk.Close()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("expected the stream to be closed")
+		}
+	})
+}
+
+func TestKeepAlive(t *testing.T) {
+	brk := broker.New(broker.Options{})
+	defer brk.Close()
+
+	srv := httptest.NewUnstartedServer(SSE(SSEOptions{
+		Broker:    brk,
+		Store:     &MockStore{},
+		KeepAlive: 50 * time.Millisecond,
+		Retry:     3 * time.Second,
+	}))
+	// The stream must outlive the server wide timeouts.
+	srv.Config.ReadTimeout = 80 * time.Millisecond
+	srv.Config.WriteTimeout = 80 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/notifications", nil)
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("could not send request: %v", err)
+	}
+	defer res.Body.Close()
+
+	rd := bufio.NewReader(res.Body)
+	for _, exp := range []string{"retry: 3000\n\n", ":keepalive\n\n", ":keepalive\n\n", ":keepalive\n\n", ":keepalive\n\n"} {
+		got, err := readFrame(rd)
+		if err != nil {
+			t.Fatalf("could not read frame: %v", err)
+		}
+		if got != exp {
+			t.Errorf("expected frame %q, got %q", exp, got)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	brk := broker.New(broker.Options{})
+	defer brk.Close()
+
+	srv := httptest.NewServer(SSE(SSEOptions{Broker: brk, Store: &MockStore{}}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		srv.URL+"/notifications?composition=abc&type=Warning", nil)
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("could not send request: %v", err)
+	}
+	defer res.Body.Close()
+
+	for brk.Len() != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	compositionEvent := func(cid, typ string) corev1.Event {
+		return corev1.Event{
 			ObjectMeta: v1.ObjectMeta{
-				Name: "event1", Namespace: "demo-system",
+				Labels: map[string]string{"krateo.io/composition-id": cid},
 			},
-		}, time.Second*2)
+			Type: typ,
+		}
+	}
 
-		handler := SSE(ttlCache)
-		req, err := http.NewRequest(http.MethodGet, "/notifications", nil)
+	brk.Publish(broker.Message{ID: 1, Event: compositionEvent("abc", "Normal")})
+	brk.Publish(broker.Message{ID: 2, Event: compositionEvent("xyz", "Warning")})
+	brk.Publish(broker.Message{ID: 3, Event: compositionEvent("abc", "Warning")})
+
+	got, err := readFrame(bufio.NewReader(res.Body))
+	if err != nil {
+		t.Fatalf("could not read frame: %v", err)
+	}
+	if !strings.Contains(got, "id: 3\n") {
+		t.Errorf("expected frame with %q, got %v", "id: 3", got)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	sto := &MockStore{}
+	for _, name := range []string{"event1", "event2", "event3"} {
+		sto.Set(name, &corev1.Event{
+			ObjectMeta: v1.ObjectMeta{Name: name},
+		})
+	}
+
+	brk := broker.New(broker.Options{})
+	defer brk.Close()
+
+	srv := httptest.NewServer(SSE(SSEOptions{Broker: brk, Store: sto}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/notifications", nil)
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+	req.Header.Set("Last-Event-ID", "1")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("could not send request: %v", err)
+	}
+	defer res.Body.Close()
+
+	for brk.Len() != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Already replayed, must be skipped.
+	brk.Publish(broker.Message{ID: 3, Key: "event3"})
+	// Live event.
+	rev, _ := sto.Set("event4", &corev1.Event{
+		ObjectMeta: v1.ObjectMeta{Name: "event4"},
+	})
+	brk.Publish(broker.Message{ID: rev, Key: "event4"})
+
+	rd := bufio.NewReader(res.Body)
+	for _, exp := range []string{"id: 2", "id: 3", "id: 4"} {
+		got, err := readFrame(rd)
+		if err != nil {
+			t.Fatalf("could not read frame: %v", err)
+		}
+		if !strings.Contains(got, exp+"\n") {
+			t.Errorf("expected frame with %q, got %v", exp, got)
+		}
+	}
+}
+
+func TestSharedRevision(t *testing.T) {
+	brk := broker.New(broker.Options{})
+	defer brk.Close()
+
+	srv := httptest.NewServer(SSE(SSEOptions{Broker: brk, Store: &MockStore{}}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/notifications", nil)
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("could not send request: %v", err)
+	}
+	defer res.Body.Close()
+
+	for brk.Len() != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Written in the same transaction: resuming from the first
+	// one must replay the second one too.
+	brk.Publish(broker.Message{ID: 5, Key: "event1", More: true})
+	brk.Publish(broker.Message{ID: 5, Key: "event2"})
+
+	rd := bufio.NewReader(res.Body)
+	for _, exp := range []string{"id: 4", "id: 5"} {
+		got, err := readFrame(rd)
+		if err != nil {
+			t.Fatalf("could not read frame: %v", err)
+		}
+		if !strings.Contains(got, exp+"\n") {
+			t.Errorf("expected frame with %q, got %v", exp, got)
+		}
+	}
+}
+
+func TestReplayFutureID(t *testing.T) {
+	// A fresh store, i.e. the memory store after a restart.
+	sto := &MockStore{}
+	sto.Set("event1", &corev1.Event{ObjectMeta: v1.ObjectMeta{Name: "event1"}})
+
+	brk := broker.New(broker.Options{})
+	defer brk.Close()
+
+	srv := httptest.NewServer(SSE(SSEOptions{Broker: brk, Store: sto}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/notifications", nil)
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+	req.Header.Set("Last-Event-ID", "500")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("could not send request: %v", err)
+	}
+	defer res.Body.Close()
+
+	for brk.Len() != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The live events must not be held back by the id from the future.
+	rev, _ := sto.Set("event2", &corev1.Event{ObjectMeta: v1.ObjectMeta{Name: "event2"}})
+	brk.Publish(broker.Message{ID: rev, Key: "event2"})
+
+	got, err := readFrame(bufio.NewReader(res.Body))
+	if err != nil {
+		t.Fatalf("could not read frame: %v", err)
+	}
+	if !strings.Contains(got, "id: 2\n") {
+		t.Errorf("expected frame with %q, got %v", "id: 2", got)
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	brk := broker.New(broker.Options{BufferSize: 1, Policy: broker.Disconnect})
+	defer brk.Close()
+
+	req, err := http.NewRequest(http.MethodGet, "/notifications", nil)
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		SSE(SSEOptions{Broker: brk, Store: &MockStore{}}).ServeHTTP(rr, req)
+	}()
+
+	for brk.Len() != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Publish faster than the client consumes, until it gets disconnected.
+	for i := int64(1); brk.Len() > 0; i++ {
+		brk.Publish(broker.Message{ID: i})
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the stream to be closed")
+	}
+
+	if got := rr.Body.String(); !strings.Contains(got, "event: overflow\n") {
+		t.Errorf("expected an overflow frame, got %v", got)
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	sto := &MockStore{}
+	for _, typ := range []string{"Warning", "Warning", "Normal", "Warning", "Normal"} {
+		sto.Set(typ, &corev1.Event{Type: typ})
+	}
+
+	brk := broker.New(broker.Options{})
+	defer brk.Close()
+
+	srv := httptest.NewServer(SSE(SSEOptions{Broker: brk, Store: sto}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		srv.URL+"/notifications?backfill=2&type=Warning", nil)
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("could not send request: %v", err)
+	}
+	defer res.Body.Close()
+
+	for brk.Len() != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The backfill reads the time index, not the whole history.
+	sto.mu.Lock()
+	calls := sto.sinceCalls
+	sto.mu.Unlock()
+	if calls != 0 {
+		t.Errorf("expected no Since call, got %d", calls)
+	}
+
+	// Already backfilled, must be skipped.
+	brk.Publish(broker.Message{ID: 4, Event: corev1.Event{Type: "Warning"}})
+	// Live event.
+	rev, _ := sto.Set("Warning", &corev1.Event{Type: "Warning"})
+	brk.Publish(broker.Message{ID: rev, Event: corev1.Event{Type: "Warning"}})
+
+	rd := bufio.NewReader(res.Body)
+	for _, exp := range []string{"id: 2", "id: 4", "id: 6"} {
+		got, err := readFrame(rd)
+		if err != nil {
+			t.Fatalf("could not read frame: %v", err)
+		}
+		if !strings.Contains(got, exp+"\n") {
+			t.Errorf("expected frame with %q, got %v", exp, got)
+		}
+	}
+}
+
+func TestBadRequest(t *testing.T) {
+	brk := broker.New(broker.Options{})
+	defer brk.Close()
+
+	handler := SSE(SSEOptions{Broker: brk, Store: &MockStore{}})
+
+	for _, q := range []string{"backfill=-1", "backfill=all", "since=yesterday", "format=xml"} {
+		req, err := http.NewRequest(http.MethodGet, "/notifications?"+q, nil)
 		if err != nil {
 			t.Fatalf("could not create request: %v", err)
 		}
 
 		rr := httptest.NewRecorder()
-
 		handler.ServeHTTP(rr, req)
 
-		if rr.Code != http.StatusOK {
-			t.Errorf("expected status 200 OK, got %v", rr.Code)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400 Bad Request, got %v", q, rr.Code)
 		}
+	}
+}
 
-		contentType := rr.Header().Get("Content-Type")
-		if contentType != "text/event-stream" {
-			t.Errorf("expected Content-Type 'text/event-stream', got %v", contentType)
-		}
+func TestLastEventID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		query  string
+		id     int64
+		ok     bool
+	}{
+		{name: "None"},
+		{name: "Header", header: "42", id: 42, ok: true},
+		{name: "Query", query: "7", id: 7, ok: true},
+		{name: "Header wins", header: "42", query: "7", id: 42, ok: true},
+		{name: "Invalid", header: "events/comp-abc/123"},
+		{name: "Negative", header: "-1"},
+	}
 
-		got := string(rr.Body.Bytes())
-		if !reflect.DeepEqual(got, exp) {
-			t.Errorf("expected response body %v, got %v", exp, got)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/notifications", nil)
+			if len(tt.header) > 0 {
+				req.Header.Set("Last-Event-ID", tt.header)
+			}
+			if len(tt.query) > 0 {
+				req.URL.RawQuery = "lastEventId=" + tt.query
+			}
+
+			id, ok := lastEventID(req)
+			if id != tt.id || ok != tt.ok {
+				t.Errorf("lastEventID() = (%d, %t), want (%d, %t)", id, ok, tt.id, tt.ok)
+			}
+		})
+	}
+}
+
+// readFrame reads a single SSE frame, up to the blank line that ends it.
+func readFrame(rd *bufio.Reader) (string, error) {
+	var sb strings.Builder
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return sb.String(), err
+		}
+		sb.WriteString(line)
+		if line == "\n" {
+			return sb.String(), nil
 		}
+	}
+}
+
+func TestCloudEventsFormat(t *testing.T) {
+	sto := &MockStore{}
+	sto.Set("Warning", &corev1.Event{
+		ObjectMeta: v1.ObjectMeta{
+			UID:    "uid-1",
+			Labels: map[string]string{"krateo.io/composition-id": "comp-1"},
+		},
+		Type: "Warning",
 	})
+
+	brk := broker.New(broker.Options{})
+	defer brk.Close()
+
+	srv := httptest.NewServer(SSE(SSEOptions{Broker: brk, Store: sto}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		srv.URL+"/notifications?backfill=1&format=cloudevents", nil)
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("could not send request: %v", err)
+	}
+	defer res.Body.Close()
+
+	got, err := readFrame(bufio.NewReader(res.Body))
+	if err != nil {
+		t.Fatalf("could not read frame: %v", err)
+	}
+
+	_, data, _ := strings.Cut(got, "data: ")
+	var ce cloudevents.Event
+	if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &ce); err != nil {
+		t.Fatalf("could not decode CloudEvent: %v", err)
+	}
+
+	if ce.SpecVersion != "1.0" || ce.ID != "uid-1" || ce.Subject != "comp-1" || ce.Type != "io.krateo.eventsse.warning" {
+		t.Errorf("unexpected CloudEvent: %+v", ce)
+	}
+}
+
+func TestUpdated(t *testing.T) {
+	brk := broker.New(broker.Options{})
+	defer brk.Close()
+
+	srv := httptest.NewServer(SSE(SSEOptions{Broker: brk, Store: &MockStore{}}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/notifications", nil)
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("could not send request: %v", err)
+	}
+	defer res.Body.Close()
+
+	for brk.Len() != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ts := v1.NewTime(time.Date(2024, 7, 5, 7, 33, 9, 0, time.UTC))
+	brk.Publish(broker.Message{
+		ID: 7,
+		Event: corev1.Event{
+			ObjectMeta: v1.ObjectMeta{
+				Labels: map[string]string{"krateo.io/composition-id": "abc"},
+			},
+			Count:         3,
+			LastTimestamp: ts,
+		},
+		Updated: true,
+	})
+	brk.Publish(broker.Message{ID: 8})
+
+	rd := bufio.NewReader(res.Body)
+	got, err := readFrame(rd)
+	if err != nil {
+		t.Fatalf("could not read frame: %v", err)
+	}
+	if !strings.HasPrefix(got, "event: updated\nid: 7\n") {
+		t.Fatalf("expected an updated frame, got %v", got)
+	}
+	if !strings.Contains(got, `"count":3`) || !strings.Contains(got, `"lastTimestamp":"2024-07-05T07:33:09Z"`) {
+		t.Errorf("expected the new count and lastTimestamp, got %v", got)
+	}
+
+	// No composition frame must follow.
+	got, err = readFrame(rd)
+	if err != nil {
+		t.Fatalf("could not read frame: %v", err)
+	}
+	if !strings.HasPrefix(got, "event: krateo\nid: 8\n") {
+		t.Errorf("expected the next event, got %v", got)
+	}
+}
+
+// MockAuthorizer allows the listed compositions only.
+type MockAuthorizer map[string]bool
+
+func (m MockAuthorizer) Allowed(_ context.Context, compositionID string) (bool, error) {
+	return m[compositionID], nil
+}
+
+func TestAuthorization(t *testing.T) {
+	brk := broker.New(broker.Options{})
+	defer brk.Close()
+
+	srv := httptest.NewServer(SSE(SSEOptions{
+		Broker: brk, Store: &MockStore{}, Authorizer: MockAuthorizer{"abc": true},
+	}))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/notifications?composition=xyz")
+	if err != nil {
+		t.Fatalf("could not send request: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected status %d, got %d", http.StatusForbidden, res.StatusCode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/notifications", nil)
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("could not send request: %v", err)
+	}
+	defer res.Body.Close()
+
+	for brk.Len() != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	compositionEvent := func(cid string) corev1.Event {
+		ev := corev1.Event{}
+		if len(cid) > 0 {
+			ev.ObjectMeta = v1.ObjectMeta{
+				Labels: map[string]string{"krateo.io/composition-id": cid},
+			}
+		}
+		return ev
+	}
+
+	brk.Publish(broker.Message{ID: 1, Event: compositionEvent("xyz")})
+	brk.Publish(broker.Message{ID: 2, Event: compositionEvent("")})
+	brk.Publish(broker.Message{ID: 3, Event: compositionEvent("abc")})
+
+	got, err := readFrame(bufio.NewReader(res.Body))
+	if err != nil {
+		t.Fatalf("could not read frame: %v", err)
+	}
+	if !strings.Contains(got, "id: 3\n") {
+		t.Errorf("expected frame with %q, got %v", "id: 3", got)
+	}
+}
+
+// slowAuthorizer allows every composition, once released,
+// and counts the calls.
+type slowAuthorizer struct {
+	release chan struct{}
+	calls   atomic.Int32
+}
+
+func (m *slowAuthorizer) Allowed(ctx context.Context, _ string) (bool, error) {
+	m.calls.Add(1)
+	select {
+	case <-m.release:
+		return true, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+func TestAuthorizationQueued(t *testing.T) {
+	brk := broker.New(broker.Options{BufferSize: 4, Policy: broker.Disconnect})
+	defer brk.Close()
+
+	a := &slowAuthorizer{release: make(chan struct{})}
+	srv := httptest.NewServer(SSE(SSEOptions{
+		Broker: brk, Store: &MockStore{}, Authorizer: a,
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/notifications", nil)
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("could not send request: %v", err)
+	}
+	defer res.Body.Close()
+
+	for brk.Len() != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// More events than the subscription queue holds, while
+	// the authorization of their composition is pending.
+	const n = 16
+	for i := 1; i <= n; i++ {
+		brk.Publish(broker.Message{ID: int64(i), Event: corev1.Event{
+			ObjectMeta: v1.ObjectMeta{
+				Labels: map[string]string{"krateo.io/composition-id": "abc"},
+			},
+		}})
+		time.Sleep(time.Millisecond)
+	}
+	close(a.release)
+
+	rd := bufio.NewReader(res.Body)
+	for i := 1; i <= n; i++ {
+		got, err := readFrame(rd)
+		if err != nil {
+			t.Fatalf("could not read frame: %v", err)
+		}
+		// The composition frame follows each event.
+		readFrame(rd)
+		if exp := fmt.Sprintf("id: %d\n", i); !strings.Contains(got, exp) {
+			t.Fatalf("expected frame with %q, got %v", exp, got)
+		}
+	}
+
+	if got := a.calls.Load(); got != 1 {
+		t.Errorf("expected 1 authorization for the composition, got %d", got)
+	}
 }
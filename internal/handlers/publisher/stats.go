@@ -0,0 +1,43 @@
+package publisher
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"github.com/krateoplatformops/eventsse/internal/broker"
+	"github.com/rs/zerolog"
+)
+
+func Stats(brk *broker.Broker) http.Handler {
+	return &statsHandler{
+		broker: brk,
+	}
+}
+
+var _ http.Handler = (*statsHandler)(nil)
+
+type statsHandler struct {
+	broker *broker.Broker
+}
+
+// Stats godoc
+// @Summary SSE Streams Statistics
+// @Description List the active SSE streams with their queued and dropped events
+// @ID notifications-stats
+// @Produce  json
+// @Success 200 {array} broker.Stats
+// @Router /notifications/stats [get]
+func (r *statsHandler) ServeHTTP(wri http.ResponseWriter, req *http.Request) {
+	log := zerolog.New(os.Stdout).With().
+		Str("service", "eventsse").
+		Timestamp().
+		Logger()
+
+	wri.Header().Set("Content-Type", "application/json")
+	wri.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(wri).Encode(r.broker.Stats()); err != nil {
+		log.Error().Msg(err.Error())
+	}
+}
@@ -0,0 +1,46 @@
+package publisher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/krateoplatformops/eventsse/internal/broker"
+)
+
+func TestStats(t *testing.T) {
+	brk := broker.New(broker.Options{BufferSize: 1, Policy: broker.DropNewest})
+	defer brk.Close()
+
+	sub := brk.Subscribe("127.0.0.1:34567")
+	defer brk.Unsubscribe(sub)
+
+	brk.Publish(broker.Message{ID: 1})
+	brk.Publish(broker.Message{ID: 2})
+
+	req, err := http.NewRequest(http.MethodGet, "/notifications/stats", nil)
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	Stats(brk).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status 200 OK, got %v", rr.Code)
+	}
+
+	var all []broker.Stats
+	if err := json.NewDecoder(rr.Body).Decode(&all); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+
+	if len(all) != 1 {
+		t.Fatalf("expected 1 stream, got %d", len(all))
+	}
+
+	if all[0].Name != "127.0.0.1:34567" || all[0].Dropped != 1 {
+		t.Errorf("unexpected stats: %+v", all[0])
+	}
+}
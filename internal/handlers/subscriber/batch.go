@@ -0,0 +1,226 @@
+package subscriber
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/krateoplatformops/eventsse/internal/events"
+	"github.com/krateoplatformops/eventsse/internal/metrics"
+	"github.com/krateoplatformops/eventsse/internal/store"
+	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// maxBatchBytes is the max size of a batch request body.
+	maxBatchBytes = 16 << 20
+	// maxBatchItems is the max number of events in a batch.
+	maxBatchItems = 1000
+	// batchWorkers is the number of writes issued
+	// to the store at the same time.
+	batchWorkers = 16
+)
+
+// Result is the outcome of the ingestion of an event of a batch.
+type Result struct {
+	Key      string `json:"key,omitempty"`
+	Revision int64  `json:"revision,omitempty"`
+	Status   int    `json:"status"`
+	Error    string `json:"error,omitempty"`
+	// Duplicate is true if the same version of
+	// the event was already stored.
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+// BatchResponse is the response to a batch request; the
+// results are in the same order of the posted events.
+type BatchResponse struct {
+	Stored int      `json:"stored"`
+	Failed int      `json:"failed"`
+	Items  []Result `json:"items"`
+}
+
+// item is an event of a batch, or the reason why it is not.
+type item struct {
+	event corev1.Event
+	err   error
+}
+
+// decodeArray reads a JSON array of events.
+func decodeArray(body io.Reader) ([]item, error) {
+	var all []json.RawMessage
+	if err := json.NewDecoder(body).Decode(&all); err != nil {
+		return nil, err
+	}
+
+	res := make([]item, len(all))
+	for i, raw := range all {
+		res[i] = decodeItem(raw)
+	}
+	return res, nil
+}
+
+// decodeNDJSON reads newline delimited events; blank lines are skipped.
+func decodeNDJSON(body io.Reader) ([]item, error) {
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 0, 64*1024), maxBatchBytes)
+
+	var res []item
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		res = append(res, decodeItem(line))
+	}
+
+	return res, sc.Err()
+}
+
+func decodeItem(raw []byte) item {
+	ev, err := events.Decode(raw)
+	return item{event: ev, err: err}
+}
+
+// serveBatch stores the events of a batch, all at once if the
+// store can, or else issuing the writes of the different events
+// concurrently, and reports the outcome of each of them.
+func (r *handler) serveBatch(wri http.ResponseWriter, req *http.Request, ndjson bool, log zerolog.Logger) {
+	body := http.MaxBytesReader(wri, req.Body, maxBatchBytes)
+
+	var all []item
+	var err error
+	if ndjson {
+		all, err = decodeNDJSON(body)
+	} else {
+		all, err = decodeArray(body)
+	}
+	if err != nil {
+		log.Error().Msg(err.Error())
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			rejected(metrics.ReasonTooLarge, 1)
+			http.Error(wri, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		rejected(metrics.ReasonInvalid, 1)
+		http.Error(wri, fmt.Sprintf("Request body contains a badly-formed batch: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if len(all) == 0 {
+		http.Error(wri, "Request body is empty", http.StatusNoContent)
+		return
+	}
+	if len(all) > maxBatchItems {
+		msg := fmt.Sprintf("Request body must not contain more than %d events", maxBatchItems)
+		log.Error().Msg(msg)
+		rejected(metrics.ReasonTooLarge, len(all))
+		http.Error(wri, msg, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	res := BatchResponse{Items: make([]Result, len(all))}
+
+	// The versions of the same event share the key: they are
+	// written one after the other, in the request order, so
+	// that the last one posted is the one kept.
+	keys := make([]string, len(all))
+	groups := map[string][]int{}
+	order := []string{}
+	for i := range all {
+		if all[i].err != nil {
+			rejected(metrics.ReasonInvalid, 1)
+			res.Items[i] = Result{Status: http.StatusBadRequest, Error: all[i].err.Error()}
+			continue
+		}
+		metrics.EventsReceived.Inc()
+
+		keys[i] = r.store.PrepareKey(&all[i].event)
+		if _, ok := groups[keys[i]]; !ok {
+			order = append(order, keys[i])
+		}
+		groups[keys[i]] = append(groups[keys[i]], i)
+	}
+
+	if bs, ok := r.store.(store.BatchSetter); ok {
+		// The store writes many events at once, in order.
+		var idx []int
+		var ks []string
+		var evs []*corev1.Event
+		for i := range all {
+			if all[i].err == nil {
+				idx = append(idx, i)
+				ks = append(ks, keys[i])
+				evs = append(evs, &all[i].event)
+			}
+		}
+
+		for j, el := range bs.SetBatch(ks, evs) {
+			res.Items[idx[j]] = result(ks[j], el.Revision, el.Err, log)
+		}
+	} else {
+		sem := make(chan struct{}, batchWorkers)
+		var wg sync.WaitGroup
+		for _, key := range order {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(idx []int) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+
+				for _, i := range idx {
+					rev, err := r.store.Set(keys[i], &all[i].event)
+					res.Items[i] = result(keys[i], rev, err, log)
+				}
+			}(groups[key])
+		}
+		wg.Wait()
+	}
+
+	for _, el := range res.Items {
+		if el.Status == http.StatusOK {
+			res.Stored++
+		} else {
+			res.Failed++
+		}
+	}
+
+	// The store watch takes care of the notifications.
+	log.Info().Int("stored", res.Stored).Int("failed", res.Failed).Msg("Events batch stored")
+
+	status := http.StatusOK
+	if res.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+
+	wri.Header().Set("Content-Type", "application/json")
+	wri.WriteHeader(status)
+	if err := json.NewEncoder(wri).Encode(&res); err != nil {
+		log.Error().Msg(err.Error())
+	}
+}
+
+// result reports the outcome of the write of an event of a batch.
+func result(key string, rev int64, err error, log zerolog.Logger) Result {
+	if errors.Is(err, store.ErrDuplicate) {
+		metrics.EventsRejected.WithLabelValues(metrics.ReasonDuplicate).Inc()
+		return Result{Key: key, Revision: rev, Status: http.StatusOK, Duplicate: true}
+	}
+	if err != nil {
+		log.Error().Str("key", key).Msg(err.Error())
+		metrics.EventsRejected.WithLabelValues(metrics.ReasonStoreError).Inc()
+		return Result{Key: key, Status: http.StatusInternalServerError, Error: err.Error()}
+	}
+
+	metrics.EventsStored.Inc()
+	return Result{Key: key, Revision: rev, Status: http.StatusOK}
+}
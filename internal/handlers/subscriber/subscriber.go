@@ -1,36 +1,44 @@
 package subscriber
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"os"
-	"time"
 
-	"github.com/krateoplatformops/eventsse/internal/cache"
+	"github.com/krateoplatformops/eventsse/internal/cloudevents"
+	"github.com/krateoplatformops/eventsse/internal/events"
 	"github.com/krateoplatformops/eventsse/internal/httputil/decode"
-	"github.com/krateoplatformops/eventsse/internal/labels"
+	"github.com/krateoplatformops/eventsse/internal/httputil/header"
+	"github.com/krateoplatformops/eventsse/internal/metrics"
 	"github.com/krateoplatformops/eventsse/internal/store"
 	"github.com/rs/zerolog"
 
 	corev1 "k8s.io/api/core/v1"
 )
 
+const (
+	// maxEventBytes is the max size of a single event request body.
+	maxEventBytes = 1 << 20
+)
+
 type HandleOptions struct {
-	TTLCache *cache.TTLCache[string, corev1.Event]
-	Store    store.Store
+	Store store.Store
 }
 
 func Handle(opts HandleOptions) http.Handler {
 	return &handler{
-		ttlCache: opts.TTLCache,
-		store:    opts.Store,
+		store: opts.Store,
 	}
 }
 
 var _ http.Handler = (*handler)(nil)
 
 type handler struct {
-	ttlCache *cache.TTLCache[string, corev1.Event]
-	store    store.Store
+	store store.Store
 }
 
 func (r *handler) ServeHTTP(wri http.ResponseWriter, req *http.Request) {
@@ -39,31 +47,137 @@ func (r *handler) ServeHTTP(wri http.ResponseWriter, req *http.Request) {
 		Timestamp().
 		Logger()
 
-	var nfo corev1.Event
-	err := decode.JSONBody(wri, req, &nfo)
+	ctype, _ := header.ParseValueAndParams(req.Header, "Content-Type")
+	if ctype == cloudevents.ContentType || cloudevents.IsBinary(req.Header) {
+		nfo, err := decodeCloudEvent(wri, req, ctype == cloudevents.ContentType)
+		if err != nil {
+			rejected(metrics.ReasonInvalid, 1)
+			log.Error().Msg(err.Error())
+			http.Error(wri, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.serveEvent(wri, &nfo, log)
+		return
+	}
+
+	if ctype == "application/x-ndjson" {
+		r.serveBatch(wri, req, true, log)
+		return
+	}
+
+	// A JSON array is a batch of events.
+	if ctype == "" || ctype == "application/json" {
+		br := bufio.NewReader(req.Body)
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{br, req.Body}
+
+		if isArray(br) {
+			r.serveBatch(wri, req, false, log)
+			return
+		}
+	}
+
+	var raw json.RawMessage
+	err := decode.JSONBody(wri, req, &raw)
 	if err != nil {
 		log.Error().Msg(err.Error())
 		if decode.IsEmptyBodyError(err) {
 			http.Error(wri, err.Error(), http.StatusNoContent)
 		} else {
+			rejected(metrics.ReasonInvalid, 1)
 			http.Error(wri, err.Error(), http.StatusBadRequest)
 		}
 		return
 	}
 
-	key := r.store.PrepareKey(string(nfo.UID), labels.CompositionID(&nfo))
+	// Both core/v1 and events.k8s.io/v1 events are accepted.
+	nfo, err := events.Decode(raw)
+	if err != nil {
+		rejected(metrics.ReasonInvalid, 1)
+		log.Error().Msg(err.Error())
+		http.Error(wri, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.serveEvent(wri, &nfo, log)
+}
+
+// serveEvent stores a single event and responds with its key.
+func (r *handler) serveEvent(wri http.ResponseWriter, nfo *corev1.Event, log zerolog.Logger) {
+	key := r.store.PrepareKey(nfo)
 	log.Info().Str("key", key).Msg("Event received")
+	metrics.EventsReceived.Inc()
 
-	if err := r.store.Set(key, &nfo); err != nil {
+	rev, err := r.store.Set(key, nfo)
+	if errors.Is(err, store.ErrDuplicate) {
+		log.Info().Str("key", key).Int64("revision", rev).Msg("Duplicate event")
+		metrics.EventsRejected.WithLabelValues(metrics.ReasonDuplicate).Inc()
+		wri.WriteHeader(http.StatusOK)
+		wri.Write([]byte(key))
+		return
+	}
+	if err != nil {
 		log.Error().Msg(err.Error())
+		metrics.EventsRejected.WithLabelValues(metrics.ReasonStoreError).Inc()
 		http.Error(wri, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	r.ttlCache.Set(key, nfo, time.Minute*2)
-	log.Info().Str("key", key).Msg("Event stored")
+	// The store watch takes care of the notifications.
+	log.Info().Str("key", key).Int64("revision", rev).Msg("Event stored")
+	metrics.EventsStored.Inc()
 
 	wri.WriteHeader(http.StatusOK)
 	wri.Header().Set("Content-Type", "text/plain")
 	wri.Write([]byte(key))
 }
+
+// rejected counts the received events not stored, for the
+// given reason, before they reach the store.
+func rejected(reason string, n int) {
+	metrics.EventsReceived.Add(float64(n))
+	metrics.EventsRejected.WithLabelValues(reason).Add(float64(n))
+}
+
+// isArray reports whether the body starts with a JSON array,
+// without consuming it.
+func isArray(br *bufio.Reader) bool {
+	for {
+		c, err := br.Peek(1)
+		if err != nil {
+			return false
+		}
+
+		switch c[0] {
+		case ' ', '\t', '\r', '\n':
+			br.ReadByte()
+		default:
+			return c[0] == '['
+		}
+	}
+}
+
+// decodeCloudEvent returns the Kubernetes event carried
+// by the CloudEvent, in structured or binary mode.
+func decodeCloudEvent(wri http.ResponseWriter, req *http.Request, structured bool) (corev1.Event, error) {
+	dat, err := io.ReadAll(http.MaxBytesReader(wri, req.Body, maxEventBytes))
+	if err != nil {
+		return corev1.Event{}, err
+	}
+
+	var ce cloudevents.Event
+	if structured {
+		if err := json.Unmarshal(dat, &ce); err != nil {
+			return corev1.Event{}, fmt.Errorf("Request body contains a badly-formed CloudEvent: %w", err)
+		}
+	} else {
+		ce, err = cloudevents.FromBinary(req.Header, dat)
+		if err != nil {
+			return corev1.Event{}, err
+		}
+	}
+
+	return cloudevents.Decode(&ce)
+}
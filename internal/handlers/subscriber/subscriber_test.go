@@ -2,15 +2,20 @@ package subscriber
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
 	"testing"
 
-	"github.com/krateoplatformops/eventsse/internal/cache"
 	"github.com/krateoplatformops/eventsse/internal/labels"
+	"github.com/krateoplatformops/eventsse/internal/metrics"
 	"github.com/krateoplatformops/eventsse/internal/store"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -21,26 +26,58 @@ var _ store.Store = (*MockStore)(nil)
 // MockStore è un mock del client store per testare l'handler
 type MockStore struct {
 	data map[string]corev1.Event
+	mu   sync.Mutex
 }
 
-func (m *MockStore) PrepareKey(uid, compositionID string) string {
-	return uid + ":" + compositionID
+func (m *MockStore) PrepareKey(ev *corev1.Event) string {
+	return string(ev.UID) + ":" + labels.CompositionID(ev)
 }
 
-func (m *MockStore) Set(key string, event *corev1.Event) error {
+func (m *MockStore) PreparePrefix(compositionID string) string {
+	return ":" + compositionID
+}
+
+func (m *MockStore) Set(key string, event *corev1.Event) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if event.UID == "fail" {
+		return 0, fmt.Errorf("cannot store %q", key)
+	}
 	if m.data == nil {
 		m.data = make(map[string]corev1.Event)
 	}
+	if old, ok := m.data[key]; ok && len(event.ResourceVersion) > 0 &&
+		old.UID == event.UID && old.ResourceVersion == event.ResourceVersion {
+		return int64(len(m.data)), store.ErrDuplicate
+	}
 	m.data[key] = *event
-	return nil
+	return int64(len(m.data)), nil
 }
 
-func (m *MockStore) Get(key string, opts store.GetOptions) (data []corev1.Event, found bool, err error) {
+func (m *MockStore) Get(key string, opts store.GetOptions) (data []store.Record, found bool, err error) {
 	event, exists := m.data[key]
 	if !exists {
 		return nil, false, fmt.Errorf("key '%s' not found", key)
 	}
-	return []corev1.Event{event}, true, nil
+	return []store.Record{{Key: key, Event: event}}, true, nil
+}
+
+func (m *MockStore) Since(rev int64, limit int) ([]store.Record, error) {
+	return nil, nil
+}
+
+func (m *MockStore) Revision() (int64, error) {
+	return 0, nil
+}
+
+func (m *MockStore) Watch(ctx context.Context, rev int64) <-chan store.Record {
+	out := make(chan store.Record)
+	go func() {
+		defer close(out)
+		<-ctx.Done()
+	}()
+	return out
 }
 
 func (m *MockStore) Delete(key string) error {
@@ -48,6 +85,12 @@ func (m *MockStore) Delete(key string) error {
 	return nil
 }
 
+func (m *MockStore) DeleteRevision(key string, _ int64) (bool, error) {
+	_, ok := m.data[key]
+	delete(m.data, key)
+	return ok, nil
+}
+
 func (m *MockStore) SetTTL(_ int) {
 
 }
@@ -57,10 +100,9 @@ func (m *MockStore) Close() error {
 }
 
 func TestServeHTTP(t *testing.T) {
-	ttlCache := cache.NewTTL[string, corev1.Event]()
 	ms := &MockStore{}
 
-	handler := Handle(HandleOptions{TTLCache: ttlCache, Store: ms})
+	handler := Handle(HandleOptions{Store: ms})
 
 	t.Run("Malformed JSON", func(t *testing.T) {
 		req, err := http.NewRequest(http.MethodPost, "/events", bytes.NewBuffer([]byte("{malformed json")))
@@ -113,9 +155,427 @@ func TestServeHTTP(t *testing.T) {
 			t.Errorf("expected status 200 OK, got %v", rr.Code)
 		}
 
-		expectedKey := ms.PrepareKey(string(event.UID), labels.CompositionID(&event))
+		expectedKey := ms.PrepareKey(&event)
 		if rr.Body.String() != expectedKey {
 			t.Errorf("expected response body %q, got %q", expectedKey, rr.Body.String())
 		}
+
+		if _, ok := ms.data[expectedKey]; !ok {
+			t.Errorf("expected the event to be stored with key %q", expectedKey)
+		}
 	})
 }
+
+func TestServeHTTPBatch(t *testing.T) {
+	event := func(uid string) corev1.Event {
+		return corev1.Event{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      "test-event-" + uid,
+				Namespace: "demo-system",
+				UID:       types.UID(uid),
+			},
+			Message: "Test Event",
+		}
+	}
+
+	ndjson := func(lines ...string) string {
+		return strings.Join(lines, "\n")
+	}
+
+	line := func(uid string) string {
+		dat, _ := json.Marshal(event(uid))
+		return string(dat)
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		expected    []int
+	}{
+		{
+			name:     "JSON array",
+			body:     " \n[" + line("1") + "," + line("2") + "]",
+			status:   http.StatusOK,
+			expected: []int{http.StatusOK, http.StatusOK},
+		},
+		{
+			name:        "NDJSON",
+			contentType: "application/x-ndjson",
+			body:        ndjson(line("1"), "", line("2"), line("3")),
+			status:      http.StatusOK,
+			expected:    []int{http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+		{
+			name:        "Partial failure",
+			contentType: "application/x-ndjson",
+			body:        ndjson(line("1"), "{malformed json", `{"unknown":1}`, line("fail")),
+			status:      http.StatusMultiStatus,
+			expected:    []int{http.StatusOK, http.StatusBadRequest, http.StatusBadRequest, http.StatusInternalServerError},
+		},
+		{
+			name:   "Malformed array",
+			body:   "[" + line("1") + ",",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "Empty array",
+			body:   "[]",
+			status: http.StatusNoContent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms := &MockStore{}
+			handler := Handle(HandleOptions{Store: ms})
+
+			req, err := http.NewRequest(http.MethodPost, "/handle", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("could not create request: %v", err)
+			}
+			if len(tt.contentType) > 0 {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Fatalf("expected status %d, got %d (%s)", tt.status, rr.Code, rr.Body.String())
+			}
+			if len(tt.expected) == 0 {
+				return
+			}
+
+			var res BatchResponse
+			if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+				t.Fatalf("could not decode response: %v", err)
+			}
+
+			if len(res.Items) != len(tt.expected) {
+				t.Fatalf("expected %d results, got %d", len(tt.expected), len(res.Items))
+			}
+
+			stored := 0
+			for i, el := range res.Items {
+				if el.Status != tt.expected[i] {
+					t.Errorf("item %d: expected status %d, got %d (%s)", i, tt.expected[i], el.Status, el.Error)
+				}
+				if el.Status != http.StatusOK {
+					continue
+				}
+				stored++
+				if _, ok := ms.data[el.Key]; !ok {
+					t.Errorf("item %d: expected the event to be stored with key %q", i, el.Key)
+				}
+			}
+
+			if res.Stored != stored || res.Failed != len(tt.expected)-stored {
+				t.Errorf("expected %d stored and %d failed, got %d and %d",
+					stored, len(tt.expected)-stored, res.Stored, res.Failed)
+			}
+		})
+	}
+}
+
+func TestServeHTTPBatchOrder(t *testing.T) {
+	ms := &MockStore{}
+	handler := Handle(HandleOptions{Store: ms})
+
+	// Many versions of the same events, interleaved.
+	lines := []string{}
+	for i := 1; i <= 50; i++ {
+		for _, uid := range []string{"1", "2"} {
+			dat, _ := json.Marshal(corev1.Event{
+				ObjectMeta: v1.ObjectMeta{
+					Name:            "test-event-" + uid,
+					Namespace:       "demo-system",
+					UID:             types.UID(uid),
+					ResourceVersion: fmt.Sprint(i),
+				},
+				Message: "Test Event",
+				Count:   int32(i),
+			})
+			lines = append(lines, string(dat))
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "/handle", strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d (%s)", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	// The last version posted is the one kept.
+	for _, key := range []string{"1:", "2:"} {
+		if got := ms.data[key].Count; got != 50 {
+			t.Errorf("%s: expected count 50, got %d", key, got)
+		}
+	}
+}
+
+// batchStore is a MockStore that writes the batches at once.
+type batchStore struct {
+	*MockStore
+	calls int
+}
+
+func (m *batchStore) SetBatch(keys []string, evs []*corev1.Event) []store.SetResult {
+	m.calls++
+
+	res := make([]store.SetResult, len(keys))
+	for i, key := range keys {
+		rev, err := m.Set(key, evs[i])
+		res[i] = store.SetResult{Revision: rev, Err: err}
+	}
+	return res
+}
+
+func TestServeHTTPBatchSetter(t *testing.T) {
+	ms := &batchStore{MockStore: &MockStore{}}
+	handler := Handle(HandleOptions{Store: ms})
+
+	lines := []string{}
+	for _, uid := range []string{"1", "fail", "", "1"} {
+		if len(uid) == 0 {
+			lines = append(lines, "{malformed json")
+			continue
+		}
+		dat, _ := json.Marshal(corev1.Event{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      "test-event-" + uid,
+				Namespace: "demo-system",
+				UID:       types.UID(uid),
+			},
+			Message: "Test Event " + fmt.Sprint(len(lines)),
+		})
+		lines = append(lines, string(dat))
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "/handle", strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusMultiStatus {
+		t.Fatalf("expected status %d, got %d (%s)", http.StatusMultiStatus, rr.Code, rr.Body.String())
+	}
+	if ms.calls != 1 {
+		t.Fatalf("expected the batch to be written at once, got %d calls", ms.calls)
+	}
+
+	var res BatchResponse
+	if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+
+	expected := []int{http.StatusOK, http.StatusInternalServerError, http.StatusBadRequest, http.StatusOK}
+	for i, el := range res.Items {
+		if el.Status != expected[i] {
+			t.Errorf("item %d: expected status %d, got %d (%s)", i, expected[i], el.Status, el.Error)
+		}
+	}
+
+	// The last version posted is the one kept.
+	if got := ms.data["1:"].Message; got != "Test Event 3" {
+		t.Errorf("expected the last version, got %q", got)
+	}
+}
+
+func TestServeHTTPDuplicate(t *testing.T) {
+	ms := &MockStore{}
+	handler := Handle(HandleOptions{Store: ms})
+
+	ev := corev1.Event{
+		ObjectMeta: v1.ObjectMeta{
+			Name:            "test-event",
+			Namespace:       "demo-system",
+			UID:             "1",
+			ResourceVersion: "100",
+		},
+		Message: "Test Event",
+	}
+	dat, _ := json.Marshal(ev)
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodPost, "/handle", bytes.NewReader(dat))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("#%d: expected status %d, got %d (%s)", i, http.StatusOK, rr.Code, rr.Body.String())
+		}
+		if got := rr.Body.String(); got != "1:" {
+			t.Fatalf("#%d: expected key %q, got %q", i, "1:", got)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "/handle", strings.NewReader("["+string(dat)+"]"))
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var res BatchResponse
+	if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if rr.Code != http.StatusOK || res.Stored != 1 || !res.Items[0].Duplicate {
+		t.Fatalf("expected a stored duplicate, got %d %+v", rr.Code, res)
+	}
+}
+
+func TestServeHTTPCloudEvents(t *testing.T) {
+	data := `{"metadata":{"name":"test-event","namespace":"demo-system","uid":"test-uid"},"message":"Test Event"}`
+
+	t.Run("Structured", func(t *testing.T) {
+		ms := &MockStore{}
+		handler := Handle(HandleOptions{Store: ms})
+
+		body := `{"specversion":"1.0","id":"ce-1","source":"test","type":"test","datacontenttype":"application/json","data":` + data + `}`
+		req, err := http.NewRequest(http.MethodPost, "/handle", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/cloudevents+json")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 OK, got %v (%s)", rr.Code, rr.Body.String())
+		}
+		if _, ok := ms.data[rr.Body.String()]; !ok {
+			t.Errorf("expected the event to be stored with key %q", rr.Body.String())
+		}
+	})
+
+	t.Run("Binary", func(t *testing.T) {
+		ms := &MockStore{}
+		handler := Handle(HandleOptions{Store: ms})
+
+		req, err := http.NewRequest(http.MethodPost, "/handle", strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("ce-specversion", "1.0")
+		req.Header.Set("ce-id", "ce-1")
+		req.Header.Set("ce-source", "test")
+		req.Header.Set("ce-type", "test")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 OK, got %v (%s)", rr.Code, rr.Body.String())
+		}
+		if rr.Body.String() != "test-uid:" {
+			t.Errorf("expected response body %q, got %q", "test-uid:", rr.Body.String())
+		}
+	})
+
+	t.Run("Missing attributes", func(t *testing.T) {
+		handler := Handle(HandleOptions{Store: &MockStore{}})
+
+		req, err := http.NewRequest(http.MethodPost, "/handle", strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("ce-specversion", "1.0")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 Bad Request, got %v", rr.Code)
+		}
+	})
+}
+
+func TestServeHTTPEventsV1(t *testing.T) {
+	ms := &MockStore{}
+	handler := Handle(HandleOptions{Store: ms})
+
+	dat, err := os.ReadFile("../../../testdata/event.sample.eventsv1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "/handle", bytes.NewReader(dat))
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 OK, got %v (%s)", rr.Code, rr.Body.String())
+	}
+
+	ev, ok := ms.data[rr.Body.String()]
+	if !ok {
+		t.Fatalf("expected the event to be stored with key %q", rr.Body.String())
+	}
+	if ev.InvolvedObject.Name != "fake-service-3" || ev.APIVersion != "v1" {
+		t.Errorf("expected a normalized core/v1 event, got %+v", ev)
+	}
+}
+
+func TestServeHTTPMetrics(t *testing.T) {
+	handler := Handle(HandleOptions{Store: &MockStore{}})
+
+	received := testutil.ToFloat64(metrics.EventsReceived)
+	stored := testutil.ToFloat64(metrics.EventsStored)
+	invalid := testutil.ToFloat64(metrics.EventsRejected.WithLabelValues(metrics.ReasonInvalid))
+	duplicate := testutil.ToFloat64(metrics.EventsRejected.WithLabelValues(metrics.ReasonDuplicate))
+
+	ev := corev1.Event{
+		ObjectMeta: v1.ObjectMeta{Name: "test-event", UID: "1", ResourceVersion: "100"},
+	}
+	dat, _ := json.Marshal(ev)
+
+	// Stored, duplicate, then a batch with a duplicate and an invalid event.
+	for _, body := range []string{string(dat), string(dat), "[" + string(dat) + `,"not an event"]`} {
+		req, err := http.NewRequest(http.MethodPost, "/handle", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	for _, el := range []struct {
+		name     string
+		got, exp float64
+	}{
+		{"received", testutil.ToFloat64(metrics.EventsReceived) - received, 4},
+		{"stored", testutil.ToFloat64(metrics.EventsStored) - stored, 1},
+		{"invalid", testutil.ToFloat64(metrics.EventsRejected.WithLabelValues(metrics.ReasonInvalid)) - invalid, 1},
+		{"duplicate", testutil.ToFloat64(metrics.EventsRejected.WithLabelValues(metrics.ReasonDuplicate)) - duplicate, 2},
+	} {
+		if el.got != el.exp {
+			t.Errorf("%s: expected %v, got %v", el.name, el.exp, el.got)
+		}
+	}
+}
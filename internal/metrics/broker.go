@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/krateoplatformops/eventsse/internal/broker"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	droppedDesc = prometheus.NewDesc(namespace+"_sse_events_dropped_total",
+		"Events dropped because the SSE stream queue was full.", nil, nil)
+	streamDeliveredDesc = prometheus.NewDesc(namespace+"_sse_stream_events_delivered_total",
+		"Events sent to the open SSE stream.", []string{"stream", "client"}, nil)
+	streamDroppedDesc = prometheus.NewDesc(namespace+"_sse_stream_events_dropped_total",
+		"Events dropped because the open SSE stream queue was full.", []string{"stream", "client"}, nil)
+	streamQueuedDesc = prometheus.NewDesc(namespace+"_sse_stream_events_queued",
+		"Events queued for the open SSE stream.", []string{"stream", "client"}, nil)
+)
+
+// RegisterBroker exposes the events dropped by the broker
+// and the state of each open SSE stream.
+func RegisterBroker(brk *broker.Broker) {
+	Registry.MustRegister(&brokerCollector{broker: brk})
+}
+
+// brokerCollector reads the broker state on every scrape,
+// so that the closed streams disappear from the metrics.
+type brokerCollector struct {
+	broker *broker.Broker
+}
+
+func (c *brokerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- droppedDesc
+	ch <- streamDeliveredDesc
+	ch <- streamDroppedDesc
+	ch <- streamQueuedDesc
+}
+
+func (c *brokerCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(droppedDesc, prometheus.CounterValue, float64(c.broker.Dropped()))
+
+	for _, el := range c.broker.Stats() {
+		id := strconv.FormatUint(el.ID, 10)
+		client := clientHost(el.Name)
+		ch <- prometheus.MustNewConstMetric(streamDeliveredDesc, prometheus.CounterValue, float64(el.Delivered), id, client)
+		ch <- prometheus.MustNewConstMetric(streamDroppedDesc, prometheus.CounterValue, float64(el.Dropped), id, client)
+		ch <- prometheus.MustNewConstMetric(streamQueuedDesc, prometheus.GaugeValue, float64(el.Queued), id, client)
+	}
+}
+
+// clientHost drops the ephemeral port of the client address,
+// which would only add to the cardinality of the series.
+func clientHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/krateoplatformops/eventsse/internal/broker"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBrokerCollector(t *testing.T) {
+	brk := broker.New(broker.Options{BufferSize: 1, Policy: broker.DropNewest})
+	defer brk.Close()
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&brokerCollector{broker: brk})
+
+	sub := brk.Subscribe("10.0.0.1:1234")
+	brk.Publish(broker.Message{ID: 1})
+	brk.Publish(broker.Message{ID: 2})
+	<-sub.C()
+	sub.MarkDelivered()
+
+	exp := `
+# HELP eventsse_sse_events_dropped_total Events dropped because the SSE stream queue was full.
+# TYPE eventsse_sse_events_dropped_total counter
+eventsse_sse_events_dropped_total 1
+# HELP eventsse_sse_stream_events_delivered_total Events sent to the open SSE stream.
+# TYPE eventsse_sse_stream_events_delivered_total counter
+eventsse_sse_stream_events_delivered_total{client="10.0.0.1",stream="1"} 1
+# HELP eventsse_sse_stream_events_dropped_total Events dropped because the open SSE stream queue was full.
+# TYPE eventsse_sse_stream_events_dropped_total counter
+eventsse_sse_stream_events_dropped_total{client="10.0.0.1",stream="1"} 1
+# HELP eventsse_sse_stream_events_queued Events queued for the open SSE stream.
+# TYPE eventsse_sse_stream_events_queued gauge
+eventsse_sse_stream_events_queued{client="10.0.0.1",stream="1"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(exp)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The closed streams disappear, their dropped events are still counted.
+	brk.Unsubscribe(sub)
+	if got := testutil.CollectAndCount(&brokerCollector{broker: brk}); got != 1 {
+		t.Fatalf("expected 1 metric, got %d", got)
+	}
+}
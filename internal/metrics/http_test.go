@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestRoute(t *testing.T) {
+	HTTPDuration.Reset()
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /events", Route("GET /events", http.HandlerFunc(func(wri http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Has("fail") {
+			http.Error(wri, "boom", http.StatusBadRequest)
+			return
+		}
+		if _, ok := wri.(http.Flusher); !ok {
+			t.Error("expected the writer to be an http.Flusher")
+		}
+		wri.Write([]byte("ok"))
+	})))
+
+	for _, url := range []string{"/events", "/events?fail", "/events"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, url, nil))
+	}
+
+	if got := testutil.CollectAndCount(HTTPDuration); got != 2 {
+		t.Fatalf("expected 2 histograms, got %d", got)
+	}
+
+	exp := map[string]uint64{"200": 2, "400": 1}
+	for code, count := range exp {
+		m := &dto.Metric{}
+		if err := HTTPDuration.WithLabelValues("GET /events", code).(prometheus.Metric).Write(m); err != nil {
+			t.Fatal(err)
+		}
+		if got := m.GetHistogram().GetSampleCount(); got != count {
+			t.Errorf("code %s: expected %d requests, got %d", code, count, got)
+		}
+	}
+}
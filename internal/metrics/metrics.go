@@ -0,0 +1,138 @@
+// Package metrics exposes the Prometheus metrics of the
+// ingestion, of the store and of the SSE streams.
+package metrics
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "eventsse"
+
+// The reasons why a received event is not stored.
+const (
+	ReasonInvalid    = "invalid"
+	ReasonTooLarge   = "too_large"
+	ReasonDuplicate  = "duplicate"
+	ReasonStoreError = "store_error"
+	ReasonSignature  = "signature"
+)
+
+// The reasons why a request is not authenticated.
+const (
+	ReasonMissingToken = "missing_token"
+	ReasonInvalidToken = "invalid_token"
+)
+
+var (
+	// Registry holds all the eventsse metrics, together
+	// with the Go runtime and process ones.
+	Registry = prometheus.NewRegistry()
+
+	// EventsReceived counts the events posted to '/handle'.
+	EventsReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_received_total",
+		Help:      "Events received by the ingestion endpoint.",
+	})
+	// EventsRejected counts the received events not stored, by reason.
+	EventsRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_rejected_total",
+		Help:      "Received events not stored, by reason.",
+	}, []string{"reason"})
+	// EventsStored counts the received events stored.
+	EventsStored = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_stored_total",
+		Help:      "Received events stored.",
+	})
+
+	// AuthRejected counts the requests without a valid token, by reason.
+	AuthRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_rejected_total",
+		Help:      "Requests rejected by the authentication, by reason.",
+	}, []string{"reason"})
+
+	// StoreDuration observes the latency of the store operations.
+	StoreDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "store_operation_duration_seconds",
+		Help:      "Latency of the store operations.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation"})
+	// StoreErrors counts the failed store operations.
+	StoreErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "store_errors_total",
+		Help:      "Failed store operations.",
+	}, []string{"operation"})
+
+	// SSEConnections is the number of the open SSE streams, by
+	// filter (the comma separated query parameters of the stream).
+	SSEConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sse_connections",
+		Help:      "Open SSE streams, by filter.",
+	}, []string{"filter"})
+	// SSEDelivered counts the events sent to all the SSE streams.
+	SSEDelivered = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sse_events_delivered_total",
+		Help:      "Events sent to the SSE streams.",
+	})
+
+	// HTTPDuration observes the duration of the requests, by route;
+	// for the SSE streams it is the duration of the whole stream.
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of the HTTP requests, by route and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "code"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		EventsReceived,
+		EventsRejected,
+		EventsStored,
+		AuthRejected,
+		StoreDuration,
+		StoreErrors,
+		SSEConnections,
+		SSEDelivered,
+		HTTPDuration,
+	)
+}
+
+// Handler returns the handler serving the metrics.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// RegisterCache exposes the number of items of the named cache.
+func RegisterCache(name string, size func() int) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "cache_items",
+		Help:        "Items of the TTL caches.",
+		ConstLabels: prometheus.Labels{"cache": name},
+	}, func() float64 { return float64(size()) }))
+}
+
+// FilterLabel returns the value of the filter label of
+// the SSEConnections for the given query parameters.
+func FilterLabel(fields []string) string {
+	if len(fields) == 0 {
+		return "none"
+	}
+	return strings.Join(fields, ",")
+}
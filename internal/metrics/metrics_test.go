@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFilterLabel(t *testing.T) {
+	if got := FilterLabel(nil); got != "none" {
+		t.Fatalf("expected %q, got %q", "none", got)
+	}
+	if got := FilterLabel([]string{"composition", "type"}); got != "composition,type" {
+		t.Fatalf("expected %q, got %q", "composition,type", got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	RegisterCache("test", func() int { return 3 })
+	EventsReceived.Inc()
+
+	rr := httptest.NewRecorder()
+	Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	body, _ := io.ReadAll(rr.Body)
+	for _, exp := range []string{
+		`eventsse_cache_items{cache="test"} 3`,
+		`eventsse_events_received_total `,
+		`go_goroutines `,
+	} {
+		if !strings.Contains(string(body), exp) {
+			t.Errorf("expected %q in the metrics", exp)
+		}
+	}
+}
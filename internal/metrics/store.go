@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/krateoplatformops/eventsse/internal/store"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Store returns the store observing the latency
+// and the errors of its Set and Get operations.
+func Store(sto store.Store) store.Store {
+	return &instrumentedStore{Store: sto}
+}
+
+type instrumentedStore struct {
+	store.Store
+}
+
+func (s *instrumentedStore) Set(k string, v *corev1.Event) (int64, error) {
+	start := time.Now()
+	rev, err := s.Store.Set(k, v)
+	observe("set", start, err)
+	return rev, err
+}
+
+func (s *instrumentedStore) Get(k string, opts store.GetOptions) ([]store.Record, bool, error) {
+	start := time.Now()
+	all, found, err := s.Store.Get(k, opts)
+	observe("get", start, err)
+	return all, found, err
+}
+
+// Ping forwards to the wrapped store, so that the
+// wrapper does not hide its store.Pinger implementation.
+func (s *instrumentedStore) Ping(ctx context.Context) error {
+	if p, ok := s.Store.(store.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func observe(op string, start time.Time, err error) {
+	StoreDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	// The duplicates are not failures.
+	if err != nil && !errors.Is(err, store.ErrDuplicate) {
+		StoreErrors.WithLabelValues(op).Inc()
+	}
+}
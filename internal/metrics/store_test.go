@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/krateoplatformops/eventsse/internal/store"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// MockStore fails the Set of the given keys.
+type MockStore struct {
+	store.Store
+	errs map[string]error
+}
+
+func (m *MockStore) Set(k string, _ *corev1.Event) (int64, error) {
+	return 1, m.errs[k]
+}
+
+func (m *MockStore) Get(k string, _ store.GetOptions) ([]store.Record, bool, error) {
+	return nil, false, m.errs[k]
+}
+
+func TestStore(t *testing.T) {
+	StoreDuration.Reset()
+	StoreErrors.Reset()
+
+	sto := Store(&MockStore{errs: map[string]error{
+		"dup":  store.ErrDuplicate,
+		"fail": errors.New("boom"),
+	}})
+
+	for _, k := range []string{"ok", "dup", "fail"} {
+		sto.Set(k, &corev1.Event{})
+	}
+	sto.Get("fail", store.GetOptions{})
+
+	if got := testutil.CollectAndCount(StoreDuration); got != 2 {
+		t.Fatalf("expected 2 histograms, got %d", got)
+	}
+	if got := testutil.ToFloat64(StoreErrors.WithLabelValues("set")); got != 1 {
+		t.Fatalf("expected 1 set error, got %v", got)
+	}
+	if got := testutil.ToFloat64(StoreErrors.WithLabelValues("get")); got != 1 {
+		t.Fatalf("expected 1 get error, got %v", got)
+	}
+}
+
+// pingStore is a store reachable or not.
+type pingStore struct {
+	store.Store
+	err error
+}
+
+func (p *pingStore) Ping(context.Context) error {
+	return p.err
+}
+
+func TestStorePing(t *testing.T) {
+	boom := errors.New("boom")
+	pinger, ok := Store(&pingStore{err: boom}).(store.Pinger)
+	if !ok {
+		t.Fatal("expected the wrapper to implement store.Pinger")
+	}
+	if err := pinger.Ping(context.Background()); !errors.Is(err, boom) {
+		t.Fatalf("expected %v, got %v", boom, err)
+	}
+}
@@ -0,0 +1,112 @@
+// Package auth authenticates the requests carrying a JSON Web
+// Token issued by the configured OpenID Connect provider.
+//
+// The token is read, in order, from:
+//   - the 'Authorization: Bearer <token>' header
+//   - the 'token' query parameter
+//   - the cookie with the configured name
+//
+// the last two are for the browsers EventSource, that
+// cannot set the request headers.
+package auth
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/krateoplatformops/eventsse/internal/metrics"
+	"github.com/krateoplatformops/eventsse/internal/oidc"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// DefaultCookie is the default name of the cookie holding the token.
+	DefaultCookie = "eventsse_token"
+
+	queryParam = "token"
+)
+
+// Options configures the authentication.
+type Options struct {
+	// Verifier verifies the tokens.
+	Verifier *oidc.Verifier
+	// Cookie is the name of the cookie holding the token.
+	Cookie string
+}
+
+type claimsKey struct{}
+
+// WithClaims returns a copy of the context carrying the claims.
+func WithClaims(ctx context.Context, claims oidc.Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFrom returns the claims of the token of
+// the request authenticated by the middleware.
+func ClaimsFrom(ctx context.Context) (oidc.Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(oidc.Claims)
+	return claims, ok
+}
+
+// Authenticate returns a middleware that rejects with
+// '401 Unauthorized' the requests without a valid token.
+func Authenticate(opts Options) func(next http.Handler) http.Handler {
+	if len(opts.Cookie) == 0 {
+		opts.Cookie = DefaultCookie
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(wri http.ResponseWriter, req *http.Request) {
+			log := zerolog.New(os.Stdout).With().
+				Str("service", "eventsse").
+				Timestamp().
+				Logger()
+
+			token := tokenFrom(req, opts.Cookie)
+			if len(token) == 0 {
+				metrics.AuthRejected.WithLabelValues(metrics.ReasonMissingToken).Inc()
+				wri.Header().Set("WWW-Authenticate", `Bearer realm="eventsse"`)
+				http.Error(wri, "Missing bearer token", http.StatusUnauthorized)
+				return
+			}
+
+			claims, err := opts.Verifier.Verify(token)
+			if err != nil {
+				log.Warn().Str("remoteAddr", req.RemoteAddr).Msg(err.Error())
+				metrics.AuthRejected.WithLabelValues(metrics.ReasonInvalidToken).Inc()
+				wri.Header().Set("WWW-Authenticate", `Bearer realm="eventsse", error="invalid_token"`)
+				http.Error(wri, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(wri, req.WithContext(WithClaims(req.Context(), claims)))
+		})
+	}
+}
+
+// tokenFrom returns the token of the request, or an empty string;
+// the 'token' query parameter is removed, so that it is not logged.
+func tokenFrom(req *http.Request, cookie string) string {
+	if val := req.Header.Get("Authorization"); len(val) > 0 {
+		scheme, token, ok := strings.Cut(val, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+
+	if q := req.URL.Query(); q.Has(queryParam) {
+		token := q.Get(queryParam)
+		q.Del(queryParam)
+		req.URL.RawQuery = q.Encode()
+		return token
+	}
+
+	if c, err := req.Cookie(cookie); err == nil {
+		return c.Value
+	}
+
+	return ""
+}
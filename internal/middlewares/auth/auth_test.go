@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/eventsse/internal/metrics"
+	"github.com/krateoplatformops/eventsse/internal/oidc"
+	"github.com/krateoplatformops/eventsse/internal/oidc/oidctest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// whoamiHandler writes back the subject of the token
+// and the query the request reached it with.
+func whoamiHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := ClaimsFrom(r.Context())
+	if !ok {
+		http.Error(w, "Claims not found in context", http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte(claims.Subject() + " " + r.URL.RawQuery))
+}
+
+func TestAuthenticate(t *testing.T) {
+	ks, err := oidc.NewKeySet(oidctest.JWKSFile(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := Authenticate(Options{
+		Verifier: oidc.NewVerifier(oidc.Options{
+			Keys:   ks,
+			Issuer: oidctest.Issuer,
+		}),
+	})(http.HandlerFunc(whoamiHandler))
+
+	token := oidctest.Token(t, oidctest.RSA, "", map[string]any{
+		"iss": oidctest.Issuer,
+		"sub": "cyberjoker",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	expired := oidctest.Token(t, oidctest.RSA, "", map[string]any{
+		"iss": oidctest.Issuer,
+		"sub": "cyberjoker",
+		"exp": time.Now().Add(-time.Hour).Unix(),
+	})
+
+	tests := []struct {
+		name     string
+		url      string
+		setup    func(req *http.Request)
+		status   int
+		reason   string
+		expected string
+	}{
+		{
+			name:     "Bearer",
+			url:      "/events?limit=1",
+			setup:    func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token) },
+			status:   http.StatusOK,
+			expected: "cyberjoker limit=1",
+		},
+		{
+			name:     "Query parameter",
+			url:      "/notifications?composition=abc&token=" + token,
+			status:   http.StatusOK,
+			expected: "cyberjoker composition=abc",
+		},
+		{
+			name:     "Cookie",
+			url:      "/notifications",
+			setup:    func(req *http.Request) { req.AddCookie(&http.Cookie{Name: DefaultCookie, Value: token}) },
+			status:   http.StatusOK,
+			expected: "cyberjoker ",
+		},
+		{
+			name:   "Missing",
+			url:    "/events",
+			status: http.StatusUnauthorized,
+			reason: metrics.ReasonMissingToken,
+		},
+		{
+			name:   "Other scheme",
+			url:    "/events",
+			setup:  func(req *http.Request) { req.Header.Set("Authorization", "Basic dXNlcjpwYXNz") },
+			status: http.StatusUnauthorized,
+			reason: metrics.ReasonMissingToken,
+		},
+		{
+			name:   "Expired",
+			url:    "/events",
+			setup:  func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+expired) },
+			status: http.StatusUnauthorized,
+			reason: metrics.ReasonInvalidToken,
+		},
+		{
+			name:   "Garbage",
+			url:    "/notifications?token=abc",
+			status: http.StatusUnauthorized,
+			reason: metrics.ReasonInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+			if err != nil {
+				t.Fatalf("could not create request: %v", err)
+			}
+			if tt.setup != nil {
+				tt.setup(req)
+			}
+
+			var rejected float64
+			if len(tt.reason) > 0 {
+				rejected = testutil.ToFloat64(metrics.AuthRejected.WithLabelValues(tt.reason))
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Fatalf("expected status %d, got %d (%s)", tt.status, rr.Code, rr.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				if got := testutil.ToFloat64(metrics.AuthRejected.WithLabelValues(tt.reason)); got != rejected+1 {
+					t.Fatalf("expected %v %s rejections, got %v", rejected+1, tt.reason, got)
+				}
+				if len(rr.Header().Get("WWW-Authenticate")) == 0 {
+					t.Fatal("expected a WWW-Authenticate header")
+				}
+				return
+			}
+			if got := rr.Body.String(); got != tt.expected {
+				t.Fatalf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+// Package cors lets the browsers, served by the allowed
+// origins, read the responses of the cross-origin requests.
+//
+// The preflight requests (OPTIONS with the
+// Access-Control-Request-Method header) are answered by the
+// middleware itself, so that the routes need not handle them.
+package cors
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// DefaultMethods are the methods allowed by default.
+	DefaultMethods = []string{http.MethodGet, http.MethodHead}
+	// DefaultHeaders are the request headers allowed by default.
+	DefaultHeaders = []string{"Authorization", "Content-Type", "Last-Event-ID"}
+)
+
+// Options configures the CORS policy.
+type Options struct {
+	// AllowedOrigins are the origins allowed to read the responses:
+	// exact (i.e. 'https://app.example.com'), with a wildcard
+	// subdomain (i.e. 'https://*.example.com') or '*' for any.
+	AllowedOrigins []string
+	// AllowedMethods are the methods of the allowed requests.
+	AllowedMethods []string
+	// AllowedHeaders are the headers the requests can set,
+	// '*' for any.
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers the browsers
+	// let the scripts read.
+	ExposedHeaders []string
+	// AllowCredentials lets the requests carry the cookies;
+	// it cannot be used together with '*'.
+	AllowCredentials bool
+	// MaxAge is how long the preflight responses can be cached.
+	MaxAge time.Duration
+}
+
+// ErrWildcardCredentials is returned when the credentials are
+// allowed together with '*': any site could then read the
+// responses on behalf of the logged users.
+//
+// The wildcard subdomains are fine: the browsers get the
+// matching origin itself, never '*'.
+var ErrWildcardCredentials = errors.New("the credentials cannot be allowed for any origin")
+
+// Handler returns a middleware applying the CORS policy,
+// or an error if the policy is not valid.
+func Handler(opts Options) (func(next http.Handler) http.Handler, error) {
+	p := newPolicy(opts)
+	if p.credentials && p.anyOrigin {
+		return nil, ErrWildcardCredentials
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(wri http.ResponseWriter, req *http.Request) {
+			if req.Method == http.MethodOptions && len(req.Header.Get("Access-Control-Request-Method")) > 0 {
+				p.preflight(wri, req)
+				return
+			}
+
+			p.actual(wri, req)
+			next.ServeHTTP(wri, req)
+		})
+	}, nil
+}
+
+type policy struct {
+	anyOrigin   bool
+	origins     map[string]bool
+	wildcards   [][2]string
+	methods     map[string]bool
+	anyHeader   bool
+	headers     map[string]bool
+	allowMethod string
+	allowHeader string
+	exposed     string
+	credentials bool
+	maxAge      string
+}
+
+func newPolicy(opts Options) *policy {
+	if len(opts.AllowedMethods) == 0 {
+		opts.AllowedMethods = DefaultMethods
+	}
+	if len(opts.AllowedHeaders) == 0 {
+		opts.AllowedHeaders = DefaultHeaders
+	}
+
+	p := &policy{
+		origins:     map[string]bool{},
+		methods:     map[string]bool{},
+		headers:     map[string]bool{},
+		credentials: opts.AllowCredentials,
+	}
+
+	for _, el := range opts.AllowedOrigins {
+		el = strings.ToLower(strings.TrimSpace(el))
+		switch {
+		case el == "*":
+			p.anyOrigin = true
+		case strings.Contains(el, "*"):
+			before, after, _ := strings.Cut(el, "*")
+			p.wildcards = append(p.wildcards, [2]string{before, after})
+		case len(el) > 0:
+			p.origins[el] = true
+		}
+	}
+
+	methods := make([]string, 0, len(opts.AllowedMethods))
+	for _, el := range opts.AllowedMethods {
+		el = strings.ToUpper(strings.TrimSpace(el))
+		if len(el) > 0 {
+			p.methods[el] = true
+			methods = append(methods, el)
+		}
+	}
+	p.allowMethod = strings.Join(methods, ",")
+
+	headers := make([]string, 0, len(opts.AllowedHeaders))
+	for _, el := range opts.AllowedHeaders {
+		el = strings.TrimSpace(el)
+		switch {
+		case el == "*":
+			p.anyHeader = true
+		case len(el) > 0:
+			p.headers[http.CanonicalHeaderKey(el)] = true
+			headers = append(headers, el)
+		}
+	}
+	p.allowHeader = strings.Join(headers, ",")
+
+	exposed := make([]string, 0, len(opts.ExposedHeaders))
+	for _, el := range opts.ExposedHeaders {
+		if el = strings.TrimSpace(el); len(el) > 0 {
+			exposed = append(exposed, el)
+		}
+	}
+	p.exposed = strings.Join(exposed, ",")
+
+	if opts.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(opts.MaxAge.Seconds()))
+	}
+
+	return p
+}
+
+// preflight answers the preflight requests: the CORS headers
+// are set only if the origin, the method and all the
+// headers of the request are allowed.
+func (p *policy) preflight(wri http.ResponseWriter, req *http.Request) {
+	wri.Header().Add("Vary", "Origin")
+	wri.Header().Add("Vary", "Access-Control-Request-Method")
+	wri.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	origin := req.Header.Get("Origin")
+	method := strings.ToUpper(req.Header.Get("Access-Control-Request-Method"))
+	if !p.allowOrigin(origin) || !p.methods[method] {
+		wri.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	requested := req.Header.Get("Access-Control-Request-Headers")
+	for _, el := range strings.Split(requested, ",") {
+		el = strings.TrimSpace(el)
+		if len(el) > 0 && !p.anyHeader && !p.headers[http.CanonicalHeaderKey(el)] {
+			wri.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	p.setOrigin(wri, origin)
+	wri.Header().Set("Access-Control-Allow-Methods", p.allowMethod)
+	if p.anyHeader && len(requested) > 0 {
+		wri.Header().Set("Access-Control-Allow-Headers", requested)
+	} else if len(p.allowHeader) > 0 {
+		wri.Header().Set("Access-Control-Allow-Headers", p.allowHeader)
+	}
+	if len(p.maxAge) > 0 {
+		wri.Header().Set("Access-Control-Max-Age", p.maxAge)
+	}
+	wri.WriteHeader(http.StatusNoContent)
+}
+
+// actual sets the CORS headers of the response
+// to a request made by an allowed origin.
+func (p *policy) actual(wri http.ResponseWriter, req *http.Request) {
+	wri.Header().Add("Vary", "Origin")
+
+	origin := req.Header.Get("Origin")
+	if !p.allowOrigin(origin) {
+		return
+	}
+
+	p.setOrigin(wri, origin)
+	if len(p.exposed) > 0 {
+		wri.Header().Set("Access-Control-Expose-Headers", p.exposed)
+	}
+}
+
+func (p *policy) setOrigin(wri http.ResponseWriter, origin string) {
+	if p.anyOrigin {
+		wri.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+
+	wri.Header().Set("Access-Control-Allow-Origin", origin)
+	if p.credentials {
+		wri.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (p *policy) allowOrigin(origin string) bool {
+	if len(origin) == 0 {
+		return false
+	}
+	if p.anyOrigin {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+	for _, el := range p.wildcards {
+		if len(origin) > len(el[0])+len(el[1]) &&
+			strings.HasPrefix(origin, el[0]) && strings.HasSuffix(origin, el[1]) &&
+			isSubdomain(origin[len(el[0]):len(origin)-len(el[1])]) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSubdomain reports whether the part of an origin matched by
+// a wildcard is made of host name labels only, so that i.e. a
+// port or a user cannot be smuggled in.
+func isSubdomain(s string) bool {
+	for _, label := range strings.Split(s, ".") {
+		if len(label) == 0 {
+			return false
+		}
+		for _, c := range label {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
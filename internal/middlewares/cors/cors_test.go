@@ -0,0 +1,259 @@
+package cors
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func okHandler(wri http.ResponseWriter, _ *http.Request) {
+	wri.WriteHeader(http.StatusOK)
+}
+
+func mustHandler(t *testing.T, opts Options) func(next http.Handler) http.Handler {
+	t.Helper()
+	mw, err := Handler(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mw
+}
+
+func TestActual(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        Options
+		origin      string
+		expected    string
+		credentials bool
+	}{
+		{
+			name:     "any origin",
+			opts:     Options{AllowedOrigins: []string{"*"}},
+			origin:   "https://app.example.com",
+			expected: "*",
+		},
+		{
+			name:        "exact with credentials",
+			opts:        Options{AllowedOrigins: []string{"https://app.example.com"}, AllowCredentials: true},
+			origin:      "https://app.example.com",
+			expected:    "https://app.example.com",
+			credentials: true,
+		},
+		{
+			name:     "exact",
+			opts:     Options{AllowedOrigins: []string{"https://app.example.com"}},
+			origin:   "https://App.example.com",
+			expected: "https://App.example.com",
+		},
+		{
+			name:   "exact mismatch",
+			opts:   Options{AllowedOrigins: []string{"https://app.example.com"}},
+			origin: "http://app.example.com",
+		},
+		{
+			name:     "wildcard subdomain",
+			opts:     Options{AllowedOrigins: []string{"https://*.example.com"}},
+			origin:   "https://a.b.example.com",
+			expected: "https://a.b.example.com",
+		},
+		{
+			name:        "wildcard subdomain with credentials",
+			opts:        Options{AllowedOrigins: []string{"https://*.example.com"}, AllowCredentials: true},
+			origin:      "https://app.example.com",
+			expected:    "https://app.example.com",
+			credentials: true,
+		},
+		{
+			name:   "wildcard smuggled host",
+			opts:   Options{AllowedOrigins: []string{"https://*.example.com"}, AllowCredentials: true},
+			origin: "https://evil.com:1@x.example.com",
+		},
+		{
+			name:   "wildcard apex",
+			opts:   Options{AllowedOrigins: []string{"https://*.example.com"}},
+			origin: "https://example.com",
+		},
+		{
+			name:   "wildcard other domain",
+			opts:   Options{AllowedOrigins: []string{"https://*.example.com"}},
+			origin: "https://app.example.org",
+		},
+		{
+			name: "no origin",
+			opts: Options{AllowedOrigins: []string{"*"}},
+		},
+		{
+			name:   "no allowed origins",
+			opts:   Options{},
+			origin: "https://app.example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.ExposedHeaders = []string{"X-Continue"}
+			handler := mustHandler(t, tt.opts)(http.HandlerFunc(okHandler))
+
+			req := httptest.NewRequest(http.MethodGet, "/events", nil)
+			if len(tt.origin) > 0 {
+				req.Header.Set("Origin", tt.origin)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tt.expected {
+				t.Errorf("expected origin %q, got %q", tt.expected, got)
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Credentials") == "true"; got != tt.credentials {
+				t.Errorf("expected credentials %t, got %t", tt.credentials, got)
+			}
+
+			exposed := ""
+			if len(tt.expected) > 0 {
+				exposed = "X-Continue"
+			}
+			if got := rr.Header().Get("Access-Control-Expose-Headers"); got != exposed {
+				t.Errorf("expected exposed headers %q, got %q", exposed, got)
+			}
+			if got := rr.Header().Get("Vary"); got != "Origin" {
+				t.Errorf("expected Vary %q, got %q", "Origin", got)
+			}
+		})
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	opts := Options{
+		AllowedOrigins: []string{"https://*.example.com"},
+		MaxAge:         10 * time.Minute,
+	}
+
+	tests := []struct {
+		name     string
+		opts     *Options
+		origin   string
+		method   string
+		headers  string
+		allowed  bool
+		expected string
+	}{
+		{name: "allowed", origin: "https://app.example.com", method: "GET", headers: "authorization, last-event-id", allowed: true},
+		{name: "no headers", origin: "https://app.example.com", method: "GET", allowed: true},
+		{name: "origin", origin: "https://app.example.org", method: "GET"},
+		{name: "method", origin: "https://app.example.com", method: "DELETE"},
+		{name: "header", origin: "https://app.example.com", method: "GET", headers: "X-Custom"},
+		{
+			name:     "any header",
+			opts:     &Options{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"*"}},
+			origin:   "https://app.example.org",
+			method:   "GET",
+			headers:  "X-Custom",
+			allowed:  true,
+			expected: "X-Custom",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := opts
+			if tt.opts != nil {
+				o = *tt.opts
+			}
+
+			called := false
+			handler := mustHandler(t, o)(http.HandlerFunc(func(wri http.ResponseWriter, _ *http.Request) {
+				called = true
+			}))
+
+			req := httptest.NewRequest(http.MethodOptions, "/notifications", nil)
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", tt.method)
+			if len(tt.headers) > 0 {
+				req.Header.Set("Access-Control-Request-Headers", tt.headers)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if called {
+				t.Fatal("expected the preflight not to reach the handler")
+			}
+			if rr.Code != http.StatusNoContent {
+				t.Fatalf("expected status %d, got %d", http.StatusNoContent, rr.Code)
+			}
+
+			got := rr.Header().Get("Access-Control-Allow-Origin")
+			if !tt.allowed {
+				if len(got) > 0 {
+					t.Fatalf("expected no CORS headers, got origin %q", got)
+				}
+				return
+			}
+
+			if got != tt.origin && got != "*" {
+				t.Errorf("expected origin %q, got %q", tt.origin, got)
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Methods"); got != "GET,HEAD" {
+				t.Errorf("expected methods %q, got %q", "GET,HEAD", got)
+			}
+
+			expected := tt.expected
+			if len(expected) == 0 {
+				expected = "Authorization,Content-Type,Last-Event-ID"
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Headers"); got != expected {
+				t.Errorf("expected headers %q, got %q", expected, got)
+			}
+
+			if tt.opts == nil {
+				if got := rr.Header().Get("Access-Control-Max-Age"); got != "600" {
+					t.Errorf("expected max age %q, got %q", "600", got)
+				}
+			}
+		})
+	}
+}
+
+func TestOptionsWithoutPreflight(t *testing.T) {
+	called := false
+	handler := mustHandler(t, Options{AllowedOrigins: []string{"*"}})(http.HandlerFunc(func(wri http.ResponseWriter, _ *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest(http.MethodOptions, "/events", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !called {
+		t.Fatal("expected a plain OPTIONS request to reach the handler")
+	}
+}
+
+func TestWildcardCredentials(t *testing.T) {
+	tests := []struct {
+		origins  []string
+		expected error
+	}{
+		{origins: []string{"*"}, expected: ErrWildcardCredentials},
+		{origins: []string{"https://app.example.com", "*"}, expected: ErrWildcardCredentials},
+		{origins: []string{"https://app.example.com", "https://*.example.com"}},
+		{origins: []string{"https://app.example.com"}},
+		{origins: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.origins, ","), func(t *testing.T) {
+			_, err := Handler(Options{AllowedOrigins: tt.origins, AllowCredentials: true})
+			if !errors.Is(err, tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
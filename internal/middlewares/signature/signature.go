@@ -0,0 +1,272 @@
+// Package signature verifies the HMAC signature of the
+// requests made by the trusted senders (i.e. the eventrouter).
+//
+// The sender signs the request body together with the time
+// of the request, using a shared secret:
+//
+//	X-Eventsse-Timestamp: 1720164787
+//	X-Eventsse-Signature: sha256=hex(HMAC-SHA256(secret, timestamp + "." + body))
+//
+// Several signatures can be sent, comma separated, and several
+// secrets can be active at the same time, so that the secrets
+// can be rotated without losing any request.
+package signature
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krateoplatformops/eventsse/internal/metrics"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// TimestampHeader holds the Unix time (in seconds) of the request.
+	TimestampHeader = "X-Eventsse-Timestamp"
+	// SignatureHeader holds the signatures of the request.
+	SignatureHeader = "X-Eventsse-Signature"
+
+	// DefaultTolerance is the default max difference between
+	// the request timestamp and the local clock.
+	DefaultTolerance = 5 * time.Minute
+
+	scheme = "sha256="
+
+	// maxBodyBytes is the max size of a signed request body.
+	maxBodyBytes = 16 << 20
+)
+
+var (
+	ErrNoSecrets        = errors.New("no HMAC secrets")
+	ErrMissingSignature = errors.New("missing request signature")
+	ErrInvalidTimestamp = errors.New("invalid request timestamp")
+	ErrExpiredTimestamp = errors.New("request timestamp out of the tolerance window")
+	ErrInvalidSignature = errors.New("invalid request signature")
+)
+
+// Options configures the signature verification.
+type Options struct {
+	// Secrets are the active shared secrets.
+	Secrets []string
+	// SecretFile is a file holding the active shared secrets, one
+	// per line; it is read again when it changes (i.e. when
+	// the mounted Kubernetes Secret is updated).
+	SecretFile string
+	// Tolerance is the max difference between the request
+	// timestamp and the local clock; older (or newer) requests
+	// are rejected, so that they cannot be replayed later.
+	Tolerance time.Duration
+
+	now func() time.Time
+}
+
+// IsEmpty reports whether no secrets are configured.
+func (o *Options) IsEmpty() bool {
+	return len(o.Secrets) == 0 && len(o.SecretFile) == 0
+}
+
+// Sign returns the signature of the body sent at the given
+// time, in the format of the SignatureHeader.
+func Sign(secret []byte, ts time.Time, body []byte) string {
+	return scheme + hex.EncodeToString(mac(secret, strconv.FormatInt(ts.Unix(), 10), body))
+}
+
+// Verify returns a middleware that rejects with '401 Unauthorized'
+// the requests without a valid signature made with one of the secrets.
+func Verify(opts Options) (func(next http.Handler) http.Handler, error) {
+	v, err := newVerifier(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(wri http.ResponseWriter, req *http.Request) {
+			log := zerolog.New(os.Stdout).With().
+				Str("service", "eventsse").
+				Timestamp().
+				Logger()
+
+			body, err := io.ReadAll(http.MaxBytesReader(wri, req.Body, maxBodyBytes))
+			if err != nil {
+				log.Error().Msg(err.Error())
+				var maxBytesError *http.MaxBytesError
+				if errors.As(err, &maxBytesError) {
+					rejected(metrics.ReasonTooLarge)
+					http.Error(wri, "Request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				rejected(metrics.ReasonInvalid)
+				http.Error(wri, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if len(v.file) > 0 {
+				if err := v.reload(); err != nil {
+					// Keep using the last known secrets.
+					log.Error().Err(err).Msg("could not read HMAC secrets")
+				}
+			}
+
+			if err := v.verify(req.Header, body); err != nil {
+				log.Warn().Str("remoteAddr", req.RemoteAddr).Msg(err.Error())
+				rejected(metrics.ReasonSignature)
+				http.Error(wri, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(wri, req)
+		})
+	}, nil
+}
+
+type verifier struct {
+	static    [][]byte
+	file      string
+	tolerance time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	modTime  time.Time
+	fromFile [][]byte
+}
+
+// rejected counts the request as a received event not
+// stored, for the given reason: it does not reach the
+// ingestion handler.
+func rejected(reason string) {
+	metrics.EventsReceived.Inc()
+	metrics.EventsRejected.WithLabelValues(reason).Inc()
+}
+
+func newVerifier(opts Options) (*verifier, error) {
+	v := &verifier{
+		file:      opts.SecretFile,
+		tolerance: opts.Tolerance,
+		now:       opts.now,
+	}
+	if v.tolerance <= 0 {
+		v.tolerance = DefaultTolerance
+	}
+	if v.now == nil {
+		v.now = time.Now
+	}
+
+	for _, el := range opts.Secrets {
+		if el = strings.TrimSpace(el); len(el) > 0 {
+			v.static = append(v.static, []byte(el))
+		}
+	}
+
+	if len(v.file) > 0 {
+		if err := v.reload(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(v.secrets()) == 0 {
+		return nil, ErrNoSecrets
+	}
+
+	return v, nil
+}
+
+func (v *verifier) verify(h http.Header, body []byte) error {
+	sigs := h.Get(SignatureHeader)
+	ts := h.Get(TimestampHeader)
+	if len(sigs) == 0 || len(ts) == 0 {
+		return ErrMissingSignature
+	}
+
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrInvalidTimestamp
+	}
+	if d := v.now().Sub(time.Unix(sec, 0)); d > v.tolerance || d < -v.tolerance {
+		return ErrExpiredTimestamp
+	}
+
+	secrets := v.secrets()
+	for _, el := range strings.Split(sigs, ",") {
+		sig, ok := strings.CutPrefix(strings.TrimSpace(el), scheme)
+		if !ok {
+			continue
+		}
+		got, err := hex.DecodeString(sig)
+		if err != nil {
+			continue
+		}
+
+		for _, key := range secrets {
+			if hmac.Equal(got, mac(key, ts, body)) {
+				return nil
+			}
+		}
+	}
+
+	return ErrInvalidSignature
+}
+
+// secrets returns the active secrets.
+func (v *verifier) secrets() [][]byte {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return append(v.fromFile[:len(v.fromFile):len(v.fromFile)], v.static...)
+}
+
+// reload reads again the secret file, if it changed since the last time.
+func (v *verifier) reload() error {
+	nfo, err := os.Stat(v.file)
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if nfo.ModTime().Equal(v.modTime) {
+		return nil
+	}
+
+	f, err := os.Open(v.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var all [][]byte
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); len(line) > 0 {
+			all = append(all, []byte(line))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("reading %s: %w", v.file, err)
+	}
+
+	v.fromFile = all
+	v.modTime = nfo.ModTime()
+	return nil
+}
+
+func mac(secret []byte, ts string, body []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(ts))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
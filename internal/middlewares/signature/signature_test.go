@@ -0,0 +1,245 @@
+package signature
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/eventsse/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var testNow = time.Date(2024, 7, 5, 7, 33, 7, 0, time.UTC)
+
+// echoHandler writes back the request body, to check
+// that it is still readable after the verification.
+func echoHandler(w http.ResponseWriter, r *http.Request) {
+	io.Copy(w, r.Body)
+}
+
+// signedRequest returns a request signed with the given
+// secrets, at the given time.
+func signedRequest(t *testing.T, body string, ts time.Time, secrets ...string) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, "/handle", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+
+	sigs := make([]string, len(secrets))
+	for i, el := range secrets {
+		sigs[i] = Sign([]byte(el), ts, []byte(body))
+	}
+
+	req.Header.Set(TimestampHeader, strconv.FormatInt(ts.Unix(), 10))
+	req.Header.Set(SignatureHeader, strings.Join(sigs, ","))
+	return req
+}
+
+func TestVerify(t *testing.T) {
+	const body = `{"metadata":{"name":"event1"}}`
+
+	mw, err := Verify(Options{
+		Secrets:   []string{"new-secret", " old-secret "},
+		Tolerance: time.Minute,
+		now:       func() time.Time { return testNow },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := mw(http.HandlerFunc(echoHandler))
+
+	tests := []struct {
+		name   string
+		req    func() *http.Request
+		status int
+	}{
+		{
+			name:   "Valid",
+			req:    func() *http.Request { return signedRequest(t, body, testNow, "new-secret") },
+			status: http.StatusOK,
+		},
+		{
+			name:   "Rotated secret",
+			req:    func() *http.Request { return signedRequest(t, body, testNow, "old-secret") },
+			status: http.StatusOK,
+		},
+		{
+			name:   "Many signatures",
+			req:    func() *http.Request { return signedRequest(t, body, testNow, "unknown", "new-secret") },
+			status: http.StatusOK,
+		},
+		{
+			name:   "Clock skew",
+			req:    func() *http.Request { return signedRequest(t, body, testNow.Add(50*time.Second), "new-secret") },
+			status: http.StatusOK,
+		},
+		{
+			name:   "Unknown secret",
+			req:    func() *http.Request { return signedRequest(t, body, testNow, "unknown") },
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "Missing signature",
+			req: func() *http.Request {
+				req := signedRequest(t, body, testNow, "new-secret")
+				req.Header.Del(SignatureHeader)
+				return req
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "Missing timestamp",
+			req: func() *http.Request {
+				req := signedRequest(t, body, testNow, "new-secret")
+				req.Header.Del(TimestampHeader)
+				return req
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "Invalid timestamp",
+			req: func() *http.Request {
+				req := signedRequest(t, body, testNow, "new-secret")
+				req.Header.Set(TimestampHeader, "yesterday")
+				return req
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "Replayed",
+			req:    func() *http.Request { return signedRequest(t, body, testNow.Add(-2*time.Minute), "new-secret") },
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "Tampered body",
+			req: func() *http.Request {
+				req := signedRequest(t, body, testNow, "new-secret")
+				req.Body = io.NopCloser(strings.NewReader(`{"metadata":{"name":"event2"}}`))
+				return req
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "Tampered timestamp",
+			req: func() *http.Request {
+				req := signedRequest(t, body, testNow, "new-secret")
+				req.Header.Set(TimestampHeader, strconv.FormatInt(testNow.Unix()+1, 10))
+				return req
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "Malformed signature",
+			req: func() *http.Request {
+				req := signedRequest(t, body, testNow, "new-secret")
+				req.Header.Set(SignatureHeader, "sha256=zzz,md5=abc")
+				return req
+			},
+			status: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rejected := testutil.ToFloat64(metrics.EventsRejected.WithLabelValues(metrics.ReasonSignature))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, tt.req())
+
+			if rr.Code != tt.status {
+				t.Fatalf("expected status %d, got %d (%s)", tt.status, rr.Code, rr.Body.String())
+			}
+			if tt.status == http.StatusOK && rr.Body.String() != body {
+				t.Fatalf("expected body %q, got %q", body, rr.Body.String())
+			}
+
+			exp := rejected
+			if tt.status == http.StatusUnauthorized {
+				exp++
+			}
+			if got := testutil.ToFloat64(metrics.EventsRejected.WithLabelValues(metrics.ReasonSignature)); got != exp {
+				t.Fatalf("expected %v rejected events, got %v", exp, got)
+			}
+		})
+	}
+}
+
+func TestVerifySecretFile(t *testing.T) {
+	const body = `{}`
+
+	path := filepath.Join(t.TempDir(), "secrets")
+	if err := os.WriteFile(path, []byte("secret-1\n\nsecret-2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	mw, err := Verify(Options{
+		SecretFile: path,
+		now:        func() time.Time { return testNow },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := mw(http.HandlerFunc(echoHandler))
+
+	send := func(secret string) int {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, signedRequest(t, body, testNow, secret))
+		return rr.Code
+	}
+
+	for _, el := range []string{"secret-1", "secret-2"} {
+		if got := send(el); got != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", el, http.StatusOK, got)
+		}
+	}
+
+	// Rotate: secret-1 is retired, secret-3 is added.
+	if err := os.WriteFile(path, []byte("secret-2\nsecret-3\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	for el, exp := range map[string]int{
+		"secret-1": http.StatusUnauthorized,
+		"secret-2": http.StatusOK,
+		"secret-3": http.StatusOK,
+	} {
+		if got := send(el); got != exp {
+			t.Fatalf("%s: expected status %d, got %d", el, exp, got)
+		}
+	}
+
+	// A missing file keeps the last known secrets.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := send("secret-3"); got != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, got)
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	if _, err := Verify(Options{}); err != ErrNoSecrets {
+		t.Fatalf("expected %v, got %v", ErrNoSecrets, err)
+	}
+
+	if _, err := Verify(Options{Secrets: []string{" "}}); err != ErrNoSecrets {
+		t.Fatalf("expected %v, got %v", ErrNoSecrets, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "missing")
+	if _, err := Verify(Options{SecretFile: path}); err == nil {
+		t.Fatal("expected an error")
+	}
+}
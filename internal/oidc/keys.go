@@ -0,0 +1,378 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRefresh is how long the keys fetched
+	// from an URL are cached by default.
+	DefaultRefresh = time.Hour
+
+	// minRefetch is the min interval between two fetches caused
+	// by unknown key ids, so that forged tokens cannot flood
+	// the identity provider.
+	minRefetch = 30 * time.Second
+
+	maxJWKSBytes = 1 << 20
+)
+
+// ErrUnknownKey is returned when no key matches the token key id.
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// jwk is a JSON Web Key (RFC 7517), only the
+// fields of the supported public keys.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// key is a verification key of a key set.
+type key struct {
+	id   string
+	algs map[string]bool // The algorithms the key verifies.
+	pub  crypto.PublicKey
+}
+
+// parseJWKS returns the signature verification keys of a
+// JSON Web Key Set; the unsupported keys are skipped.
+func parseJWKS(data []byte) ([]key, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	res := make([]key, 0, len(set.Keys))
+	for _, el := range set.Keys {
+		if len(el.Use) > 0 && el.Use != "sig" {
+			continue
+		}
+
+		pub, err := el.publicKey()
+		if err != nil {
+			continue
+		}
+
+		// The algorithm is bound to the key type and curve: a
+		// key declaring an algorithm of another type is invalid.
+		algs := el.algs()
+		if len(el.Alg) > 0 {
+			if !algs[el.Alg] {
+				continue
+			}
+			algs = map[string]bool{el.Alg: true}
+		}
+
+		res = append(res, key{id: el.Kid, algs: algs, pub: pub})
+	}
+
+	return res, nil
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var crv elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			crv = elliptic.P256()
+		case "P-384":
+			crv = elliptic.P384()
+		case "P-521":
+			crv = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !crv.IsOnCurve(x, y) {
+			return nil, errors.New("invalid EC point")
+		}
+		return &ecdsa.PublicKey{Curve: crv, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// algs returns the signing algorithms that fit
+// the key type and, for the EC keys, the curve.
+func (k *jwk) algs() map[string]bool {
+	switch k.Kty {
+	case "RSA":
+		return map[string]bool{
+			"RS256": true, "RS384": true, "RS512": true,
+			"PS256": true, "PS384": true, "PS512": true,
+		}
+	case "EC":
+		switch k.Crv {
+		case "P-256":
+			return map[string]bool{"ES256": true}
+		case "P-384":
+			return map[string]bool{"ES384": true}
+		case "P-521":
+			return map[string]bool{"ES512": true}
+		}
+	case "OKP":
+		return map[string]bool{"EdDSA": true}
+	}
+	return nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	dat, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(dat) == 0 {
+		return nil, errors.New("empty key parameter")
+	}
+	return new(big.Int).SetBytes(dat), nil
+}
+
+// KeySet is a JSON Web Key Set loaded from a file or
+// an URL; the keys are cached:
+//   - the file is read again when it changes
+//   - the URL is fetched again after the refresh interval, or
+//     when a token is signed with an unknown key (i.e. the
+//     identity provider rotated its keys)
+type KeySet struct {
+	src     string
+	remote  bool
+	refresh time.Duration
+	client  *http.Client
+	now     func() time.Time
+
+	mu      sync.Mutex
+	keys    []key
+	loaded  time.Time // When the keys were fetched or the file was modified.
+	fetched time.Time // When the URL was fetched last time, even if failing.
+	running *loadCall // The load in progress, if any.
+}
+
+// loadCall is a load of the keys shared by all
+// the callers that need it while in progress.
+type loadCall struct {
+	done chan struct{}
+	err  error
+}
+
+// NewKeySet returns the key set loaded from the given file
+// or http(s) URL, cached for the refresh interval.
+func NewKeySet(src string, refresh time.Duration) (*KeySet, error) {
+	if refresh <= 0 {
+		refresh = DefaultRefresh
+	}
+
+	ks := &KeySet{
+		src:     src,
+		remote:  strings.HasPrefix(src, "https://") || strings.HasPrefix(src, "http://"),
+		refresh: refresh,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		now:     time.Now,
+	}
+
+	ks.fetched = ks.now()
+	keys, loaded, err := ks.read(ks.fetched)
+	if err != nil {
+		return nil, err
+	}
+	ks.keys, ks.loaded = keys, loaded
+
+	return ks, nil
+}
+
+// keysFor returns the keys to verify a token signed with the given
+// key id and algorithm; all the keys if the token has no key id.
+//
+// The stale keys are still served while they are loaded again;
+// a token signed with an unknown key waits for the load instead,
+// either started by it or already in progress.
+func (ks *KeySet) keysFor(kid, alg string) ([]key, error) {
+	now := ks.now()
+
+	ks.mu.Lock()
+	stale := ks.isStale(now)
+	refetch := (ks.remote && now.Sub(ks.fetched) >= minRefetch) || ks.running != nil
+	res := ks.match(kid, alg)
+	ks.mu.Unlock()
+
+	switch {
+	case len(res) > 0 && stale:
+		// Keep using the last known keys if failing.
+		ks.load()
+	case len(res) == 0 && (stale || refetch):
+		call := ks.load()
+		<-call.done
+		if call.err != nil {
+			return nil, call.err
+		}
+
+		ks.mu.Lock()
+		res = ks.match(kid, alg)
+		ks.mu.Unlock()
+	}
+
+	if len(res) == 0 {
+		return nil, ErrUnknownKey
+	}
+	return res, nil
+}
+
+func (ks *KeySet) match(kid, alg string) []key {
+	var res []key
+	for _, el := range ks.keys {
+		if len(kid) > 0 && el.id != kid {
+			continue
+		}
+		if !el.algs[alg] {
+			continue
+		}
+		res = append(res, el)
+	}
+	return res
+}
+
+func (ks *KeySet) isStale(now time.Time) bool {
+	if ks.remote {
+		return now.Sub(ks.fetched) >= ks.refresh
+	}
+
+	nfo, err := os.Stat(ks.src)
+	return err == nil && !nfo.ModTime().Equal(ks.loaded)
+}
+
+// load starts loading the keys, unless a load is already
+// in progress; the returned call is done when the keys are
+// loaded. The keys are read without holding the lock, so that
+// a slow identity provider does not hold back the requests.
+func (ks *KeySet) load() *loadCall {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if ks.running != nil {
+		return ks.running
+	}
+
+	call := &loadCall{done: make(chan struct{})}
+	ks.running = call
+	if ks.remote {
+		ks.fetched = ks.now()
+	}
+	now := ks.fetched
+
+	go func() {
+		keys, loaded, err := ks.read(now)
+
+		ks.mu.Lock()
+		if err == nil {
+			ks.keys, ks.loaded = keys, loaded
+		}
+		ks.running = nil
+		ks.mu.Unlock()
+
+		call.err = err
+		close(call.done)
+	}()
+
+	return call
+}
+
+// read reads the keys, together with the time of the
+// file change, or the given one if fetched from the URL.
+func (ks *KeySet) read(now time.Time) ([]key, time.Time, error) {
+	var dat []byte
+	var loaded time.Time
+	var err error
+	if ks.remote {
+		loaded = now
+		dat, err = ks.fetch()
+	} else {
+		var nfo os.FileInfo
+		if nfo, err = os.Stat(ks.src); err == nil {
+			loaded = nfo.ModTime()
+			dat, err = os.ReadFile(ks.src)
+		}
+	}
+	if err != nil {
+		return nil, loaded, fmt.Errorf("loading JWKS from %s: %w", ks.src, err)
+	}
+
+	keys, err := parseJWKS(dat)
+	if err != nil {
+		return nil, loaded, err
+	}
+	return keys, loaded, nil
+}
+
+func (ks *KeySet) fetch() ([]byte, error) {
+	res, err := ks.client.Get(ks.src)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(res.Body, maxJWKSBytes))
+}
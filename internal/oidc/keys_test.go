@@ -0,0 +1,241 @@
+package oidc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/eventsse/internal/oidc/oidctest"
+)
+
+func TestParseJWKS(t *testing.T) {
+	dat, err := os.ReadFile(oidctest.JWKSFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := parseJWKS(dat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The encryption key is skipped.
+	ids := []string{oidctest.RSA, oidctest.EC, oidctest.Ed25519}
+	if len(keys) != len(ids) {
+		t.Fatalf("expected %d keys, got %d", len(ids), len(keys))
+	}
+	for i, el := range keys {
+		if el.id != ids[i] {
+			t.Errorf("key %d: expected %q, got %q", i, ids[i], el.id)
+		}
+	}
+
+	if _, err := parseJWKS([]byte("{")); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestKeyAlgs(t *testing.T) {
+	dat, err := os.ReadFile(oidctest.JWKSFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var set struct {
+		Keys []map[string]any `json:"keys"`
+	}
+	if err := json.Unmarshal(dat, &set); err != nil {
+		t.Fatal(err)
+	}
+
+	// The keys do not declare their algorithm, but a copy
+	// of the RSA one declares an algorithm of the EC keys.
+	wrong := map[string]any{}
+	for _, el := range set.Keys {
+		delete(el, "alg")
+		if el["kid"] == oidctest.RSA {
+			for k, v := range el {
+				wrong[k] = v
+			}
+		}
+	}
+	wrong["kid"], wrong["alg"] = "test-wrong", "ES256"
+	set.Keys = append(set.Keys, wrong)
+
+	dat, err = json.Marshal(&set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := parseJWKS(dat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := &KeySet{keys: keys}
+
+	tests := []struct {
+		kid   string
+		alg   string
+		found bool
+	}{
+		{oidctest.RSA, "RS384", true},
+		{oidctest.RSA, "PS512", true},
+		{oidctest.RSA, "ES256", false},
+		{oidctest.EC, "ES256", true},
+		{oidctest.EC, "ES384", false},
+		{oidctest.EC, "RS256", false},
+		{oidctest.Ed25519, "EdDSA", true},
+		{oidctest.Ed25519, "ES256", false},
+		{"test-wrong", "ES256", false},
+		{"test-wrong", "RS256", false},
+	}
+
+	for _, tt := range tests {
+		if got := len(ks.match(tt.kid, tt.alg)) > 0; got != tt.found {
+			t.Errorf("%s %s: expected found %t, got %t", tt.kid, tt.alg, tt.found, got)
+		}
+	}
+}
+
+func TestKeySetURL(t *testing.T) {
+	dat, err := os.ReadFile(oidctest.JWKSFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hits atomic.Int32
+	var empty atomic.Bool
+	empty.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if empty.Load() {
+			w.Write([]byte(`{"keys":[]}`))
+			return
+		}
+		w.Write(dat)
+	}))
+	defer srv.Close()
+
+	ks, err := NewKeySet(srv.URL, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	ks.now = func() time.Time { return now }
+
+	if _, err := ks.keysFor(oidctest.RSA, "RS256"); err != ErrUnknownKey {
+		t.Fatalf("expected %v, got %v", ErrUnknownKey, err)
+	}
+	if got := hits.Load(); got != 1 {
+		t.Fatalf("expected the keys to be cached, got %d fetches", got)
+	}
+
+	// The identity provider rotates its keys: an unknown key id
+	// causes a fetch, but not sooner than minRefetch.
+	empty.Store(false)
+	now = now.Add(minRefetch)
+	if _, err := ks.keysFor(oidctest.RSA, "RS256"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.keysFor(oidctest.EC, "ES256"); err != nil {
+		t.Fatal(err)
+	}
+	if got := hits.Load(); got != 2 {
+		t.Fatalf("expected 2 fetches, got %d", got)
+	}
+
+	// The cache expires: the keys are fetched again in the background.
+	now = now.Add(time.Hour)
+	if _, err := ks.keysFor(oidctest.RSA, "RS256"); err != nil {
+		t.Fatal(err)
+	}
+	<-ks.load().done
+	if got := hits.Load(); got != 3 {
+		t.Fatalf("expected 3 fetches, got %d", got)
+	}
+
+	// The last known keys are kept if the identity provider is down.
+	srv.Close()
+	now = now.Add(time.Hour)
+	if _, err := ks.keysFor(oidctest.RSA, "RS256"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeySetSlowRefresh(t *testing.T) {
+	dat, err := os.ReadFile(oidctest.JWKSFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hits atomic.Int32
+	var slow atomic.Bool
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if slow.Load() {
+			<-release
+		}
+		w.Write(dat)
+	}))
+	defer srv.Close()
+
+	ks, err := NewKeySet(srv.URL, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Add(time.Hour)
+	ks.now = func() time.Time { return now }
+
+	// The identity provider hangs: the cached keys are
+	// served meanwhile, and the keys are fetched once.
+	slow.Store(true)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ks.keysFor(oidctest.RSA, "RS256"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The unknown keys wait for the fetch in progress.
+	done := make(chan error)
+	go func() {
+		_, err := ks.keysFor("unknown", "RS256")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("expected to wait for the fetch, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-done; err != ErrUnknownKey {
+		t.Fatalf("expected %v, got %v", ErrUnknownKey, err)
+	}
+	if got := hits.Load(); got != 2 {
+		t.Fatalf("expected 2 fetches, got %d", got)
+	}
+}
+
+func TestKeySetErrors(t *testing.T) {
+	if _, err := NewKeySet("/does/not/exist.json", 0); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	if _, err := NewKeySet(srv.URL, 0); err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -0,0 +1,265 @@
+// Package oidc verifies the JSON Web Tokens (RFC 7519) issued
+// by an OpenID Connect provider, signed with the keys of a
+// JSON Web Key Set.
+//
+// Only the asymmetric algorithms are supported (RS*, PS*,
+// ES* and EdDSA), so a token cannot be forged knowing
+// the public keys; the algorithm of a token must fit the
+// type and curve of the key it is verified with.
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformed        = errors.New("malformed token")
+	ErrUnsupportedAlg   = errors.New("unsupported signing algorithm")
+	ErrInvalidSignature = errors.New("invalid token signature")
+	ErrInvalidIssuer    = errors.New("invalid token issuer")
+	ErrInvalidAudience  = errors.New("invalid token audience")
+	ErrExpired          = errors.New("token is expired")
+	ErrNotYetValid      = errors.New("token is not valid yet")
+)
+
+// supportedAlgs are the supported signing algorithms (RFC 7518, RFC 8037).
+var supportedAlgs = map[string]bool{
+	"RS256": true, "RS384": true, "RS512": true,
+	"PS256": true, "PS384": true, "PS512": true,
+	"ES256": true, "ES384": true, "ES512": true,
+	"EdDSA": true,
+}
+
+// DefaultLeeway is the default clock skew allowed
+// checking the token validity period.
+const DefaultLeeway = time.Minute
+
+// Claims are the claims of a verified token.
+type Claims map[string]any
+
+// Subject returns the 'sub' claim.
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+// String returns the named string claim, or an empty string.
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns the named claim, either a string or an array of strings.
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		res := make([]string, 0, len(v))
+		for _, el := range v {
+			if s, ok := el.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	default:
+		return nil
+	}
+}
+
+// Options configures the token verification.
+type Options struct {
+	// Keys are the keys the tokens are signed with.
+	Keys *KeySet
+	// Issuer is the expected 'iss' claim.
+	Issuer string
+	// Audience is the expected 'aud' claim, not checked if empty.
+	Audience string
+	// Leeway is the clock skew allowed checking
+	// the 'exp' and 'nbf' claims.
+	Leeway time.Duration
+
+	now func() time.Time
+}
+
+// Verifier verifies the tokens.
+type Verifier struct {
+	opts Options
+}
+
+// NewVerifier returns a Verifier.
+func NewVerifier(opts Options) *Verifier {
+	if opts.Leeway <= 0 {
+		opts.Leeway = DefaultLeeway
+	}
+	if opts.now == nil {
+		opts.now = time.Now
+	}
+	return &Verifier{opts: opts}
+}
+
+// Verify checks the token signature, issuer, audience and
+// validity period, and returns its claims.
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	var hdr struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &hdr); err != nil {
+		return nil, err
+	}
+
+	if !supportedAlgs[hdr.Alg] {
+		return nil, ErrUnsupportedAlg
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	keys, err := v.opts.Keys.keysFor(hdr.Kid, hdr.Alg)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	err = ErrInvalidSignature
+	for _, el := range keys {
+		if err = verifySignature(hdr.Alg, el.pub, signed, sig); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	if err := v.validate(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (v *Verifier) validate(claims Claims) error {
+	if claims.String("iss") != v.opts.Issuer {
+		return ErrInvalidIssuer
+	}
+
+	if aud := v.opts.Audience; len(aud) > 0 {
+		found := false
+		for _, el := range claims.Strings("aud") {
+			if el == aud {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrInvalidAudience
+		}
+	}
+
+	now := v.opts.now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("%w: missing 'exp' claim", ErrMalformed)
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(v.opts.Leeway)) {
+		return ErrExpired
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok {
+		if now.Add(v.opts.Leeway).Before(time.Unix(int64(nbf), 0)) {
+			return ErrNotYetValid
+		}
+	}
+
+	return nil
+}
+
+func decodeSegment(seg string, dst any) error {
+	dat, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return ErrMalformed
+	}
+	if err := json.Unmarshal(dat, dst); err != nil {
+		return ErrMalformed
+	}
+	return nil
+}
+
+func verifySignature(alg string, pub crypto.PublicKey, signed, sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	case "EdDSA":
+		k, ok := pub.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(k, signed, sig) {
+			return ErrInvalidSignature
+		}
+		return nil
+	default:
+		return ErrUnsupportedAlg
+	}
+
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS":
+		k, ok := pub.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(k, hash, digest, sig) != nil {
+			return ErrInvalidSignature
+		}
+	case "PS":
+		k, ok := pub.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPSS(k, hash, digest, sig, nil) != nil {
+			return ErrInvalidSignature
+		}
+	case "ES":
+		k, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrInvalidSignature
+		}
+		// The signature is the concatenation of R and S (RFC 7518).
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return ErrInvalidSignature
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return ErrInvalidSignature
+		}
+	}
+
+	return nil
+}
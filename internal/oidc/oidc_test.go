@@ -0,0 +1,204 @@
+package oidc
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/eventsse/internal/oidc/oidctest"
+)
+
+var testNow = time.Date(2024, 7, 5, 7, 33, 7, 0, time.UTC)
+
+func newVerifier(t *testing.T, audience string) *Verifier {
+	t.Helper()
+
+	ks, err := NewKeySet(oidctest.JWKSFile(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewVerifier(Options{
+		Keys:     ks,
+		Issuer:   oidctest.Issuer,
+		Audience: audience,
+		now:      func() time.Time { return testNow },
+	})
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"iss":    oidctest.Issuer,
+		"sub":    "cyberjoker",
+		"aud":    []string{"eventsse", "other"},
+		"exp":    testNow.Add(time.Hour).Unix(),
+		"groups": []string{"devs", "admins"},
+	}
+}
+
+func TestVerify(t *testing.T) {
+	v := newVerifier(t, "eventsse")
+
+	for _, tt := range []struct{ kid, alg string }{
+		{oidctest.RSA, "RS256"},
+		{oidctest.RSA, ""},
+		{oidctest.EC, ""},
+		{oidctest.Ed25519, ""},
+	} {
+		t.Run(tt.kid+tt.alg, func(t *testing.T) {
+			claims, err := v.Verify(oidctest.Token(t, tt.kid, tt.alg, validClaims()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := claims.Subject(); got != "cyberjoker" {
+				t.Errorf("subject: got %q, expected %q", got, "cyberjoker")
+			}
+			if got := claims.Strings("groups"); len(got) != 2 || got[1] != "admins" {
+				t.Errorf("groups: got %v", got)
+			}
+		})
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	v := newVerifier(t, "eventsse")
+
+	with := func(name string, val any) map[string]any {
+		res := validClaims()
+		if val == nil {
+			delete(res, name)
+		} else {
+			res[name] = val
+		}
+		return res
+	}
+
+	valid := oidctest.Token(t, oidctest.RSA, "", validClaims())
+	parts := strings.Split(valid, ".")
+
+	tests := []struct {
+		name     string
+		token    string
+		expected error
+	}{
+		{
+			name:     "Malformed",
+			token:    "abc.def",
+			expected: ErrMalformed,
+		},
+		{
+			name:     "Issuer",
+			token:    oidctest.Token(t, oidctest.RSA, "", with("iss", "https://evil.example.com")),
+			expected: ErrInvalidIssuer,
+		},
+		{
+			name:     "Audience",
+			token:    oidctest.Token(t, oidctest.RSA, "", with("aud", "other")),
+			expected: ErrInvalidAudience,
+		},
+		{
+			name:     "Expired",
+			token:    oidctest.Token(t, oidctest.RSA, "", with("exp", testNow.Add(-2*time.Minute).Unix())),
+			expected: ErrExpired,
+		},
+		{
+			name:     "No expiry",
+			token:    oidctest.Token(t, oidctest.RSA, "", with("exp", nil)),
+			expected: ErrMalformed,
+		},
+		{
+			name:     "Not yet valid",
+			token:    oidctest.Token(t, oidctest.RSA, "", with("nbf", testNow.Add(2*time.Minute).Unix())),
+			expected: ErrNotYetValid,
+		},
+		{
+			// The key is restricted to RS256.
+			name:     "Algorithm not allowed by the key",
+			token:    oidctest.Token(t, oidctest.RSA, "PS256", validClaims()),
+			expected: ErrUnknownKey,
+		},
+		{
+			name:     "Unsupported algorithm",
+			token:    encodeHeader(`{"alg":"none","kid":"test-rsa"}`) + "." + parts[1] + ".",
+			expected: ErrUnsupportedAlg,
+		},
+		{
+			name:     "Symmetric algorithm",
+			token:    encodeHeader(`{"alg":"HS256","kid":"test-rsa"}`) + "." + parts[1] + "." + parts[2],
+			expected: ErrUnsupportedAlg,
+		},
+		{
+			name:     "Unknown key",
+			token:    encodeHeader(`{"alg":"RS256","kid":"unknown"}`) + "." + parts[1] + "." + parts[2],
+			expected: ErrUnknownKey,
+		},
+		{
+			// Encryption keys are not used to verify the signatures.
+			name:     "Encryption key",
+			token:    encodeHeader(`{"alg":"RS256","kid":"test-enc"}`) + "." + parts[1] + "." + parts[2],
+			expected: ErrUnknownKey,
+		},
+		{
+			name:     "Tampered claims",
+			token:    parts[0] + "." + encodeHeader(`{"iss":"`+oidctest.Issuer+`","sub":"admin"}`) + "." + parts[2],
+			expected: ErrInvalidSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.Verify(tt.token)
+			if !errors.Is(err, tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestKeySetReload(t *testing.T) {
+	dat, err := os.ReadFile(oidctest.JWKSFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, []byte(`{"keys":[]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err := NewKeySet(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewVerifier(Options{
+		Keys:   ks,
+		Issuer: oidctest.Issuer,
+		now:    func() time.Time { return testNow },
+	})
+
+	token := oidctest.Token(t, oidctest.EC, "", validClaims())
+	if _, err := v.Verify(token); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expected %v, got %v", ErrUnknownKey, err)
+	}
+
+	// The keys are rotated.
+	if err := os.WriteFile(path, dat, 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := v.Verify(token); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func encodeHeader(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
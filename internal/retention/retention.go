@@ -0,0 +1,247 @@
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krateoplatformops/eventsse/internal/filter"
+	"github.com/krateoplatformops/eventsse/internal/labels"
+	"github.com/krateoplatformops/eventsse/internal/store"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// pageSize is the number of events read from
+	// the store at each step of a compaction.
+	pageSize = 500
+
+	// anyType is the MaxAge key that applies to
+	// the event types without a rule of their own.
+	anyType = "*"
+)
+
+// Policy tells which events to keep; the zero value keeps them all
+// (the store TTL still applies).
+type Policy struct {
+	// MaxPerComposition is the max number of events kept for
+	// each composition (and for the events not related to any);
+	// the most recently written ones are kept.
+	MaxPerComposition int
+	// MaxAge is the max age of the events, by type (case insensitive);
+	// the "*" key applies to the types not listed.
+	MaxAge map[string]time.Duration
+	// MaxBytes caps the size of the stored keys and values;
+	// the least recently written events are deleted first.
+	MaxBytes int64
+}
+
+// IsEmpty reports whether the policy keeps all the events.
+func (p *Policy) IsEmpty() bool {
+	return p.MaxPerComposition <= 0 && len(p.MaxAge) == 0 && p.MaxBytes <= 0
+}
+
+func (p *Policy) maxAge(typ string) (time.Duration, bool) {
+	if d, ok := p.MaxAge[strings.ToLower(typ)]; ok {
+		return d, true
+	}
+	d, ok := p.MaxAge[anyType]
+	return d, ok
+}
+
+// ParseMaxAge parses a list of max ages by event type,
+// i.e. "Warning=7d,Normal=1h,*=24h".
+func ParseMaxAge(s string) (map[string]time.Duration, error) {
+	res := map[string]time.Duration{}
+	for _, el := range strings.Split(s, ",") {
+		el = strings.TrimSpace(el)
+		if len(el) == 0 {
+			continue
+		}
+
+		typ, val, ok := strings.Cut(el, "=")
+		if !ok || len(strings.TrimSpace(typ)) == 0 {
+			return nil, fmt.Errorf("invalid max age rule %q: expected <type>=<duration>", el)
+		}
+
+		d, err := parseDuration(strings.TrimSpace(val))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid max age rule %q: bad duration", el)
+		}
+
+		res[strings.ToLower(strings.TrimSpace(typ))] = d
+	}
+
+	return res, nil
+}
+
+// parseDuration parses a Go duration, or a number of days (i.e. "7d").
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Compactor enforces a retention policy on the events of a store.
+type Compactor struct {
+	store  store.Store
+	policy Policy
+	now    func() time.Time
+}
+
+// New creates a new Compactor.
+func New(sto store.Store, p Policy) *Compactor {
+	return &Compactor{
+		store:  sto,
+		policy: p,
+		now:    time.Now,
+	}
+}
+
+// Run compacts the store at every interval, until the context is done.
+func (c *Compactor) Run(ctx context.Context, interval time.Duration) {
+	log := zerolog.New(os.Stdout).With().
+		Str("service", "eventsse").
+		Timestamp().
+		Logger()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			count, err := c.Compact()
+			if err != nil {
+				log.Error().Err(err).Msg("could not compact stored events")
+			}
+			if count > 0 {
+				log.Info().Msgf("[%d] stored events deleted by the retention policy", count)
+			}
+		}
+	}
+}
+
+// entry is a stored event the compactor may delete.
+type entry struct {
+	key         string
+	rev         int64
+	size        int64
+	composition string
+}
+
+// Compact deletes the events that the policy does not keep
+// and returns how many they are.
+func (c *Compactor) Compact() (int, error) {
+	if c.policy.IsEmpty() {
+		return 0, nil
+	}
+
+	now := c.now()
+
+	// Events to delete, and to keep, in revision order.
+	var drop, keep []entry
+
+	var rev int64
+	for {
+		all, err := c.store.Since(rev, pageSize)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, el := range all {
+			rev = el.Revision
+
+			size, err := sizeOf(&el)
+			if err != nil {
+				return 0, err
+			}
+
+			e := entry{
+				key:         el.Key,
+				rev:         el.Revision,
+				size:        size,
+				composition: labels.CompositionID(&el.Event),
+			}
+
+			if d, ok := c.policy.maxAge(el.Event.Type); ok && now.Sub(filter.Timestamp(&el.Event)) > d {
+				drop = append(drop, e)
+				continue
+			}
+			keep = append(keep, e)
+		}
+
+		if len(all) < pageSize {
+			break
+		}
+	}
+
+	if n := c.policy.MaxPerComposition; n > 0 {
+		count := map[string]int{}
+		res := keep[:0:0]
+		// Newest first.
+		for i := len(keep) - 1; i >= 0; i-- {
+			e := keep[i]
+			count[e.composition]++
+			if count[e.composition] > n {
+				drop = append(drop, e)
+				continue
+			}
+			res = append(res, e)
+		}
+		// Back to revision order.
+		slices.Reverse(res)
+		keep = res
+	}
+
+	if c.policy.MaxBytes > 0 {
+		var total int64
+		for _, e := range keep {
+			total += e.size
+		}
+		for _, e := range keep {
+			if total <= c.policy.MaxBytes {
+				break
+			}
+			drop = append(drop, e)
+			total -= e.size
+		}
+	}
+
+	// The events written again since the scan are left alone:
+	// their new version is judged by the next run.
+	var n int
+	for _, e := range drop {
+		ok, err := c.store.DeleteRevision(e.key, e.rev)
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// sizeOf estimates the space taken by a stored event: its
+// key and value, twice, since the time index holds a copy.
+func sizeOf(el *store.Record) (int64, error) {
+	dat, err := json.Marshal(&el.Event)
+	if err != nil {
+		return 0, err
+	}
+	return 2 * int64(len(el.Key)+len(dat)), nil
+}
@@ -0,0 +1,226 @@
+package retention
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/eventsse/internal/store"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var now = time.Date(2024, 7, 5, 12, 0, 0, 0, time.UTC)
+
+type sample struct {
+	name        string
+	composition string
+	typ         string
+	age         time.Duration
+}
+
+func setup(t *testing.T, all []sample) *store.Memory {
+	t.Helper()
+
+	sto := store.NewMemory(store.MemoryOptions{})
+	for _, el := range all {
+		ev := &corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: el.name,
+				UID:  types.UID(el.name),
+			},
+			Type:          el.typ,
+			LastTimestamp: metav1.NewTime(now.Add(-el.age)),
+		}
+		if len(el.composition) > 0 {
+			ev.Labels = map[string]string{"krateo.io/composition-id": el.composition}
+		}
+
+		if _, err := sto.Set(sto.PrepareKey(ev), ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return sto
+}
+
+func names(t *testing.T, sto store.Store) []string {
+	t.Helper()
+
+	all, err := sto.Since(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := make([]string, len(all))
+	for i, el := range all {
+		res[i] = el.Event.Name
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestCompact(t *testing.T) {
+	samples := []sample{
+		{name: "a1", composition: "a", typ: "Warning", age: 48 * time.Hour},
+		{name: "a2", composition: "a", typ: "Normal", age: 2 * time.Hour},
+		{name: "a3", composition: "a", typ: "Normal", age: 30 * time.Minute},
+		{name: "a4", composition: "a", typ: "Warning", age: 10 * time.Minute},
+		{name: "b1", composition: "b", typ: "Normal", age: 10 * time.Minute},
+		{name: "x1", typ: "Normal", age: 3 * time.Hour},
+	}
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		deleted  int
+		expected []string
+	}{
+		{
+			name:     "empty",
+			expected: []string{"a1", "a2", "a3", "a4", "b1", "x1"},
+		},
+		{
+			name:     "max per composition",
+			policy:   Policy{MaxPerComposition: 2},
+			deleted:  2,
+			expected: []string{"a3", "a4", "b1", "x1"},
+		},
+		{
+			name: "max age by type",
+			policy: Policy{MaxAge: map[string]time.Duration{
+				"warning": 7 * 24 * time.Hour,
+				"normal":  time.Hour,
+			}},
+			deleted:  2,
+			expected: []string{"a1", "a3", "a4", "b1"},
+		},
+		{
+			name:     "max age any type",
+			policy:   Policy{MaxAge: map[string]time.Duration{"warning": 7 * 24 * time.Hour, "*": time.Hour}},
+			deleted:  2,
+			expected: []string{"a1", "a3", "a4", "b1"},
+		},
+		{
+			name:     "max bytes",
+			policy:   Policy{MaxBytes: 1},
+			deleted:  6,
+			expected: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sto := setup(t, samples)
+			defer sto.Close()
+
+			c := New(sto, tt.policy)
+			c.now = func() time.Time { return now }
+
+			deleted, err := c.Compact()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted != tt.deleted {
+				t.Fatalf("deleted: got %d, expected %d", deleted, tt.deleted)
+			}
+
+			if got := names(t, sto); fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Fatalf("got %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCompactMaxBytes(t *testing.T) {
+	sto := setup(t, []sample{
+		{name: "1", typ: "Normal"},
+		{name: "2", typ: "Normal"},
+		{name: "3", typ: "Normal"},
+	})
+	defer sto.Close()
+
+	all, _ := sto.Since(0, 0)
+
+	// Room for the newest two.
+	var size int64
+	for _, el := range all[1:] {
+		n, err := sizeOf(&el)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += n
+	}
+
+	c := New(sto, Policy{MaxBytes: size})
+	if deleted, err := c.Compact(); err != nil || deleted != 1 {
+		t.Fatalf("deleted: got %d (%v), expected 1", deleted, err)
+	}
+
+	if got := names(t, sto); fmt.Sprint(got) != "[2 3]" {
+		t.Fatalf("got %v, expected [2 3]", got)
+	}
+}
+
+// rewriter is a store where each event is written again
+// just before it is deleted, as if updated after the scan.
+type rewriter struct {
+	*store.Memory
+}
+
+func (r rewriter) DeleteRevision(k string, rev int64) (bool, error) {
+	all, _, err := r.Get(k, store.GetOptions{Limit: 1})
+	if err != nil || len(all) == 0 {
+		return false, err
+	}
+
+	ev := all[0].Event
+	ev.Count++
+	if _, err := r.Set(k, &ev); err != nil {
+		return false, err
+	}
+
+	return r.Memory.DeleteRevision(k, rev)
+}
+
+func TestCompactUpdated(t *testing.T) {
+	sto := setup(t, []sample{
+		{name: "1", typ: "Normal", age: 2 * time.Hour},
+		{name: "2", typ: "Normal"},
+	})
+	defer sto.Close()
+
+	c := New(rewriter{sto}, Policy{MaxAge: map[string]time.Duration{"*": time.Hour}})
+	c.now = func() time.Time { return now }
+
+	if deleted, err := c.Compact(); err != nil || deleted != 0 {
+		t.Fatalf("deleted: got %d (%v), expected 0", deleted, err)
+	}
+
+	if got := names(t, sto); fmt.Sprint(got) != "[1 2]" {
+		t.Fatalf("got %v, expected [1 2]", got)
+	}
+}
+
+func TestParseMaxAge(t *testing.T) {
+	got, err := ParseMaxAge("Warning=7d, Normal=1h,*=30m")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]time.Duration{
+		"warning": 7 * 24 * time.Hour,
+		"normal":  time.Hour,
+		"*":       30 * time.Minute,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(exp) {
+		t.Fatalf("got %v, expected %v", got, exp)
+	}
+
+	for _, s := range []string{"Warning", "=1h", "Normal=soon", "Normal=-1h"} {
+		if _, err := ParseMaxAge(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
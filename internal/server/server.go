@@ -0,0 +1,44 @@
+// Package server builds the HTTP listeners: each one
+// has its own address, timeouts and middlewares.
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Middleware wraps an http.Handler.
+type Middleware func(next http.Handler) http.Handler
+
+// Options configures a listener.
+type Options struct {
+	// Port is the port to listen on.
+	Port int
+	// ReadTimeout is the max duration for reading the entire request.
+	ReadTimeout time.Duration
+	// WriteTimeout is the max duration before timing out the
+	// writes of the response (0 for no timeout).
+	WriteTimeout time.Duration
+	// IdleTimeout is the max amount of time to wait for the
+	// next request when keep-alives are enabled.
+	IdleTimeout time.Duration
+	// Middlewares wrap every request of the listener;
+	// the first one is the outermost.
+	Middlewares []Middleware
+}
+
+// New returns the server for the given handler.
+func New(handler http.Handler, opts Options) *http.Server {
+	for i := len(opts.Middlewares) - 1; i >= 0; i-- {
+		handler = opts.Middlewares[i](handler)
+	}
+
+	return &http.Server{
+		Addr:         fmt.Sprintf(":%d", opts.Port),
+		Handler:      handler,
+		ReadTimeout:  opts.ReadTimeout,
+		WriteTimeout: opts.WriteTimeout,
+		IdleTimeout:  opts.IdleTimeout,
+	}
+}
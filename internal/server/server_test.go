@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	trace := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Trace", name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+
+	srv := New(mux, Options{
+		Port:         8182,
+		ReadTimeout:  time.Second,
+		WriteTimeout: 2 * time.Second,
+		IdleTimeout:  3 * time.Second,
+		Middlewares:  []Middleware{trace("first"), trace("second")},
+	})
+
+	if srv.Addr != ":8182" {
+		t.Errorf("addr: got %q, expected %q", srv.Addr, ":8182")
+	}
+	if srv.ReadTimeout != time.Second || srv.WriteTimeout != 2*time.Second || srv.IdleTimeout != 3*time.Second {
+		t.Errorf("unexpected timeouts: %v %v %v", srv.ReadTimeout, srv.WriteTimeout, srv.IdleTimeout)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/ping", nil)
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rr, req)
+
+	if rr.Body.String() != "pong" {
+		t.Fatalf("expected %q, got %q", "pong", rr.Body.String())
+	}
+
+	got := rr.Header().Values("X-Trace")
+	if len(got) != 2 || got[0] != "first" || got[1] != "second" {
+		t.Fatalf("expected the middlewares in order, got %v", got)
+	}
+}
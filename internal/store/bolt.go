@@ -0,0 +1,492 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	boltOpenTimeout             = 1 * time.Second
+	_               TTLSetter   = (*Bolt)(nil)
+	_               KeyPreparer = (*Bolt)(nil)
+	_               Watcher     = (*Bolt)(nil)
+	_               Store       = (*Bolt)(nil)
+	_               Pinger      = (*Bolt)(nil)
+)
+
+// The database has three buckets:
+//
+//   - events: the events, under the same keys used in etcd,
+//     both the primary ones and the time index ones
+//   - revisions: the primary key of each event, by revision
+//   - expiries: the primary key of each event, by expiry time
+//     and revision
+//
+// Revisions come from the sequence of the revisions bucket,
+// so they keep increasing across restarts.
+var (
+	eventsBucket    = []byte("events")
+	revisionsBucket = []byte("revisions")
+	expiriesBucket  = []byte("expiries")
+)
+
+// boltValue is the value stored in the events bucket.
+type boltValue struct {
+	Revision int64 `json:"revision"`
+	// Expiry is the expiry time in Unix nanoseconds;
+	// zero if the event never expires.
+	Expiry int64 `json:"expiry,omitempty"`
+	// Updated is true if the event replaced another one.
+	Updated bool         `json:"updated,omitempty"`
+	Event   corev1.Event `json:"event"`
+}
+
+func (v *boltValue) isExpired(now time.Time) bool {
+	return v.Expiry > 0 && now.UnixNano() >= v.Expiry
+}
+
+// BoltOptions are the options for the bbolt store.
+type BoltOptions struct {
+	// Path of the database file; it is created if missing.
+	Path string
+}
+
+// Bolt is a Store implementation on an embedded bbolt
+// database file, with the same key layout of the etcd one.
+//
+// The events survive restarts, but they are not shared
+// among replicas: the file can be opened by one process only.
+type Bolt struct {
+	db     *bolt.DB
+	ttl    time.Duration
+	notify chan struct{} // Closed and replaced at each write.
+	done   chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once
+	mu     sync.RWMutex
+	now    func() time.Time
+}
+
+// NewBolt opens (or creates) the database file and starts
+// a goroutine to periodically remove the expired events.
+//
+// You must call the Close() method on the store when you're done working with it.
+func NewBolt(opts BoltOptions) (*Bolt, error) {
+	db, err := bolt.Open(opts.Path, 0o600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{eventsBucket, revisionsBucket, expiriesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	b := &Bolt{
+		db:     db,
+		notify: make(chan struct{}),
+		done:   make(chan struct{}),
+		now:    time.Now,
+	}
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+
+		ticker := time.NewTicker(sweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-b.done:
+				return
+			case <-ticker.C:
+				b.sweep()
+			}
+		}
+	}()
+
+	return b, nil
+}
+
+func (b *Bolt) SetTTL(ttl int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.ttl = time.Duration(ttl) * time.Second
+}
+
+func (b *Bolt) PrepareKey(ev *corev1.Event) string {
+	return eventKey(ev)
+}
+
+func (b *Bolt) PreparePrefix(compositionId string) string {
+	return prefixKey(compositionId)
+}
+
+// Set stores the given value for the given key and
+// returns the revision at which it has been written.
+//
+// The time index entry is written in the same transaction; the
+// concurrent writes share the transaction, and so the disk sync.
+//
+// If the same or a newer version of the event is already stored,
+// nothing is written and ErrDuplicate is returned, together
+// with the revision of the stored event.
+func (b *Bolt) Set(k string, v *corev1.Event) (int64, error) {
+	b.mu.RLock()
+	ttl := b.ttl
+	b.mu.RUnlock()
+
+	var rev int64
+	var dup bool
+	// The function may run more than once, if another
+	// one of the same transaction fails.
+	err := b.db.Batch(func(tx *bolt.Tx) error {
+		var updated bool
+		dup = false
+		if dat := tx.Bucket(eventsBucket).Get([]byte(k)); dat != nil {
+			var old boltValue
+			if err := json.Unmarshal(dat, &old); err != nil {
+				return err
+			}
+
+			if !old.isExpired(b.now()) {
+				if isStale(&old.Event, v) {
+					// Not an error, that would roll back
+					// the other writes of the transaction.
+					rev, dup = old.Revision, true
+					return nil
+				}
+				updated = true
+			}
+		}
+
+		if err := remove(tx, k); err != nil {
+			return err
+		}
+
+		seq, err := tx.Bucket(revisionsBucket).NextSequence()
+		if err != nil {
+			return err
+		}
+		rev = int64(seq)
+
+		val := boltValue{Revision: rev, Updated: updated, Event: *v}
+		if ttl > 0 {
+			val.Expiry = b.now().Add(ttl).UnixNano()
+		}
+
+		dat, err := json.Marshal(&val)
+		if err != nil {
+			return err
+		}
+
+		events := tx.Bucket(eventsBucket)
+		if err := events.Put([]byte(k), dat); err != nil {
+			return err
+		}
+		if ik := indexKey(k, v); len(ik) > 0 {
+			if err := events.Put([]byte(ik), dat); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Bucket(revisionsBucket).Put(itob(rev), []byte(k)); err != nil {
+			return err
+		}
+		if val.Expiry > 0 {
+			return tx.Bucket(expiriesBucket).Put(expiryKey(val.Expiry, rev), []byte(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if dup {
+		return rev, ErrDuplicate
+	}
+
+	b.mu.Lock()
+	close(b.notify)
+	b.notify = make(chan struct{})
+	b.mu.Unlock()
+
+	return rev, nil
+}
+
+// Get retrieves, in descending key order, the stored values
+// whose key starts with the given one.
+func (b *Bolt) Get(k string, opts GetOptions) (data []Record, found bool, err error) {
+	now := b.now()
+	prefix := []byte(k)
+
+	err = b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(eventsBucket).Cursor()
+
+		// Position the cursor on the last key of the range.
+		end := []byte(opts.EndKey)
+		if len(end) == 0 {
+			end = []byte(prefixEnd(k))
+		}
+
+		var key, dat []byte
+		if len(end) > 0 {
+			if key, _ = c.Seek(end); key != nil {
+				key, dat = c.Prev()
+			} else {
+				key, dat = c.Last()
+			}
+		} else {
+			key, dat = c.Last()
+		}
+
+		for ; key != nil; key, dat = c.Prev() {
+			if bytes.Compare(key, prefix) < 0 || (len(opts.EndKey) == 0 && !bytes.HasPrefix(key, prefix)) {
+				break
+			}
+
+			var val boltValue
+			if err := json.Unmarshal(dat, &val); err != nil {
+				return err
+			}
+
+			if val.isExpired(now) {
+				continue
+			}
+			if opts.Match != nil && !opts.Match(&val.Event) {
+				continue
+			}
+
+			data = append(data, Record{
+				Key:      string(key),
+				Revision: val.Revision,
+				Event:    val.Event,
+				Updated:  val.Updated,
+			})
+			if opts.Limit > 0 && len(data) == opts.Limit {
+				break
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return data, false, err
+	}
+
+	// If no value was found return false
+	return data, len(data) > 0, nil
+}
+
+// Since retrieves, oldest first, the events written after the given revision.
+//
+// A limit of zero means no limit.
+func (b *Bolt) Since(rev int64, limit int) (data []Record, err error) {
+	now := b.now()
+
+	err = b.db.View(func(tx *bolt.Tx) error {
+		events := tx.Bucket(eventsBucket)
+
+		c := tx.Bucket(revisionsBucket).Cursor()
+		for r, key := c.Seek(itob(rev + 1)); r != nil; r, key = c.Next() {
+			dat := events.Get(key)
+			if dat == nil {
+				continue
+			}
+
+			var val boltValue
+			if err := json.Unmarshal(dat, &val); err != nil {
+				return err
+			}
+
+			if val.isExpired(now) {
+				continue
+			}
+
+			data = append(data, Record{
+				Key:      string(key),
+				Revision: val.Revision,
+				Event:    val.Event,
+				Updated:  val.Updated,
+			})
+			if limit > 0 && len(data) == limit {
+				break
+			}
+		}
+
+		return nil
+	})
+
+	return data, err
+}
+
+// Revision returns the revision of the last write.
+func (b *Bolt) Revision() (int64, error) {
+	var rev int64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		rev = int64(tx.Bucket(revisionsBucket).Sequence())
+		return nil
+	})
+	return rev, err
+}
+
+// Watch streams the events written after the given revision.
+//
+// Only the events written by this process are streamed.
+func (b *Bolt) Watch(ctx context.Context, rev int64) <-chan Record {
+	if rev == 0 {
+		rev, _ = b.Revision()
+	}
+
+	return pollWatch(ctx, rev, b.done, b.Since, func() <-chan struct{} {
+		b.mu.RLock()
+		defer b.mu.RUnlock()
+		return b.notify
+	})
+}
+
+// Delete deletes the stored value for the given key
+// together with its time index entry.
+func (b *Bolt) Delete(k string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return remove(tx, k)
+	})
+}
+
+// DeleteRevision deletes the stored value for the given key, together
+// with its time index entry, only if it is still the one written
+// at the given revision.
+func (b *Bolt) DeleteRevision(k string, rev int64) (bool, error) {
+	var deleted bool
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		dat := tx.Bucket(eventsBucket).Get([]byte(k))
+		if dat == nil {
+			return nil
+		}
+
+		var val boltValue
+		if err := json.Unmarshal(dat, &val); err != nil {
+			return err
+		}
+		if val.Revision != rev {
+			return nil
+		}
+
+		deleted = true
+		return remove(tx, k)
+	})
+	return deleted, err
+}
+
+// Ping returns an error if the database has been closed.
+func (b *Bolt) Ping(_ context.Context) error {
+	return b.db.View(func(*bolt.Tx) error { return nil })
+}
+
+// Close stops the expired events removal and closes the database.
+func (b *Bolt) Close() error {
+	b.once.Do(func() {
+		close(b.done)
+	})
+	b.wg.Wait()
+
+	return b.db.Close()
+}
+
+// sweep removes the expired events.
+func (b *Bolt) sweep() error {
+	now := b.now().UnixNano()
+
+	expired := func(tx *bolt.Tx) [][]byte {
+		all := [][]byte{}
+		c := tx.Bucket(expiriesBucket).Cursor()
+		for ek, key := c.First(); ek != nil; ek, key = c.Next() {
+			if int64(binary.BigEndian.Uint64(ek[:8])) > now {
+				break
+			}
+			all = append(all, bytes.Clone(key))
+		}
+		return all
+	}
+
+	// Look for them first, to avoid a write
+	// transaction when there is nothing to do.
+	var all [][]byte
+	b.db.View(func(tx *bolt.Tx) error {
+		all = expired(tx)
+		return nil
+	})
+	if len(all) == 0 {
+		return nil
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, key := range expired(tx) {
+			if err := remove(tx, string(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// remove removes the event stored under the given
+// key, if any, with all its references.
+func remove(tx *bolt.Tx, k string) error {
+	events := tx.Bucket(eventsBucket)
+
+	dat := events.Get([]byte(k))
+	if dat == nil {
+		return nil
+	}
+
+	var val boltValue
+	if err := json.Unmarshal(dat, &val); err != nil {
+		return err
+	}
+
+	if err := events.Delete([]byte(k)); err != nil {
+		return err
+	}
+	if ik := indexKey(k, &val.Event); len(ik) > 0 {
+		if err := events.Delete([]byte(ik)); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Bucket(revisionsBucket).Delete(itob(val.Revision)); err != nil {
+		return err
+	}
+	if val.Expiry > 0 {
+		return tx.Bucket(expiriesBucket).Delete(expiryKey(val.Expiry, val.Revision))
+	}
+	return nil
+}
+
+// itob returns the big endian representation of the given
+// revision, so that the keys sort by revision.
+func itob(v int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+func expiryKey(expiry, rev int64) []byte {
+	return append(itob(expiry), itob(rev)...)
+}
@@ -0,0 +1,263 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func newBolt(t *testing.T, path string) *Bolt {
+	t.Helper()
+
+	sto, err := NewBolt(BoltOptions{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sto
+}
+
+func TestBoltGet(t *testing.T) {
+	sto := newBolt(t, filepath.Join(t.TempDir(), "events.db"))
+	defer sto.Close()
+
+	now := time.Date(2024, 7, 5, 7, 33, 7, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		cid := "abc"
+		if i%2 == 1 {
+			cid = "xyz"
+		}
+		typ := "Normal"
+		if i%3 == 0 {
+			typ = "Warning"
+		}
+		ev := newEvent(cid, fmt.Sprintf("%d", i), typ, now.Add(time.Duration(i)*time.Second))
+		if _, err := sto.Set(sto.PrepareKey(ev), ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		prefix   string
+		opts     GetOptions
+		expected []string
+	}{
+		{
+			name:     "composition",
+			prefix:   sto.PreparePrefix("abc"),
+			expected: []string{"4", "2", "0"},
+		},
+		{
+			name:     "timeline",
+			prefix:   sto.PreparePrefix(""),
+			opts:     GetOptions{Limit: 4},
+			expected: []string{"5", "4", "3", "2"},
+		},
+		{
+			name:     "end key",
+			prefix:   sto.PreparePrefix(""),
+			opts:     GetOptions{Limit: 2, EndKey: timelineKey(sto, newEvent("", "3", "", now.Add(3*time.Second)))},
+			expected: []string{"2", "1"},
+		},
+		{
+			name:   "match",
+			prefix: sto.PreparePrefix(""),
+			opts: GetOptions{Limit: 1, Match: func(ev *corev1.Event) bool {
+				return ev.Type == "Warning"
+			}},
+			expected: []string{"3"},
+		},
+		{
+			name:   "not found",
+			prefix: sto.PreparePrefix("abcd"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			all, found, err := sto.Get(tt.prefix, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != (len(tt.expected) > 0) {
+				t.Fatalf("found: got %t, expected %t", found, len(tt.expected) > 0)
+			}
+
+			got := make([]string, len(all))
+			for i, el := range all {
+				got[i] = el.Event.Name
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Fatalf("got %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBoltRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+
+	sto := newBolt(t, path)
+	ev := newEvent("abc", "1", "Normal", time.Now())
+	key := sto.PrepareKey(ev)
+	rev1, err := sto.Set(key, ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sto.Close()
+
+	sto = newBolt(t, path)
+	defer sto.Close()
+
+	all, found, err := sto.Get(sto.PreparePrefix("abc"), GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || all[0].Key != key || all[0].Revision != rev1 {
+		t.Fatalf("got %v, expected %s (%d)", all, key, rev1)
+	}
+
+	ev.Count = 2
+	rev2, _ := sto.Set(key, ev)
+	if rev2 <= rev1 {
+		t.Fatalf("revision: got %d, expected more than %d", rev2, rev1)
+	}
+
+	all, _ = sto.Since(0, 0)
+	if len(all) != 1 || all[0].Revision != rev2 || all[0].Event.Count != 2 || !all[0].Updated {
+		t.Fatalf("got %+v, expected the updated event only", all)
+	}
+}
+
+func TestBoltDuplicate(t *testing.T) {
+	sto := newBolt(t, filepath.Join(t.TempDir(), "events.db"))
+	defer sto.Close()
+
+	ev := newEvent("abc", "1", "Normal", time.Now())
+	ev.ResourceVersion = "100"
+	key := sto.PrepareKey(ev)
+
+	rev1, err := sto.Set(key, ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rev2, err := sto.Set(key, ev)
+	if !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("got %v, expected %v", err, ErrDuplicate)
+	}
+	if rev2 != rev1 {
+		t.Fatalf("revision: got %d, expected %d", rev2, rev1)
+	}
+
+	all, _ := sto.Since(0, 0)
+	if len(all) != 1 || all[0].Revision != rev1 || all[0].Updated {
+		t.Fatalf("got %+v, expected the first version only", all)
+	}
+}
+
+func TestBoltOutOfOrder(t *testing.T) {
+	sto := newBolt(t, filepath.Join(t.TempDir(), "events.db"))
+	defer sto.Close()
+
+	testOutOfOrder(t, sto, "abc")
+}
+
+func TestBoltTimeline(t *testing.T) {
+	sto := newBolt(t, filepath.Join(t.TempDir(), "events.db"))
+	defer sto.Close()
+
+	testTimeline(t, sto, "abc")
+}
+
+func TestBoltDeleteRevision(t *testing.T) {
+	sto := newBolt(t, filepath.Join(t.TempDir(), "events.db"))
+	defer sto.Close()
+
+	testDeleteRevision(t, sto, "abc")
+}
+
+func TestBoltTTL(t *testing.T) {
+	sto := newBolt(t, filepath.Join(t.TempDir(), "events.db"))
+	defer sto.Close()
+
+	now := time.Now()
+	sto.now = func() time.Time { return now }
+	sto.SetTTL(10)
+
+	ev := newEvent("abc", "1", "Normal", now)
+	sto.Set(sto.PrepareKey(ev), ev)
+
+	if _, found, _ := sto.Get(sto.PreparePrefix("abc"), GetOptions{}); !found {
+		t.Fatal("expected data before expiry")
+	}
+
+	now = now.Add(10 * time.Second)
+	if _, found, _ := sto.Get(sto.PreparePrefix("abc"), GetOptions{}); found {
+		t.Fatal("expected no data after expiry")
+	}
+
+	if err := sto.sweep(); err != nil {
+		t.Fatal(err)
+	}
+
+	sto.now = time.Now
+	for _, prefix := range []string{sto.PreparePrefix("abc"), sto.PreparePrefix("")} {
+		if _, found, _ := sto.Get(prefix, GetOptions{}); found {
+			t.Fatalf("%s: expected no data after sweep", prefix)
+		}
+	}
+	if all, _ := sto.Since(0, 0); len(all) != 0 {
+		t.Fatalf("Found: %d events, expected: 0", len(all))
+	}
+}
+
+func TestBoltWatch(t *testing.T) {
+	sto := newBolt(t, filepath.Join(t.TempDir(), "events.db"))
+	defer sto.Close()
+
+	ev := newEvent("abc", "0", "Normal", time.Now())
+	sto.Set(sto.PrepareKey(ev), ev)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := sto.Watch(ctx, 0)
+
+	ev = newEvent("abc", "1", "Normal", time.Now())
+	rev, _ := sto.Set(sto.PrepareKey(ev), ev)
+
+	select {
+	case rec := <-ch:
+		if rec.Revision != rev || rec.Event.Name != "1" {
+			t.Fatalf("got %s (%d), expected 1 (%d)", rec.Event.Name, rec.Revision, rev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event not delivered")
+	}
+
+	if err := sto.Delete(sto.PrepareKey(ev)); err != nil {
+		t.Fatal(err)
+	}
+	if all, _ := sto.Since(rev-1, 0); len(all) != 0 {
+		t.Fatalf("Found: %d events, expected: 0", len(all))
+	}
+}
+
+func TestBoltPing(t *testing.T) {
+	sto := newBolt(t, filepath.Join(t.TempDir(), "events.db"))
+
+	if err := sto.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	sto.Close()
+	if err := sto.Ping(context.Background()); err == nil {
+		t.Fatal("expected an error after Close")
+	}
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krateoplatformops/eventsse/internal/filter"
+	"github.com/krateoplatformops/eventsse/internal/labels"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Events are stored under keys that sort by time:
+//
+//	events/comp-<composition-id>/<timestamp>-<uid>
+//	events/<timestamp>-<uid>    (events not related to any composition)
+//
+// The timestamp is the first occurrence of the event, so that
+// the key does not change when Kubernetes updates the event.
+//
+// A copy of each of them is kept in a global time index, keyed
+// on the most recent occurrence of the event:
+//
+//	timeline/<last timestamp>-<timestamp>-<uid>
+//
+// so that a descending range over it returns the most recently
+// seen events first; the entry moves when the event is updated.
+const (
+	keyRoot      = "events"
+	keyPrefix    = keyRoot + "/"
+	timelineRoot = "timeline"
+
+	// timestampLayout is a fixed width, lexically sortable time layout.
+	timestampLayout = "20060102T150405.000000000Z"
+)
+
+// eventKey returns the key under which the event is stored.
+func eventKey(ev *corev1.Event) string {
+	name := fmt.Sprintf("%s-%s", firstTimestamp(ev).UTC().Format(timestampLayout), ev.UID)
+	if cid := labels.CompositionID(ev); len(cid) > 0 {
+		return path.Join(keyRoot, compositionDir(cid), name)
+	}
+	return path.Join(keyRoot, name)
+}
+
+// prefixKey returns the prefix of the keys of the events
+// related to the composition, or of the global time index if
+// the composition is empty.
+func prefixKey(compositionId string) string {
+	if len(compositionId) == 0 {
+		return timelineRoot + "/"
+	}
+	return path.Join(keyRoot, compositionDir(compositionId)) + "/"
+}
+
+// indexKey returns the time index key of the given event,
+// stored under the given key, or an empty string if the key
+// does not belong to the time ordered layout.
+func indexKey(k string, ev *corev1.Event) string {
+	name := path.Base(k)
+	if !isTimedName(name) {
+		return ""
+	}
+
+	// Truncated as when stored: the key of the stored
+	// version must be found again to be moved.
+	last := filter.Timestamp(ev).Truncate(time.Second)
+	if last.IsZero() {
+		// The first occurrence, as in the key.
+		last, _ = time.Parse(timestampLayout, name[:len(timestampLayout)])
+	}
+	return path.Join(timelineRoot, fmt.Sprintf("%s-%s", last.UTC().Format(timestampLayout), name))
+}
+
+// isTimedKey reports whether the key belongs to the time ordered layout.
+func isTimedKey(k string) bool {
+	return isTimedName(path.Base(k))
+}
+
+func compositionDir(compositionId string) string {
+	return fmt.Sprintf("comp-%s", strings.ToLower(compositionId))
+}
+
+func isTimedName(name string) bool {
+	n := len(timestampLayout)
+	if len(name) <= n || name[n] != '-' {
+		return false
+	}
+
+	_, err := time.Parse(timestampLayout, name[:n])
+	return err == nil
+}
+
+func firstTimestamp(ev *corev1.Event) time.Time {
+	switch {
+	case !ev.FirstTimestamp.IsZero():
+		return ev.FirstTimestamp.Time
+	case !ev.EventTime.IsZero():
+		return ev.EventTime.Time
+	case !ev.CreationTimestamp.IsZero():
+		return ev.CreationTimestamp.Time
+	default:
+		return time.Now()
+	}
+}
+
+// isStale reports whether the event is the stored version of
+// the same event, or an older one delivered late (i.e. by a
+// retry). The resource versions are compared as numbers, as
+// the API servers set them; if either is missing, the count
+// and then the time of the most recent occurrence are.
+func isStale(stored, ev *corev1.Event) bool {
+	if stored.UID != ev.UID {
+		return false
+	}
+
+	a, errA := strconv.ParseUint(stored.ResourceVersion, 10, 64)
+	b, errB := strconv.ParseUint(ev.ResourceVersion, 10, 64)
+	switch {
+	case errA == nil && errB == nil:
+		return b <= a
+	case len(ev.ResourceVersion) > 0 && ev.ResourceVersion == stored.ResourceVersion:
+		return true
+	}
+
+	if ev.Count != stored.Count {
+		return ev.Count < stored.Count
+	}
+	return !filter.Timestamp(ev).After(filter.Timestamp(stored))
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestIndexKey(t *testing.T) {
+	last := &corev1.Event{LastTimestamp: metav1.NewTime(time.Date(2024, 7, 6, 8, 0, 0, 0, time.UTC))}
+
+	tests := []struct {
+		key      string
+		event    *corev1.Event
+		expected string
+	}{
+		{
+			key:      "events/comp-abc/20240705T073307.000000000Z-383b7f73-bdfe-4817-a06d-000000000000",
+			event:    last,
+			expected: "timeline/20240706T080000.000000000Z-20240705T073307.000000000Z-383b7f73-bdfe-4817-a06d-000000000000",
+		},
+		{
+			key:      "events/20240705T073307.000000000Z-123",
+			event:    last,
+			expected: "timeline/20240706T080000.000000000Z-20240705T073307.000000000Z-123",
+		},
+		{
+			// No timestamps: the first occurrence of the key.
+			key:      "events/20240705T073307.000000000Z-123",
+			event:    &corev1.Event{},
+			expected: "timeline/20240705T073307.000000000Z-20240705T073307.000000000Z-123",
+		},
+		{
+			// Legacy layout.
+			key:      "events/comp-abc/383b7f73-bdfe-4817-a06d-000000000000",
+			event:    last,
+			expected: "",
+		},
+		{
+			key:      "events/comp-abc/20240705T073307.000000000Z",
+			event:    last,
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := indexKey(tt.key, tt.event); got != tt.expected {
+				t.Errorf("indexKey() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsStale(t *testing.T) {
+	ts := time.Date(2024, 7, 5, 7, 33, 7, 0, time.UTC)
+
+	event := func(uid, rv string, count int32, last time.Time) *corev1.Event {
+		return &corev1.Event{
+			ObjectMeta:    metav1.ObjectMeta{UID: types.UID(uid), ResourceVersion: rv},
+			Count:         count,
+			LastTimestamp: metav1.NewTime(last),
+		}
+	}
+
+	tests := []struct {
+		name     string
+		stored   *corev1.Event
+		ev       *corev1.Event
+		expected bool
+	}{
+		{"same version", event("1", "100", 2, ts), event("1", "100", 2, ts), true},
+		{"older version", event("1", "100", 2, ts), event("1", "99", 3, ts), true},
+		{"newer version", event("1", "100", 2, ts), event("1", "101", 1, ts), false},
+		{"newer version, more digits", event("1", "99", 1, ts), event("1", "100", 1, ts), false},
+		{"other event", event("1", "100", 2, ts), event("2", "100", 2, ts), false},
+		{"no version, same", event("1", "", 2, ts), event("1", "", 2, ts), true},
+		{"no version, lower count", event("1", "", 2, ts), event("1", "", 1, ts.Add(time.Minute)), true},
+		{"no version, higher count", event("1", "", 2, ts), event("1", "", 3, ts), false},
+		{"no version, older", event("1", "", 2, ts), event("1", "", 2, ts.Add(-time.Minute)), true},
+		{"no version, newer", event("1", "", 2, ts), event("1", "", 2, ts.Add(time.Minute)), false},
+		{"opaque versions", event("1", "a", 2, ts), event("1", "b", 3, ts), false},
+		{"same opaque version", event("1", "a", 2, ts), event("1", "a", 3, ts), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isStale(tt.stored, tt.ev); got != tt.expected {
+				t.Errorf("isStale() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	defaultLeaseBuckets = 10
+)
+
+// granter is the part of the etcd lease API used to create leases.
+type granter interface {
+	Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error)
+}
+
+// leaseBuckets shares etcd leases among the events written in
+// the same time window, instead of granting one lease per event.
+//
+// The TTL is split in windows: the lease of a window is granted for
+// the TTL plus the window length, so that every event attached to it
+// lives at least the TTL and at most the TTL plus one window.
+type leaseBuckets struct {
+	g      granter
+	ttl    time.Duration
+	window time.Duration
+	leases map[int64]clientv3.LeaseID // By window.
+	grants map[int64]*grantCall       // In progress, by window.
+	mu     sync.Mutex
+	now    func() time.Time
+}
+
+// grantCall is a lease grant shared by all the
+// writes of its window while in progress.
+type grantCall struct {
+	done chan struct{}
+	id   clientv3.LeaseID
+	err  error
+}
+
+// newLeaseBuckets returns the lease buckets for the given TTL (in
+// seconds) split in the given number of windows of at least 1s.
+func newLeaseBuckets(g granter, ttl int, buckets int) *leaseBuckets {
+	if buckets <= 0 {
+		buckets = defaultLeaseBuckets
+	}
+
+	window := (time.Duration(ttl) * time.Second / time.Duration(buckets)).Truncate(time.Second)
+	if window < time.Second {
+		window = time.Second
+	}
+
+	return &leaseBuckets{
+		g:      g,
+		ttl:    time.Duration(ttl) * time.Second,
+		window: window,
+		leases: make(map[int64]clientv3.LeaseID),
+		grants: make(map[int64]*grantCall),
+		now:    time.Now,
+	}
+}
+
+// get returns the lease of the current window, granting it if needed.
+//
+// The lease is granted without holding the lock, once per window:
+// the concurrent writes of the window wait for the same grant.
+func (l *leaseBuckets) get(ctx context.Context) (clientv3.LeaseID, error) {
+	l.mu.Lock()
+	w := l.now().UnixNano() / int64(l.window)
+	if id, ok := l.leases[w]; ok {
+		l.mu.Unlock()
+		return id, nil
+	}
+
+	if call, ok := l.grants[w]; ok {
+		l.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.id, call.err
+		case <-ctx.Done():
+			return clientv3.NoLease, ctx.Err()
+		}
+	}
+
+	call := &grantCall{done: make(chan struct{})}
+	l.grants[w] = call
+	l.mu.Unlock()
+
+	res, err := l.g.Grant(ctx, int64((l.ttl+l.window)/time.Second))
+
+	l.mu.Lock()
+	delete(l.grants, w)
+	if err == nil {
+		// Forget the leases of the past windows: they expire by
+		// themselves, revoking them would delete their keys too.
+		for k := range l.leases {
+			if k < w {
+				delete(l.leases, k)
+			}
+		}
+		l.leases[w] = res.ID
+		call.id = res.ID
+	}
+	call.err = err
+	l.mu.Unlock()
+	close(call.done)
+
+	return call.id, call.err
+}
+
+// forget drops the given lease, i.e. because etcd did
+// not find it, so that a new one is granted next time.
+func (l *leaseBuckets) forget(id clientv3.LeaseID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for k, v := range l.leases {
+		if v == id {
+			delete(l.leases, k)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// countingGranter grants fake leases and counts the requests.
+type countingGranter struct {
+	grants atomic.Int64
+	ttl    int64
+}
+
+func (g *countingGranter) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	g.ttl = ttl
+	return &clientv3.LeaseGrantResponse{ID: clientv3.LeaseID(g.grants.Add(1)), TTL: ttl}, nil
+}
+
+func TestLeaseBuckets(t *testing.T) {
+	g := &countingGranter{}
+	l := newLeaseBuckets(g, 120, 10)
+
+	now := time.Date(2024, 7, 5, 7, 33, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	if l.window != 12*time.Second {
+		t.Fatalf("window: got %v, expected %v", l.window, 12*time.Second)
+	}
+
+	id1, err := l.get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.ttl != 132 {
+		t.Fatalf("lease TTL: got %d, expected %d", g.ttl, 132)
+	}
+
+	// Same window: the lease is shared.
+	now = now.Add(11 * time.Second)
+	if id, _ := l.get(context.Background()); id != id1 {
+		t.Fatalf("lease: got %d, expected %d", id, id1)
+	}
+
+	// Next window: a new lease, the old one is forgotten.
+	now = now.Add(time.Second)
+	id2, _ := l.get(context.Background())
+	if id2 == id1 {
+		t.Fatal("expected a new lease")
+	}
+	if n := len(l.leases); n != 1 {
+		t.Fatalf("Found: %d cached leases, expected: 1", n)
+	}
+
+	l.forget(id2)
+	if id, _ := l.get(context.Background()); id == id2 {
+		t.Fatal("expected a new lease after forget")
+	}
+
+	if n := g.grants.Load(); n != 3 {
+		t.Fatalf("Found: %d grants, expected: 3", n)
+	}
+}
+
+func TestLeaseBucketsShortTTL(t *testing.T) {
+	l := newLeaseBuckets(&countingGranter{}, 5, 10)
+	if l.window != time.Second {
+		t.Fatalf("window: got %v, expected %v", l.window, time.Second)
+	}
+}
+
+func TestLeaseBucketsConcurrentGrant(t *testing.T) {
+	g := &blockingGranter{release: make(chan struct{})}
+	l := newLeaseBuckets(g, 120, 10)
+
+	var wg sync.WaitGroup
+	ids := make([]clientv3.LeaseID, 10)
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ids[i], _ = l.get(context.Background())
+		}(i)
+	}
+
+	// The lock is not held during the grant.
+	for g.grants.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	l.forget(42)
+
+	close(g.release)
+	wg.Wait()
+
+	if n := g.grants.Load(); n != 1 {
+		t.Fatalf("Found: %d grants, expected: 1", n)
+	}
+	for i, id := range ids {
+		if id != ids[0] {
+			t.Fatalf("lease %d: got %d, expected %d", i, id, ids[0])
+		}
+	}
+}
+
+// blockingGranter grants fake leases once released.
+type blockingGranter struct {
+	grants  atomic.Int64
+	release chan struct{}
+}
+
+func (g *blockingGranter) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	n := g.grants.Add(1)
+	<-g.release
+	return &clientv3.LeaseGrantResponse{ID: clientv3.LeaseID(n), TTL: ttl}, nil
+}
+
+// countingKV counts the requests to the etcd KV API.
+type countingKV struct {
+	clientv3.KV
+	n *atomic.Int64
+}
+
+func (c *countingKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	c.n.Add(1)
+	return c.KV.Get(ctx, key, opts...)
+}
+
+func (c *countingKV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	c.n.Add(1)
+	return c.KV.Put(ctx, key, val, opts...)
+}
+
+func (c *countingKV) Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	c.n.Add(1)
+	return c.KV.Delete(ctx, key, opts...)
+}
+
+// Txn counts a request, since each transaction is committed once.
+func (c *countingKV) Txn(ctx context.Context) clientv3.Txn {
+	c.n.Add(1)
+	return c.KV.Txn(ctx)
+}
+
+// countingLease counts the lease grants.
+type countingLease struct {
+	clientv3.Lease
+	n *atomic.Int64
+}
+
+func (c *countingLease) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	c.n.Add(1)
+	return c.Lease.Grant(ctx, ttl)
+}
+
+// BenchmarkLeases compares the etcd requests made by Client.Set to
+// store a burst of new events, written 1ms apart, with a TTL of 120s:
+// one lease per event takes a grant for each of them.
+func BenchmarkLeases(b *testing.B) {
+	for _, buckets := range []int{0, 10, 60} {
+		name := fmt.Sprintf("buckets=%d", buckets)
+		if buckets == 0 {
+			name = "per-event"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			sto := newClient(b)
+
+			var requests, grants atomic.Int64
+			sto.c.KV = &countingKV{KV: sto.c.KV, n: &requests}
+			sto.c.Lease = &countingLease{Lease: sto.c.Lease, n: &grants}
+			sto.buckets = buckets
+			sto.SetTTL(120)
+
+			now := time.Now()
+			sto.leases.now = func() time.Time { return now }
+			step := time.Millisecond
+			if buckets == 0 {
+				// Every event falls in a window of its own.
+				step = sto.leases.window
+			}
+
+			id := uniqueID("bench")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				now = now.Add(step)
+
+				ev := newEvent(id, fmt.Sprintf("%s-%d", id, i), "Normal", now)
+				if _, err := sto.Set(sto.PrepareKey(ev), ev); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.ReportMetric(float64(requests.Load()+grants.Load())/float64(b.N), "requests/op")
+			b.ReportMetric(float64(grants.Load())/float64(b.N), "grants/op")
+		})
+	}
+}
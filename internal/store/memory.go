@@ -0,0 +1,400 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	defaultMaxEvents = 10000
+)
+
+var (
+	sweepInterval             = 1 * time.Second
+	_             TTLSetter   = (*Memory)(nil)
+	_             KeyPreparer = (*Memory)(nil)
+	_             Watcher     = (*Memory)(nil)
+	_             Store       = (*Memory)(nil)
+	_             Pinger      = (*Memory)(nil)
+
+	errClosed = errors.New("store closed")
+)
+
+// MemoryOptions are the options for the in-memory store.
+type MemoryOptions struct {
+	// MaxEvents is the max number of stored events; when it is
+	// reached, the least recently written event is evicted.
+	// Optional (10000 by default).
+	MaxEvents int
+}
+
+// entry is an event stored in memory.
+type entry struct {
+	key     string
+	rev     int64
+	event   corev1.Event
+	expiry  time.Time // Zero if the event never expires.
+	dead    bool      // True once the entry has been replaced or removed.
+	updated bool      // True if the entry replaced another one.
+}
+
+func (e *entry) isExpired(now time.Time) bool {
+	return !e.expiry.IsZero() && !now.Before(e.expiry)
+}
+
+// Memory is a bounded Store implementation that keeps
+// the events in memory, with the same key layout and
+// the same semantics of the etcd one.
+//
+// The events are lost on restart and are not shared
+// among replicas.
+type Memory struct {
+	entries   map[string]*entry // Both the event and the time index keys.
+	keys      []string          // The keys of entries, sorted.
+	log       []*entry          // The events, in revision order.
+	live      int               // The number of live entries in log.
+	rev       int64             // Last assigned revision.
+	ttl       time.Duration
+	maxEvents int
+	notify    chan struct{} // Closed and replaced at each write.
+	done      chan struct{}
+	once      sync.Once
+	mu        sync.RWMutex
+	now       func() time.Time
+}
+
+// NewMemory creates a new in-memory store and starts a goroutine
+// to periodically remove the expired events.
+//
+// You must call the Close() method on the store when you're done working with it.
+func NewMemory(opts MemoryOptions) *Memory {
+	if opts.MaxEvents <= 0 {
+		opts.MaxEvents = defaultMaxEvents
+	}
+
+	m := &Memory{
+		entries:   make(map[string]*entry),
+		maxEvents: opts.MaxEvents,
+		notify:    make(chan struct{}),
+		done:      make(chan struct{}),
+		now:       time.Now,
+	}
+
+	go func() {
+		ticker := time.NewTicker(sweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-m.done:
+				return
+			case <-ticker.C:
+				m.sweep()
+			}
+		}
+	}()
+
+	return m
+}
+
+func (m *Memory) SetTTL(ttl int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ttl = time.Duration(ttl) * time.Second
+}
+
+func (m *Memory) PrepareKey(ev *corev1.Event) string {
+	return eventKey(ev)
+}
+
+func (m *Memory) PreparePrefix(compositionId string) string {
+	return prefixKey(compositionId)
+}
+
+// Set stores the given value for the given key and
+// returns the revision at which it has been written.
+//
+// If the same or a newer version of the event is already stored,
+// nothing is written and ErrDuplicate is returned, together
+// with the revision of the stored event.
+func (m *Memory) Set(k string, v *corev1.Event) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, found := m.entries[k]
+	if found && old.key == k && !old.isExpired(m.now()) {
+		if isStale(&old.event, v) {
+			return old.rev, ErrDuplicate
+		}
+	} else {
+		found = false
+	}
+
+	m.rev++
+	e := &entry{key: k, rev: m.rev, event: *v.DeepCopy(), updated: found}
+	if m.ttl > 0 {
+		e.expiry = m.now().Add(m.ttl)
+	}
+
+	// The time index entry of the previous version goes too.
+	m.remove(k)
+	m.insert(k, e)
+	if ik := indexKey(k, v); len(ik) > 0 {
+		m.insert(ik, e)
+	}
+	m.log = append(m.log, e)
+	m.live++
+
+	for m.live > m.maxEvents {
+		m.evict()
+	}
+
+	close(m.notify)
+	m.notify = make(chan struct{})
+
+	return e.rev, nil
+}
+
+// Get retrieves, in descending key order, the stored values
+// whose key starts with the given one.
+func (m *Memory) Get(k string, opts GetOptions) (data []Record, found bool, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := m.now()
+
+	// Scan backwards from the end of the range.
+	i := len(m.keys)
+	if end := prefixEnd(k); len(end) > 0 {
+		i = sort.SearchStrings(m.keys, end)
+	}
+	if len(opts.EndKey) > 0 {
+		i = sort.SearchStrings(m.keys, opts.EndKey)
+	}
+
+	for i--; i >= 0; i-- {
+		key := m.keys[i]
+		if key < k || (len(opts.EndKey) == 0 && !strings.HasPrefix(key, k)) {
+			break
+		}
+
+		e := m.entries[key]
+		if e.isExpired(now) {
+			continue
+		}
+		if opts.Match != nil && !opts.Match(&e.event) {
+			continue
+		}
+
+		data = append(data, e.record(key))
+		if opts.Limit > 0 && len(data) == opts.Limit {
+			break
+		}
+	}
+
+	// If no value was found return false
+	return data, len(data) > 0, nil
+}
+
+// Since retrieves, oldest first, the events written after the given revision.
+//
+// A limit of zero means no limit.
+func (m *Memory) Since(rev int64, limit int) (data []Record, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.since(rev, limit), nil
+}
+
+func (m *Memory) since(rev int64, limit int) (data []Record) {
+	now := m.now()
+
+	i := sort.Search(len(m.log), func(i int) bool {
+		return m.log[i].rev > rev
+	})
+	for ; i < len(m.log); i++ {
+		e := m.log[i]
+		if e.dead || e.isExpired(now) || !strings.HasPrefix(e.key, keyPrefix) {
+			continue
+		}
+
+		data = append(data, e.record(e.key))
+		if limit > 0 && len(data) == limit {
+			break
+		}
+	}
+
+	return data
+}
+
+// Revision returns the revision of the last write.
+func (m *Memory) Revision() (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.rev, nil
+}
+
+// Watch streams the events written after the given revision.
+func (m *Memory) Watch(ctx context.Context, rev int64) <-chan Record {
+	m.mu.RLock()
+	if rev == 0 {
+		rev = m.rev
+	}
+	m.mu.RUnlock()
+
+	return pollWatch(ctx, rev, m.done, m.Since, func() <-chan struct{} {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		return m.notify
+	})
+}
+
+// Delete deletes the stored value for the given key
+// together with its time index entry.
+func (m *Memory) Delete(k string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.remove(k)
+	return nil
+}
+
+// DeleteRevision deletes the stored value for the given key, together
+// with its time index entry, only if it is still the one written
+// at the given revision.
+func (m *Memory) DeleteRevision(k string, rev int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.entries[k]; !ok || e.key != k || e.rev != rev {
+		return false, nil
+	}
+
+	m.remove(k)
+	return true, nil
+}
+
+// Ping returns an error if the store has been closed.
+func (m *Memory) Ping(_ context.Context) error {
+	select {
+	case <-m.done:
+		return errClosed
+	default:
+		return nil
+	}
+}
+
+// Close stops the expired events removal.
+func (m *Memory) Close() error {
+	m.once.Do(func() {
+		close(m.done)
+	})
+	return nil
+}
+
+func (e *entry) record(key string) Record {
+	return Record{
+		Key:      key,
+		Revision: e.rev,
+		Event:    *e.event.DeepCopy(),
+		Updated:  e.updated,
+	}
+}
+
+// sweep removes the expired events.
+func (m *Memory) sweep() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	for _, e := range m.log {
+		if !e.dead && e.isExpired(now) {
+			m.remove(e.key)
+		}
+	}
+	m.compact()
+}
+
+// evict removes the least recently written event.
+func (m *Memory) evict() {
+	for _, e := range m.log {
+		if !e.dead {
+			m.remove(e.key)
+			break
+		}
+	}
+	m.compact()
+}
+
+// compact drops the dead entries from the log, once
+// they are more than the live ones.
+func (m *Memory) compact() {
+	if len(m.log) < 2*m.live+1 {
+		return
+	}
+
+	all := make([]*entry, 0, m.live)
+	for _, e := range m.log {
+		if !e.dead {
+			all = append(all, e)
+		}
+	}
+	m.log = all
+}
+
+// remove removes the event stored under the given key, if any.
+func (m *Memory) remove(k string) {
+	e, ok := m.entries[k]
+	if !ok || e.key != k {
+		return
+	}
+
+	m.delete(k)
+	if ik := indexKey(k, &e.event); len(ik) > 0 {
+		if x, ok := m.entries[ik]; ok && x == e {
+			m.delete(ik)
+		}
+	}
+	e.dead = true
+	m.live--
+}
+
+func (m *Memory) insert(k string, e *entry) {
+	if _, ok := m.entries[k]; !ok {
+		i := sort.SearchStrings(m.keys, k)
+		m.keys = append(m.keys, "")
+		copy(m.keys[i+1:], m.keys[i:])
+		m.keys[i] = k
+	}
+	m.entries[k] = e
+}
+
+func (m *Memory) delete(k string) {
+	delete(m.entries, k)
+
+	i := sort.SearchStrings(m.keys, k)
+	if i < len(m.keys) && m.keys[i] == k {
+		m.keys = append(m.keys[:i], m.keys[i+1:]...)
+	}
+}
+
+// prefixEnd returns the smallest key greater than all the keys
+// with the given prefix, or an empty string if there is none.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,455 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/eventsse/internal/labels"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newEvent(cid string, uid string, typ string, ts time.Time) *corev1.Event {
+	ev := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: uid,
+			UID:  types.UID(uid),
+		},
+		Type:           typ,
+		FirstTimestamp: metav1.NewTime(ts),
+	}
+	if len(cid) > 0 {
+		ev.Labels = map[string]string{"krateo.io/composition-id": cid}
+	}
+	return ev
+}
+
+// timelineKey returns the time index key of the event.
+func timelineKey(sto KeyPreparer, ev *corev1.Event) string {
+	return indexKey(sto.PrepareKey(ev), ev)
+}
+
+func keysOf(all []Record) []string {
+	res := make([]string, len(all))
+	for i, el := range all {
+		res[i] = el.Key
+	}
+	return res
+}
+
+func TestMemoryGet(t *testing.T) {
+	sto := NewMemory(MemoryOptions{})
+	defer sto.Close()
+
+	now := time.Date(2024, 7, 5, 7, 33, 7, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		cid := "abc"
+		if i%2 == 1 {
+			cid = "xyz"
+		}
+		typ := "Normal"
+		if i%3 == 0 {
+			typ = "Warning"
+		}
+		ev := newEvent(cid, fmt.Sprintf("%d", i), typ, now.Add(time.Duration(i)*time.Second))
+		if _, err := sto.Set(sto.PrepareKey(ev), ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		prefix   string
+		opts     GetOptions
+		expected []string
+	}{
+		{
+			name:     "composition",
+			prefix:   sto.PreparePrefix("abc"),
+			expected: []string{"4", "2", "0"},
+		},
+		{
+			name:     "timeline",
+			prefix:   sto.PreparePrefix(""),
+			opts:     GetOptions{Limit: 4},
+			expected: []string{"5", "4", "3", "2"},
+		},
+		{
+			name:     "end key",
+			prefix:   sto.PreparePrefix(""),
+			opts:     GetOptions{Limit: 2, EndKey: timelineKey(sto, newEvent("", "3", "", now.Add(3*time.Second)))},
+			expected: []string{"2", "1"},
+		},
+		{
+			name:   "match",
+			prefix: sto.PreparePrefix(""),
+			opts: GetOptions{Limit: 1, Match: func(ev *corev1.Event) bool {
+				return ev.Type == "Warning"
+			}},
+			expected: []string{"3"},
+		},
+		{
+			name:   "not found",
+			prefix: sto.PreparePrefix("abcd"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			all, found, err := sto.Get(tt.prefix, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != (len(tt.expected) > 0) {
+				t.Fatalf("found: got %t, expected %t", found, len(tt.expected) > 0)
+			}
+
+			got := make([]string, len(all))
+			for i, el := range all {
+				got[i] = el.Event.Name
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Fatalf("got %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMemoryUpdate(t *testing.T) {
+	sto := NewMemory(MemoryOptions{})
+	defer sto.Close()
+
+	ev := newEvent("abc", "1", "Normal", time.Now())
+	key := sto.PrepareKey(ev)
+
+	rev1, _ := sto.Set(key, ev)
+	ev.Count = 2
+	rev2, _ := sto.Set(key, ev)
+	if rev2 <= rev1 {
+		t.Fatalf("revision: got %d, expected more than %d", rev2, rev1)
+	}
+
+	all, _ := sto.Since(0, 0)
+	if len(all) != 1 || all[0].Revision != rev2 || all[0].Event.Count != 2 {
+		t.Fatalf("got %+v, expected the updated event only", all)
+	}
+
+	if !all[0].Updated {
+		t.Fatal("expected the event to be marked as updated")
+	}
+
+	if err := sto.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	for _, prefix := range []string{sto.PreparePrefix("abc"), sto.PreparePrefix("")} {
+		if _, found, _ := sto.Get(prefix, GetOptions{}); found {
+			t.Fatalf("%s: expected no data after delete", prefix)
+		}
+	}
+}
+
+func TestMemoryDuplicate(t *testing.T) {
+	sto := NewMemory(MemoryOptions{})
+	defer sto.Close()
+
+	ev := newEvent("abc", "1", "Normal", time.Now())
+	ev.ResourceVersion = "100"
+	key := sto.PrepareKey(ev)
+
+	rev1, err := sto.Set(key, ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rev2, err := sto.Set(key, ev)
+	if !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("got %v, expected %v", err, ErrDuplicate)
+	}
+	if rev2 != rev1 {
+		t.Fatalf("revision: got %d, expected %d", rev2, rev1)
+	}
+
+	ev.ResourceVersion = "101"
+	ev.Count = 2
+	rev3, err := sto.Set(key, ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all, _ := sto.Since(0, 0)
+	if len(all) != 1 || all[0].Revision != rev3 || !all[0].Updated {
+		t.Fatalf("got %+v, expected the updated event only", all)
+	}
+}
+
+func TestMemoryOutOfOrder(t *testing.T) {
+	sto := NewMemory(MemoryOptions{})
+	defer sto.Close()
+
+	testOutOfOrder(t, sto, "abc")
+}
+
+// testOutOfOrder checks that the older versions of an
+// event, delivered late, do not replace the stored one.
+func testOutOfOrder(t *testing.T, sto Store, cid string) {
+	t.Helper()
+
+	ts := time.Now().Truncate(time.Second)
+	for _, rv := range []string{"101", ""} {
+		ev := newEvent(cid, fmt.Sprintf("uid-%s-%d", rv, ts.UnixNano()), "Normal", ts)
+		ev.ResourceVersion = rv
+		ev.Count = 2
+		ev.LastTimestamp = metav1.NewTime(ts.Add(time.Minute))
+		key := sto.PrepareKey(ev)
+
+		rev1, err := sto.Set(key, ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		old := ev.DeepCopy()
+		if len(rv) > 0 {
+			old.ResourceVersion = "100"
+		}
+		old.Count = 1
+		old.LastTimestamp = metav1.NewTime(ts)
+
+		rev2, err := sto.Set(key, old)
+		if !errors.Is(err, ErrDuplicate) {
+			t.Fatalf("%q: got %v, expected %v", rv, err, ErrDuplicate)
+		}
+		if rev2 != rev1 {
+			t.Fatalf("%q: revision: got %d, expected %d", rv, rev2, rev1)
+		}
+
+		all, _, err := sto.Get(key, GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != 1 || all[0].Revision != rev1 || all[0].Event.Count != 2 {
+			t.Fatalf("%q: got %+v, expected the newer version", rv, all)
+		}
+	}
+}
+
+func TestMemoryTimeline(t *testing.T) {
+	sto := NewMemory(MemoryOptions{})
+	defer sto.Close()
+
+	testTimeline(t, sto, "abc")
+}
+
+// testTimeline checks that the time index follows the
+// most recent occurrence of the events.
+func testTimeline(t *testing.T, sto Store, cid string) {
+	t.Helper()
+
+	ts := time.Now().Truncate(time.Second)
+	old := newEvent(cid, fmt.Sprintf("old-%d", ts.UnixNano()), "Normal", ts.Add(-time.Hour))
+	recent := newEvent(cid, fmt.Sprintf("recent-%d", ts.UnixNano()), "Normal", ts.Add(-time.Minute))
+	for _, ev := range []*corev1.Event{old, recent} {
+		if _, err := sto.Set(sto.PrepareKey(ev), ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The old event occurs again.
+	old.Count = 2
+	old.LastTimestamp = metav1.NewTime(ts)
+	if _, err := sto.Set(sto.PrepareKey(old), old); err != nil {
+		t.Fatal(err)
+	}
+
+	all, _, err := sto.Get(sto.PreparePrefix(""), GetOptions{Match: func(ev *corev1.Event) bool {
+		return labels.CompositionID(ev) == cid
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].Event.UID != old.UID || all[1].Event.UID != recent.UID {
+		t.Fatalf("got %v, expected the updated event first, once", keysOf(all))
+	}
+
+	// The moved entry goes with the event.
+	if err := sto.Delete(sto.PrepareKey(old)); err != nil {
+		t.Fatal(err)
+	}
+	all, _, _ = sto.Get(sto.PreparePrefix(""), GetOptions{Match: func(ev *corev1.Event) bool {
+		return labels.CompositionID(ev) == cid
+	}})
+	if len(all) != 1 || all[0].Event.UID != recent.UID {
+		t.Fatalf("got %v, expected the recent event only", keysOf(all))
+	}
+}
+
+func TestMemoryDeleteRevision(t *testing.T) {
+	sto := NewMemory(MemoryOptions{})
+	defer sto.Close()
+
+	testDeleteRevision(t, sto, "abc")
+}
+
+func testDeleteRevision(t *testing.T, sto Store, cid string) {
+	t.Helper()
+
+	ev := newEvent(cid, fmt.Sprintf("%d", time.Now().UnixNano()), "Normal", time.Now().Truncate(time.Second))
+	key := sto.PrepareKey(ev)
+	rev1, err := sto.Set(key, ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ev.Count = 2
+	rev2, err := sto.Set(key, ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Written again after rev1.
+	if ok, err := sto.DeleteRevision(key, rev1); err != nil || ok {
+		t.Fatalf("revision %d: got %t (%v), expected not deleted", rev1, ok, err)
+	}
+	if _, found, _ := sto.Get(key, GetOptions{}); !found {
+		t.Fatal("expected the updated event to survive")
+	}
+
+	if ok, err := sto.DeleteRevision(key, rev2); err != nil || !ok {
+		t.Fatalf("revision %d: got %t (%v), expected deleted", rev2, ok, err)
+	}
+	all, _, _ := sto.Get(sto.PreparePrefix(""), GetOptions{Match: func(el *corev1.Event) bool {
+		return el.UID == ev.UID
+	}})
+	if len(all) != 0 {
+		t.Fatalf("got %v, expected neither the event nor its index entry", keysOf(all))
+	}
+}
+
+func TestMemoryTTL(t *testing.T) {
+	sto := NewMemory(MemoryOptions{})
+	defer sto.Close()
+
+	now := time.Now()
+	sto.now = func() time.Time { return now }
+	sto.SetTTL(10)
+
+	ev := newEvent("abc", "1", "Normal", now)
+	sto.Set(sto.PrepareKey(ev), ev)
+
+	if _, found, _ := sto.Get(sto.PreparePrefix("abc"), GetOptions{}); !found {
+		t.Fatal("expected data before expiry")
+	}
+
+	now = now.Add(10 * time.Second)
+	if _, found, _ := sto.Get(sto.PreparePrefix("abc"), GetOptions{}); found {
+		t.Fatal("expected no data after expiry")
+	}
+	if all, _ := sto.Since(0, 0); len(all) != 0 {
+		t.Fatalf("Found: %d events, expected: 0", len(all))
+	}
+
+	sto.sweep()
+	if l := len(sto.keys); l != 0 {
+		t.Fatalf("Found: %d keys after sweep, expected: 0", l)
+	}
+}
+
+func TestMemoryMaxEvents(t *testing.T) {
+	sto := NewMemory(MemoryOptions{MaxEvents: 3})
+	defer sto.Close()
+
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		ev := newEvent("", fmt.Sprintf("%d", i), "Normal", now.Add(time.Duration(i)*time.Second))
+		sto.Set(sto.PrepareKey(ev), ev)
+	}
+
+	all, err := sto.Since(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]string, len(all))
+	for i, el := range all {
+		got[i] = el.Event.Name
+	}
+	if fmt.Sprint(got) != "[7 8 9]" {
+		t.Fatalf("got %v, expected [7 8 9]", got)
+	}
+
+	if l := len(sto.keys); l != 6 {
+		t.Fatalf("Found: %d keys, expected: 6", l)
+	}
+}
+
+func TestMemorySince(t *testing.T) {
+	sto := NewMemory(MemoryOptions{})
+	defer sto.Close()
+
+	now := time.Now()
+	revs := []int64{}
+	for i := 0; i < 5; i++ {
+		ev := newEvent("abc", fmt.Sprintf("%d", i), "Normal", now)
+		rev, _ := sto.Set(sto.PrepareKey(ev), ev)
+		revs = append(revs, rev)
+	}
+
+	all, err := sto.Since(revs[1], 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].Revision != revs[2] || all[1].Revision != revs[3] {
+		t.Fatalf("got %v, expected revisions %v", keysOf(all), revs[2:4])
+	}
+}
+
+func TestMemoryWatch(t *testing.T) {
+	sto := NewMemory(MemoryOptions{})
+	defer sto.Close()
+
+	ev := newEvent("abc", "0", "Normal", time.Now())
+	sto.Set(sto.PrepareKey(ev), ev)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := sto.Watch(ctx, 0)
+
+	ev = newEvent("abc", "1", "Normal", time.Now())
+	rev, _ := sto.Set(sto.PrepareKey(ev), ev)
+
+	select {
+	case rec := <-ch:
+		if rec.Revision != rev || rec.Event.Name != "1" {
+			t.Fatalf("got %s (%d), expected 1 (%d)", rec.Event.Name, rec.Revision, rev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event not delivered")
+	}
+
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("unexpected event")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("watch channel not closed")
+	}
+}
+
+func TestMemoryPing(t *testing.T) {
+	sto := NewMemory(MemoryOptions{})
+
+	if err := sto.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	sto.Close()
+	if err := sto.Ping(context.Background()); err == nil {
+		t.Fatal("expected an error after Close")
+	}
+}
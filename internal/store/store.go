@@ -5,11 +5,10 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"path"
-	"strings"
 	"time"
 
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	corev1 "k8s.io/api/core/v1"
 )
@@ -19,27 +18,108 @@ type TTLSetter interface {
 }
 
 type KeyPreparer interface {
-	PrepareKey(eventId, compositionId string) string
+	// PrepareKey returns the key under which the event is stored.
+	PrepareKey(ev *corev1.Event) string
+	// PreparePrefix returns the prefix of the keys of the events related
+	// to the given composition, or of all the events if it is empty.
+	PreparePrefix(compositionId string) string
+}
+
+// Migrator is implemented by the stores that can rewrite the
+// events stored with an older key layout.
+type Migrator interface {
+	Migrate() (count int, err error)
+}
+
+// BatchSetter is implemented by the stores that can write many
+// events with fewer requests than one Set for each of them.
+type BatchSetter interface {
+	// SetBatch stores the given events, in order, under the keys
+	// with the same index; the results, with the same semantics
+	// of Set, have the same index too.
+	SetBatch(keys []string, evs []*corev1.Event) []SetResult
+}
+
+// SetResult is the outcome of the write of an event of a batch.
+type SetResult struct {
+	Revision int64
+	Err      error
+}
+
+// Pinger is implemented by the stores that can check
+// whether they are reachable (i.e. the etcd cluster).
+type Pinger interface {
+	// Ping returns an error if the store cannot serve the
+	// requests before the context is done.
+	Ping(ctx context.Context) error
 }
 
 type Closer interface {
 	Close() error
 }
 
+type Watcher interface {
+	// Watch streams, in revision order, the events written after
+	// the given revision (or from now on, if zero) until the
+	// context is done; the returned channel is then closed.
+	Watch(ctx context.Context, rev int64) <-chan Record
+}
+
+// ErrDuplicate is returned by Set when the same or
+// a newer version of the event is already stored.
+var ErrDuplicate = errors.New("duplicate event")
+
+// maxBatchTxnEvents bounds the events written in one transaction:
+// each of them takes up to three of the 128 operations allowed
+// by default (--max-txn-ops).
+const maxBatchTxnEvents = 32
+
 var (
-	defaultTimeout             = 200 * time.Millisecond
-	_              TTLSetter   = (*Client)(nil)
-	_              KeyPreparer = (*Client)(nil)
-	_              Store       = (*Client)(nil)
+	defaultTimeout              = 200 * time.Millisecond
+	defaultPageSize             = 100
+	migrateTimeout              = 30 * time.Second
+	watchRetryDelay             = 1 * time.Second
+	_               TTLSetter   = (*Client)(nil)
+	_               KeyPreparer = (*Client)(nil)
+	_               Watcher     = (*Client)(nil)
+	_               Migrator    = (*Client)(nil)
+	_               BatchSetter = (*Client)(nil)
+	_               Pinger      = (*Client)(nil)
+	_               Store       = (*Client)(nil)
 )
 
 type Store interface {
 	TTLSetter
 	KeyPreparer
+	Watcher
 	Closer
-	Set(k string, v *corev1.Event) error
-	Get(k string, opts GetOptions) (data []corev1.Event, found bool, err error)
+	Set(k string, v *corev1.Event) (rev int64, err error)
+	Get(k string, opts GetOptions) (data []Record, found bool, err error)
+	Since(rev int64, limit int) (data []Record, err error)
+	// Revision returns the revision of the last write.
+	Revision() (rev int64, err error)
 	Delete(k string) error
+	// DeleteRevision deletes the value stored for the given key only
+	// if it has not been written again after the given revision;
+	// it reports whether the value has been deleted.
+	DeleteRevision(k string, rev int64) (deleted bool, err error)
+}
+
+// Record is a stored event together with the revision
+// at which it has been written.
+//
+// Revisions are monotonically increasing, so they can be
+// used to order the events and to resume from a given one.
+type Record struct {
+	Key      string
+	Revision int64
+	Event    corev1.Event
+	// Updated is true if the event replaced a
+	// previous version stored under the same key.
+	Updated bool
+	// More is true if other events, written in the same
+	// transaction, follow with the same revision.
+	More bool
 }
 
 // Client is a Store implementation for etcd.
@@ -47,96 +127,587 @@ type Client struct {
 	c       *clientv3.Client
 	timeOut time.Duration
 	ttl     int
+	buckets int
+	leases  *leaseBuckets
 }
 
 func (c *Client) SetTTL(ttl int) {
 	c.ttl = ttl
+	c.leases = nil
+	if ttl > 0 {
+		c.leases = newLeaseBuckets(c.c, ttl, c.buckets)
+	}
 }
 
-func (c *Client) PrepareKey(eventId, compositionId string) string {
-	key := ""
-	if len(compositionId) > 0 {
-		key = path.Join(key, fmt.Sprintf("comp-%s", compositionId))
-	}
-	if len(eventId) > 0 {
-		key = path.Join(key, eventId)
-	}
-	key = path.Join("events", strings.ToLower(key))
-	return key
+func (c *Client) PrepareKey(ev *corev1.Event) string {
+	return eventKey(ev)
+}
+
+func (c *Client) PreparePrefix(compositionId string) string {
+	return prefixKey(compositionId)
 }
 
-// Set stores the given value for the given key.
-func (c *Client) Set(k string, v *corev1.Event) error {
+// Set stores the given value for the given key and
+// returns the revision at which it has been written.
+//
+// The time index entry is written in the same transaction; the
+// key is attached to the shared lease of the current time window.
+//
+// If the same or a newer version of the event is already stored,
+// nothing is written and ErrDuplicate is returned, together
+// with the revision of the stored event.
+func (c *Client) Set(k string, v *corev1.Event) (int64, error) {
 	buf := bytes.Buffer{}
 	enc := json.NewEncoder(&buf)
 	if err := enc.Encode(v); err != nil {
-		return err
+		return 0, err
 	}
 
-	opts := []clientv3.OpOption{}
-	if c.ttl > 0 {
-		lease := clientv3.NewLease(c.c)
-		res, err := lease.Grant(context.Background(), int64(c.ttl))
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), c.timeOut)
+	defer cancel()
+
+	retryLease := true
+	for {
+		getRes, err := c.c.Get(ctxWithTimeout, k)
 		if err != nil {
-			return err
+			return 0, err
 		}
-		opts = append(opts, clientv3.WithLease(res.ID))
+
+		// The write succeeds only if the key has not changed
+		// since it was read (zero if it did not exist).
+		var modRev int64
+		var oldIndex string
+		if len(getRes.Kvs) > 0 {
+			kv := getRes.Kvs[0]
+			var old corev1.Event
+			if err := json.Unmarshal(kv.Value, &old); err == nil {
+				if isStale(&old, v) {
+					return kv.ModRevision, ErrDuplicate
+				}
+				oldIndex = indexKey(k, &old)
+			}
+			modRev = kv.ModRevision
+		}
+
+		opts := []clientv3.OpOption{}
+
+		lease := clientv3.NoLease
+		if c.leases != nil {
+			lease, err = c.leases.get(ctxWithTimeout)
+			if err != nil {
+				return 0, err
+			}
+			opts = append(opts, clientv3.WithLease(lease))
+		}
+
+		ops := putOps(k, buf.String(), v, opts...)
+		if len(oldIndex) > 0 && oldIndex != indexKey(k, v) {
+			// The event occurred again: its time index entry moves.
+			ops = append(ops, clientv3.OpDelete(oldIndex))
+		}
+
+		res, err := c.c.Txn(ctxWithTimeout).
+			If(clientv3.Compare(clientv3.ModRevision(k), "=", modRev)).
+			Then(ops...).
+			Commit()
+		if errors.Is(err, rpctypes.ErrLeaseNotFound) && c.leases != nil && retryLease {
+			// The lease has gone (i.e. revoked by hand): get a new one.
+			c.leases.forget(lease)
+			retryLease = false
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if !res.Succeeded {
+			// Written in the meantime: compare with that version.
+			continue
+		}
+
+		return res.Header.Revision, nil
 	}
+}
 
+// SetBatch stores the given events, in order, under the keys with
+// the same index and returns the outcome of each write, with the
+// same semantics of Set.
+//
+// The events are written in transactions of up to maxBatchTxnEvents
+// distinct keys, all attached to the shared lease of the current time
+// window: the events written together share their revision.
+func (c *Client) SetBatch(keys []string, evs []*corev1.Event) []SetResult {
+	res := make([]SetResult, len(keys))
+
+	var chunk []int
+	seen := map[string]bool{}
+	for i, k := range keys {
+		// The versions of the same event go in different
+		// transactions, one after the other.
+		if seen[k] || len(chunk) == maxBatchTxnEvents {
+			c.setChunk(chunk, keys, evs, res)
+			chunk = chunk[:0]
+			clear(seen)
+		}
+		chunk = append(chunk, i)
+		seen[k] = true
+	}
+	if len(chunk) > 0 {
+		c.setChunk(chunk, keys, evs, res)
+	}
+
+	return res
+}
+
+// setChunk writes the events of a batch at the given indexes, whose
+// keys are all distinct, in one transaction and fills their results.
+//
+// If any of the keys is written in the meantime, the events are
+// written one by one instead.
+func (c *Client) setChunk(idx []int, keys []string, evs []*corev1.Event, res []SetResult) {
 	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), c.timeOut)
 	defer cancel()
-	_, err := c.c.Put(ctxWithTimeout, k, buf.String(), opts...)
-	return err
+
+	fail := func(err error) {
+		for _, i := range idx {
+			res[i] = SetResult{Err: err}
+		}
+	}
+
+	gets := make([]clientv3.Op, len(idx))
+	for j, i := range idx {
+		gets[j] = clientv3.OpGet(keys[i])
+	}
+	getRes, err := c.c.Txn(ctxWithTimeout).Then(gets...).Commit()
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	opts := []clientv3.OpOption{}
+	lease := clientv3.NoLease
+	if c.leases != nil {
+		lease, err = c.leases.get(ctxWithTimeout)
+		if err != nil {
+			fail(err)
+			return
+		}
+		opts = append(opts, clientv3.WithLease(lease))
+	}
+
+	var todo []int
+	var cmps []clientv3.Cmp
+	var ops []clientv3.Op
+	for j, i := range idx {
+		k, v := keys[i], evs[i]
+
+		buf := bytes.Buffer{}
+		if err := json.NewEncoder(&buf).Encode(v); err != nil {
+			res[i] = SetResult{Err: err}
+			continue
+		}
+
+		// The write succeeds only if the key has not changed
+		// since it was read (zero if it did not exist).
+		var modRev int64
+		var oldIndex string
+		if kvs := getRes.Responses[j].GetResponseRange().Kvs; len(kvs) > 0 {
+			var old corev1.Event
+			if err := json.Unmarshal(kvs[0].Value, &old); err == nil {
+				if isStale(&old, v) {
+					res[i] = SetResult{Revision: kvs[0].ModRevision, Err: ErrDuplicate}
+					continue
+				}
+				oldIndex = indexKey(k, &old)
+			}
+			modRev = kvs[0].ModRevision
+		}
+
+		todo = append(todo, i)
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(k), "=", modRev))
+		ops = append(ops, putOps(k, buf.String(), v, opts...)...)
+		if len(oldIndex) > 0 && oldIndex != indexKey(k, v) {
+			// The event occurred again: its time index entry moves.
+			ops = append(ops, clientv3.OpDelete(oldIndex))
+		}
+	}
+	if len(todo) == 0 {
+		return
+	}
+
+	txnRes, err := c.c.Txn(ctxWithTimeout).If(cmps...).Then(ops...).Commit()
+	switch {
+	case errors.Is(err, rpctypes.ErrLeaseNotFound) && c.leases != nil:
+		// The lease has gone (i.e. revoked by hand): Set gets a new one.
+		c.leases.forget(lease)
+	case err != nil:
+		for _, i := range todo {
+			res[i] = SetResult{Err: err}
+		}
+		return
+	case txnRes.Succeeded:
+		for _, i := range todo {
+			res[i] = SetResult{Revision: txnRes.Header.Revision}
+		}
+		return
+	}
+
+	// Written in the meantime, or without a lease: one by one,
+	// comparing each event with the stored version.
+	for _, i := range todo {
+		rev, err := c.Set(keys[i], evs[i])
+		res[i] = SetResult{Revision: rev, Err: err}
+	}
+}
+
+// putOps returns the operations that store the value
+// both under the given key and in the time index.
+func putOps(k, v string, ev *corev1.Event, opts ...clientv3.OpOption) []clientv3.Op {
+	ops := []clientv3.Op{clientv3.OpPut(k, v, opts...)}
+	if ik := indexKey(k, ev); len(ik) > 0 {
+		ops = append(ops, clientv3.OpPut(ik, v, opts...))
+	}
+	return ops
 }
 
 type GetOptions struct {
-	Limit  int
+	Limit int
+	// EndKey, if set, restricts the results to the
+	// keys in the range [k, EndKey).
 	EndKey string
+	// Match, if set, selects the events to return; the
+	// limit counts only the matching ones.
+	Match func(ev *corev1.Event) bool
 }
 
-// Get retrieves the stored value for the given key.
-func (c *Client) Get(k string, opts GetOptions) (data []corev1.Event, found bool, err error) {
-	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), c.timeOut)
-	defer cancel()
-
-	ops := []clientv3.OpOption{
-		clientv3.WithLimit(int64(opts.Limit)),
-		clientv3.WithSort(clientv3.SortByKey, clientv3.SortDescend),
+// Get retrieves, in descending key order, the stored values
+// whose key starts with the given one.
+func (c *Client) Get(k string, opts GetOptions) (data []Record, found bool, err error) {
+	pageSize := opts.Limit
+	if opts.Match != nil && pageSize < defaultPageSize {
+		pageSize = defaultPageSize
 	}
-	if len(opts.EndKey) > 0 {
-		ops = append(ops, clientv3.WithRange(opts.EndKey))
-	} else {
-		ops = append(ops, clientv3.WithPrefix())
+
+	end := opts.EndKey
+	for {
+		ops := []clientv3.OpOption{
+			clientv3.WithLimit(int64(pageSize)),
+			clientv3.WithSort(clientv3.SortByKey, clientv3.SortDescend),
+		}
+		if len(end) > 0 {
+			ops = append(ops, clientv3.WithRange(end))
+		} else {
+			ops = append(ops, clientv3.WithPrefix())
+		}
+
+		ctxWithTimeout, cancel := context.WithTimeout(context.Background(), c.timeOut)
+		getRes, err := c.c.Get(ctxWithTimeout, k, ops...)
+		cancel()
+		if err != nil {
+			return data, false, err
+		}
+
+		for _, el := range getRes.Kvs {
+			var obj corev1.Event
+			if err := json.Unmarshal(el.Value, &obj); err != nil {
+				return data, false, err
+			}
+
+			if opts.Match != nil && !opts.Match(&obj) {
+				continue
+			}
+
+			data = append(data, Record{
+				Key:      string(el.Key),
+				Revision: el.ModRevision,
+				Updated:  el.Version > 1,
+				Event:    obj,
+			})
+			if opts.Limit > 0 && len(data) == opts.Limit {
+				return data, true, nil
+			}
+		}
+
+		if !getRes.More || len(getRes.Kvs) == 0 {
+			break
+		}
+		// Carry on before the last scanned key.
+		end = string(getRes.Kvs[len(getRes.Kvs)-1].Key)
 	}
 
-	getRes, err := c.c.Get(ctxWithTimeout, k, ops...)
+	// If no value was found return false
+	return data, len(data) > 0, nil
+}
+
+// Since retrieves, oldest first, the events written after the given revision.
+//
+// A limit of zero means no limit; the events written in the same
+// transaction are returned together, even beyond the limit.
+func (c *Client) Since(rev int64, limit int) (data []Record, err error) {
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), c.timeOut)
+	defer cancel()
+
+	getRes, err := c.c.Get(ctxWithTimeout, keyPrefix,
+		clientv3.WithPrefix(),
+		clientv3.WithMinModRev(rev+1),
+		clientv3.WithSort(clientv3.SortByModRevision, clientv3.SortAscend),
+		clientv3.WithLimit(int64(limit)),
+	)
 	if err != nil {
-		return data, false, err
+		return data, err
 	}
-
 	kvs := getRes.Kvs
-	// If no value was found return false
-	if len(kvs) == 0 {
-		return data, false, nil
+
+	// The events written in the same transaction share the revision:
+	// the page is never cut among them, or the next one would miss
+	// the rest.
+	if n := len(kvs); limit > 0 && n == limit {
+		last := kvs[n-1].ModRevision
+		restRes, err := c.c.Get(ctxWithTimeout, keyPrefix,
+			clientv3.WithPrefix(),
+			clientv3.WithMinModRev(last),
+			clientv3.WithMaxModRev(last),
+		)
+		if err != nil {
+			return data, err
+		}
+
+		got := map[string]bool{}
+		for i := n - 1; i >= 0 && kvs[i].ModRevision == last; i-- {
+			got[string(kvs[i].Key)] = true
+		}
+		for _, el := range restRes.Kvs {
+			if !got[string(el.Key)] {
+				kvs = append(kvs, el)
+			}
+		}
 	}
 
-	for _, el := range kvs {
+	for i, el := range kvs {
 		var obj corev1.Event
 		if err := json.Unmarshal(el.Value, &obj); err != nil {
-			return data, false, err
+			return data, err
 		}
 
-		data = append(data, obj)
+		data = append(data, Record{
+			Key:      string(el.Key),
+			Revision: el.ModRevision,
+			Updated:  el.Version > 1,
+			More:     i+1 < len(kvs) && kvs[i+1].ModRevision == el.ModRevision,
+			Event:    obj,
+		})
 	}
 
-	return data, true, nil
+	return data, nil
+}
+
+// Revision returns the current revision of the etcd cluster.
+func (c *Client) Revision() (int64, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), c.timeOut)
+	defer cancel()
+
+	getRes, err := c.c.Get(ctxWithTimeout, keyPrefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return 0, err
+	}
+	return getRes.Header.Revision, nil
 }
 
-// Delete deletes the stored value for the given key.
+// Watch streams the events written after the given revision.
+//
+// Broken watches are transparently re-established; if the requested
+// revision has been compacted, the stream resumes from the oldest
+// available one.
+func (c *Client) Watch(ctx context.Context, rev int64) <-chan Record {
+	out := make(chan Record)
+
+	go func() {
+		defer close(out)
+
+		for ctx.Err() == nil {
+			if rev == 0 {
+				// Pin the current revision, so that nothing gets lost
+				// if the watch has to be re-established.
+				res, err := c.c.Get(ctx, keyPrefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+				if err == nil {
+					rev = res.Header.Revision
+				}
+			}
+
+			opts := []clientv3.OpOption{
+				clientv3.WithPrefix(),
+				clientv3.WithFilterDelete(),
+			}
+			if rev > 0 {
+				opts = append(opts, clientv3.WithRev(rev+1))
+			}
+
+			wch := c.c.Watch(clientv3.WithRequireLeader(ctx), keyPrefix, opts...)
+			for res := range wch {
+				if res.CompactRevision > 0 {
+					rev = res.CompactRevision - 1
+				}
+				if res.Err() != nil {
+					break
+				}
+
+				for i, ev := range res.Events {
+					var obj corev1.Event
+					if err := json.Unmarshal(ev.Kv.Value, &obj); err != nil {
+						rev = ev.Kv.ModRevision
+						continue
+					}
+
+					select {
+					case out <- Record{
+						Key:      string(ev.Kv.Key),
+						Revision: ev.Kv.ModRevision,
+						Updated:  ev.IsModify(),
+						More:     i+1 < len(res.Events) && res.Events[i+1].Kv.ModRevision == ev.Kv.ModRevision,
+						Event:    obj,
+					}:
+					case <-ctx.Done():
+						return
+					}
+					rev = ev.Kv.ModRevision
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(watchRetryDelay):
+			}
+		}
+	}()
+
+	return out
+}
+
+// Delete deletes the stored value for the given key
+// together with its time index entry.
 func (c *Client) Delete(k string) error {
 	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), c.timeOut)
 	defer cancel()
-	_, err := c.c.Delete(ctxWithTimeout, k)
+
+	for {
+		getRes, err := c.c.Get(ctxWithTimeout, k)
+		if err != nil {
+			return err
+		}
+		if len(getRes.Kvs) == 0 {
+			return nil
+		}
+
+		ok, err := c.deleteKV(ctxWithTimeout, k, getRes.Kvs[0])
+		if err != nil || ok {
+			return err
+		}
+		// Updated in the meantime: read it again.
+	}
+}
+
+// DeleteRevision deletes the stored value for the given key, together
+// with its time index entry, only if it is still the one written
+// at the given revision.
+func (c *Client) DeleteRevision(k string, rev int64) (bool, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), c.timeOut)
+	defer cancel()
+
+	getRes, err := c.c.Get(ctxWithTimeout, k)
+	if err != nil {
+		return false, err
+	}
+	if len(getRes.Kvs) == 0 || getRes.Kvs[0].ModRevision != rev {
+		return false, nil
+	}
+
+	return c.deleteKV(ctxWithTimeout, k, getRes.Kvs[0])
+}
+
+// deleteKV deletes the given key and its time index entry
+// in one transaction, if the key has not changed since kv
+// has been read; it reports whether they have been deleted.
+func (c *Client) deleteKV(ctx context.Context, k string, kv *mvccpb.KeyValue) (bool, error) {
+	// The time index key depends on the stored version.
+	ops := []clientv3.Op{clientv3.OpDelete(k)}
+	var obj corev1.Event
+	if err := json.Unmarshal(kv.Value, &obj); err == nil {
+		if ik := indexKey(k, &obj); len(ik) > 0 {
+			ops = append(ops, clientv3.OpDelete(ik))
+		}
+	}
+
+	res, err := c.c.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(k), "=", kv.ModRevision)).
+		Then(ops...).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	return res.Succeeded, nil
+}
+
+// Migrate moves the events stored with the legacy key layout
+// (events/comp-<composition-id>/<uid>) to the time ordered one,
+// keeping their leases; it is safe to run it many times.
+func (c *Client) Migrate() (int, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	getRes, err := c.c.Get(ctxWithTimeout, keyPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, el := range getRes.Kvs {
+		if isTimedKey(string(el.Key)) {
+			continue
+		}
+
+		var obj corev1.Event
+		if err := json.Unmarshal(el.Value, &obj); err != nil {
+			return count, err
+		}
+
+		opts := []clientv3.OpOption{}
+		if el.Lease != 0 {
+			opts = append(opts, clientv3.WithLease(clientv3.LeaseID(el.Lease)))
+		}
+
+		newKey := eventKey(&obj)
+		unchanged := clientv3.Compare(clientv3.ModRevision(string(el.Key)), "=", el.ModRevision)
+		ops := putOps(newKey, string(el.Value), &obj, opts...)
+		ops = append(ops, clientv3.OpDelete(string(el.Key)))
+
+		// Skip the keys changed in the meantime, and never overwrite
+		// the events already written with the new layout (i.e. by
+		// the upgraded replicas during a rolling update): the legacy
+		// copy is stale then, and is just deleted.
+		res, err := c.c.Txn(ctxWithTimeout).
+			If(unchanged, clientv3.Compare(clientv3.CreateRevision(newKey), "=", 0)).
+			Then(ops...).
+			Else(clientv3.OpTxn(
+				[]clientv3.Cmp{unchanged},
+				[]clientv3.Op{clientv3.OpDelete(string(el.Key))},
+				nil,
+			)).
+			Commit()
+		if err != nil {
+			return count, err
+		}
+		if res.Succeeded {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// Ping reads a key, so that it succeeds only
+// if the etcd cluster has a quorum.
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.c.Get(ctx, "health", clientv3.WithCountOnly())
 	return err
 }
 
@@ -152,6 +723,11 @@ type Options struct {
 	Endpoints []string
 	// Sored Items TTL in seconds
 	TTL int64
+	// LeaseBuckets is the number of time windows the TTL is split in:
+	// the events written in the same window share an etcd lease, so
+	// they live up to TTL*(1+1/LeaseBuckets).
+	// Optional (10 by default).
+	LeaseBuckets int
 }
 
 // DefaultOptions is an Options object with default values.
@@ -193,6 +769,7 @@ func NewClient(options Options) (Store, error) {
 
 	result.c = cli
 	result.timeOut = defaultTimeout
+	result.buckets = options.LeaseBuckets
 
 	return result, nil
 }
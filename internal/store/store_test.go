@@ -1,8 +1,13 @@
 package store
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"path"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -10,6 +15,7 @@ import (
 	"github.com/krateoplatformops/eventsse/internal/labels"
 
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestClientTTL(t *testing.T) {
@@ -18,12 +24,33 @@ func TestClientTTL(t *testing.T) {
 }
 
 func TestClientPrepareKey(t *testing.T) {
-	const exp = "events/comp-abc/123"
+	const exp = "events/comp-abc/20240705T073307.000000000Z-123"
+
+	nfo := corev1.Event{}
+	nfo.UID = "123"
+	nfo.Labels = map[string]string{"krateo.io/composition-id": "ABC"}
+	nfo.FirstTimestamp = metav1.NewTime(time.Date(2024, 7, 5, 7, 33, 7, 0, time.UTC))
+	nfo.LastTimestamp = metav1.NewTime(time.Date(2024, 7, 5, 7, 33, 9, 0, time.UTC))
 
 	var c KeyPreparer = &Client{}
-	got := c.PrepareKey("123", "abc")
+	got := c.PrepareKey(&nfo)
 	if got != exp {
-		t.Fatalf("ttl: got %v, expected %v", got, exp)
+		t.Fatalf("key: got %v, expected %v", got, exp)
+	}
+
+	nfo.Labels = nil
+	if got := c.PrepareKey(&nfo); got != "events/20240705T073307.000000000Z-123" {
+		t.Fatalf("key: got %v, expected %v", got, "events/20240705T073307.000000000Z-123")
+	}
+}
+
+func TestClientPreparePrefix(t *testing.T) {
+	var c KeyPreparer = &Client{}
+	if got := c.PreparePrefix("ABC"); got != "events/comp-abc/" {
+		t.Fatalf("prefix: got %v, expected %v", got, "events/comp-abc/")
+	}
+	if got := c.PreparePrefix(""); got != "timeline/" {
+		t.Fatalf("prefix: got %v, expected %v", got, "timeline/")
 	}
 }
 
@@ -44,7 +71,7 @@ func TestGet(t *testing.T) {
 	}
 	defer sto.Close()
 
-	key := sto.PrepareKey("", "abcde12345")
+	key := sto.PreparePrefix("abcde12345")
 	_, ok, err := sto.Get(key, GetOptions{
 		Limit: 10,
 	})
@@ -90,13 +117,329 @@ func TestPut(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		key := sto.PrepareKey(string(nfo.UID), labels.CompositionID(&nfo))
+		key := sto.PrepareKey(&nfo)
 		t.Logf("key: %s", key)
 
-		err = sto.Set(key, &nfo)
+		_, err = sto.Set(key, &nfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	if len(os.Getenv("INTEGRATION")) == 0 {
+		t.Skip("skipping integration tests: set INTEGRATION environment variable")
+	}
+
+	sto, err := NewClient(DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sto.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	nfo := corev1.Event{}
+	nfo.Name = "watched"
+	nfo.UID = "watched-uid"
+
+	key := sto.PrepareKey(&nfo)
+	rev, err := sto.Set(key, &nfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wch := sto.Watch(ctx, rev-1)
+
+	select {
+	case got := <-wch:
+		if got.Key != key || got.Revision != rev {
+			t.Fatalf("got (%s, %d), expected (%s, %d)", got.Key, got.Revision, key, rev)
+		}
+	case <-ctx.Done():
+		t.Fatal("event not watched")
+	}
+}
+
+// newClient returns a client of the etcd at localhost:2379,
+// skipping the test if INTEGRATION is not set.
+func newClient(t testing.TB) *Client {
+	t.Helper()
+	if len(os.Getenv("INTEGRATION")) == 0 {
+		t.Skip("skipping integration tests: set INTEGRATION environment variable")
+	}
+
+	sto, err := NewClient(DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sto.Close() })
+	return sto.(*Client)
+}
+
+// uniqueID keeps the keys of each run apart, since
+// the etcd used for the tests is not emptied.
+func uniqueID(name string) string {
+	return fmt.Sprintf("%s-%d", name, time.Now().UnixNano())
+}
+
+func TestClientMigrate(t *testing.T) {
+	sto := newClient(t)
+
+	cid := uniqueID("migrate")
+	// The stored timestamps have a second precision.
+	ev := newEvent(cid, uniqueID("uid"), "Normal", time.Now().Truncate(time.Second))
+	buf, err := json.Marshal(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The legacy layout: events/comp-<composition-id>/<uid>
+	legacy := path.Join(keyRoot, compositionDir(cid), string(ev.UID))
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := sto.c.Put(ctx, legacy, string(buf)); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := sto.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count < 1 {
+		t.Fatalf("migrated: got %d, expected at least 1", count)
+	}
+
+	res, err := sto.c.Get(ctx, legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Kvs) != 0 {
+		t.Fatalf("legacy key %s not deleted", legacy)
+	}
+
+	key := sto.PrepareKey(ev)
+	for _, k := range []string{key, indexKey(key, ev)} {
+		res, err := sto.c.Get(ctx, k)
 		if err != nil {
 			t.Fatal(err)
 		}
+		if len(res.Kvs) != 1 {
+			t.Fatalf("key %s not written", k)
+		}
+	}
+
+	// Nothing left to move: running it again is a no op.
+	again, err := sto.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := sto.Get(sto.PreparePrefix(cid), GetOptions{}); len(got) != 1 {
+		t.Fatalf("got %d events after the second migration (%d moved), expected 1", len(got), again)
+	}
+}
+
+func TestClientMigrateNewer(t *testing.T) {
+	sto := newClient(t)
+
+	cid := uniqueID("migrate")
+	ev := newEvent(cid, uniqueID("uid"), "Normal", time.Now().Truncate(time.Second))
+	stale, err := json.Marshal(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An upgraded replica has already written a newer version.
+	ev.Count = 2
+	if _, err := sto.Set(sto.PrepareKey(ev), ev); err != nil {
+		t.Fatal(err)
+	}
+
+	legacy := path.Join(keyRoot, compositionDir(cid), string(ev.UID))
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := sto.c.Put(ctx, legacy, string(stale)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sto.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	all, _, err := sto.Get(sto.PreparePrefix(cid), GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0].Event.Count != 2 {
+		t.Fatalf("got %+v, expected the newer version", all)
+	}
+
+	res, err := sto.c.Get(ctx, legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Kvs) != 0 {
+		t.Fatalf("stale legacy key %s not deleted", legacy)
+	}
+}
+
+func TestClientDuplicate(t *testing.T) {
+	sto := newClient(t)
+
+	ev := newEvent(uniqueID("dup"), uniqueID("uid"), "Normal", time.Now())
+	ev.ResourceVersion = "100"
+	key := sto.PrepareKey(ev)
+
+	rev1, err := sto.Set(key, ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rev2, err := sto.Set(key, ev)
+	if !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("got %v, expected %v", err, ErrDuplicate)
+	}
+	if rev2 != rev1 {
+		t.Fatalf("revision: got %d, expected %d", rev2, rev1)
+	}
+
+	// A new resource version is written.
+	ev.ResourceVersion = "101"
+	rev3, err := sto.Set(key, ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev3 <= rev1 {
+		t.Fatalf("revision: got %d, expected greater than %d", rev3, rev1)
+	}
+}
+
+func TestClientOutOfOrder(t *testing.T) {
+	sto := newClient(t)
+
+	testOutOfOrder(t, sto, uniqueID("order"))
+}
+
+func TestClientTimeline(t *testing.T) {
+	sto := newClient(t)
+
+	testTimeline(t, sto, uniqueID("timeline"))
+}
+
+func TestClientDeleteRevision(t *testing.T) {
+	sto := newClient(t)
+
+	testDeleteRevision(t, sto, uniqueID("delete"))
+}
+
+func TestClientSetBatch(t *testing.T) {
+	sto := newClient(t)
+
+	var requests atomic.Int64
+	sto.c.KV = &countingKV{KV: sto.c.KV, n: &requests}
+
+	id := uniqueID("batch")
+	now := time.Now().Truncate(time.Second)
+
+	// Two transactions of new events, then a new version
+	// of the first one and a duplicate of the second one.
+	var keys []string
+	var evs []*corev1.Event
+	for i := 0; i < 2*maxBatchTxnEvents; i++ {
+		ev := newEvent(id, fmt.Sprintf("%s-%02d", id, i), "Normal", now)
+		keys = append(keys, sto.PrepareKey(ev))
+		evs = append(evs, ev)
+	}
+	again := *evs[0]
+	again.Count = 2
+	keys = append(keys, keys[0], keys[1])
+	evs = append(evs, &again, evs[1])
+
+	res := sto.SetBatch(keys, evs)
+
+	if got := requests.Load(); got != 6 {
+		t.Errorf("requests: got %d, expected 6", got)
+	}
+
+	first := res[0].Revision
+	for i, el := range res[:2*maxBatchTxnEvents] {
+		exp := first
+		if i >= maxBatchTxnEvents {
+			exp = res[maxBatchTxnEvents].Revision
+		}
+		if el.Err != nil || el.Revision != exp {
+			t.Fatalf("item %d: got %d (%v), expected %d", i, el.Revision, el.Err, exp)
+		}
+	}
+	if el := res[len(res)-2]; el.Err != nil || el.Revision <= res[maxBatchTxnEvents].Revision {
+		t.Fatalf("new version: got %d (%v), expected a later revision", el.Revision, el.Err)
+	}
+	if el := res[len(res)-1]; !errors.Is(el.Err, ErrDuplicate) || el.Revision != first {
+		t.Fatalf("duplicate: got %d (%v), expected %d (%v)", el.Revision, el.Err, first, ErrDuplicate)
+	}
+
+	// A page never splits the events of a transaction.
+	all, err := sto.Since(first-1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != maxBatchTxnEvents-1 {
+		t.Fatalf("got %d events, expected %d", len(all), maxBatchTxnEvents-1)
+	}
+	for i, el := range all {
+		if el.Revision != first || el.More != (i < len(all)-1) {
+			t.Fatalf("item %d: got %d (more: %t), expected %d", i, el.Revision, el.More, first)
+		}
+	}
+}
+
+func TestClientGetPaging(t *testing.T) {
+	sto := newClient(t)
+
+	cid := uniqueID("paging")
+	now := time.Now()
+
+	// More than a page, so that Get has to carry on.
+	n := defaultPageSize + 20
+	keys := make([]string, n)
+	for i := 0; i < n; i++ {
+		typ := "Normal"
+		if i%2 == 0 {
+			typ = "Warning"
+		}
+		ev := newEvent(cid, fmt.Sprintf("%03d", i), typ, now.Add(time.Duration(i)*time.Second))
+		keys[i] = sto.PrepareKey(ev)
+		if _, err := sto.Set(keys[i], ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prefix := sto.PreparePrefix(cid)
+
+	// The matching events are collected across the pages.
+	all, ok, err := sto.Get(prefix, GetOptions{
+		Limit: n,
+		Match: func(ev *corev1.Event) bool { return ev.Type == "Warning" },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || len(all) != n/2 {
+		t.Fatalf("got %d events, expected %d", len(all), n/2)
+	}
+	if all[0].Key != keys[n-2] || all[len(all)-1].Key != keys[0] {
+		t.Fatalf("got %s .. %s, expected %s .. %s", all[0].Key, all[len(all)-1].Key, keys[n-2], keys[0])
+	}
+
+	// EndKey excludes the given key and the ones after it.
+	all, _, err = sto.Get(prefix, GetOptions{Limit: 5, EndKey: keys[50]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := keysOf(all), keys[45:50]; len(got) != 5 || got[0] != expected[4] || got[4] != expected[0] {
+		t.Fatalf("got %v, expected %v in reverse order", got, expected)
 	}
 }
 
@@ -106,23 +449,46 @@ var _ Store = (*MockStore)(nil)
 type MockStore struct {
 	data *cache.TTLCache[string, corev1.Event]
 	ttl  time.Duration
+	rev  int64
 }
 
-func (m *MockStore) PrepareKey(uid, compositionID string) string {
-	return uid + ":" + compositionID
+func (m *MockStore) PrepareKey(ev *corev1.Event) string {
+	return string(ev.UID) + ":" + labels.CompositionID(ev)
 }
 
-func (m *MockStore) Set(key string, event *corev1.Event) error {
+func (m *MockStore) PreparePrefix(compositionID string) string {
+	return ":" + compositionID
+}
+
+func (m *MockStore) Set(key string, event *corev1.Event) (int64, error) {
 	m.data.Set(key, *event, m.ttl)
-	return nil
+	m.rev++
+	return m.rev, nil
 }
 
-func (m *MockStore) Get(key string, opts GetOptions) (data []corev1.Event, found bool, err error) {
+func (m *MockStore) Get(key string, opts GetOptions) (data []Record, found bool, err error) {
 	obj, exists := m.data.Get(key)
 	if !exists {
 		return nil, false, nil
 	}
-	return []corev1.Event{obj}, true, nil
+	return []Record{{Key: key, Event: obj}}, true, nil
+}
+
+func (m *MockStore) Since(rev int64, limit int) ([]Record, error) {
+	return nil, nil
+}
+
+func (m *MockStore) Revision() (int64, error) {
+	return m.rev, nil
+}
+
+func (m *MockStore) Watch(ctx context.Context, rev int64) <-chan Record {
+	out := make(chan Record)
+	go func() {
+		defer close(out)
+		<-ctx.Done()
+	}()
+	return out
 }
 
 func (m *MockStore) Delete(key string) error {
@@ -130,6 +496,11 @@ func (m *MockStore) Delete(key string) error {
 	return nil
 }
 
+func (m *MockStore) DeleteRevision(key string, _ int64) (bool, error) {
+	_, ok := m.data.Pop(key)
+	return ok, nil
+}
+
 func (m *MockStore) SetTTL(x int) {
 	m.ttl = time.Second * time.Duration(x)
 }
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// pollWatch streams the events written after the given revision
+// by the stores that live in this process: it reads them with since
+// and, once caught up, waits for the channel returned by changed to
+// be closed by the next write.
+//
+// The stream ends when the context or the done channel are done.
+func pollWatch(ctx context.Context, rev int64, done <-chan struct{},
+	since func(rev int64, limit int) ([]Record, error), changed func() <-chan struct{}) <-chan Record {
+	out := make(chan Record)
+
+	go func() {
+		defer close(out)
+
+		for {
+			// Get the channel before reading, so that
+			// no write can get lost in the meantime.
+			notify := changed()
+
+			var retry <-chan time.Time
+			all, err := since(rev, defaultPageSize)
+			if err != nil {
+				retry = time.After(watchRetryDelay)
+			}
+
+			for _, el := range all {
+				select {
+				case out <- el:
+				case <-ctx.Done():
+					return
+				}
+				rev = el.Revision
+			}
+
+			if len(all) == defaultPageSize {
+				continue
+			}
+
+			select {
+			case <-notify:
+			case <-retry:
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return out
+}
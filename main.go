@@ -12,15 +12,26 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/krateoplatformops/eventsse/internal/cache"
+	"github.com/krateoplatformops/eventsse/internal/authz"
+	"github.com/krateoplatformops/eventsse/internal/broker"
 	"github.com/krateoplatformops/eventsse/internal/env"
 	"github.com/krateoplatformops/eventsse/internal/handlers/getter"
 	"github.com/krateoplatformops/eventsse/internal/handlers/health"
 	"github.com/krateoplatformops/eventsse/internal/handlers/publisher"
 	"github.com/krateoplatformops/eventsse/internal/handlers/subscriber"
+	"github.com/krateoplatformops/eventsse/internal/metrics"
+	"github.com/krateoplatformops/eventsse/internal/middlewares/auth"
+	"github.com/krateoplatformops/eventsse/internal/middlewares/cors"
+	"github.com/krateoplatformops/eventsse/internal/middlewares/logger"
+	"github.com/krateoplatformops/eventsse/internal/middlewares/signature"
+	"github.com/krateoplatformops/eventsse/internal/oidc"
+	"github.com/krateoplatformops/eventsse/internal/retention"
+	"github.com/krateoplatformops/eventsse/internal/server"
 	"github.com/krateoplatformops/eventsse/internal/store"
 	"github.com/rs/zerolog"
-	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 
 	_ "github.com/krateoplatformops/eventsse/docs"
 	httpSwagger "github.com/swaggo/http-swagger"
@@ -30,14 +41,114 @@ const (
 	serviceName = "eventsse"
 )
 
+// Injected at build time:
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse --short HEAD)"
+var (
+	version = "dev"
+	commit  = "none"
+)
+
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description OIDC token as 'Bearer <token>', when the authentication is enabled
 func main() {
 	debugOn := flag.Bool("debug", env.Bool("EVENTSSE_DEBUG", true), "dump verbose output")
 	dumpEnv := flag.Bool("dump-env", env.Bool("EVENTSSE_DUMP_ENV", false), "dump environment variables")
-	port := flag.Int("port", env.Int("EVENTSSE_PORT", 8181), "port to listen on")
+	port := flag.Int("port", env.Int("EVENTSSE_PORT", 8181), "port of the public listener (SSE, events, docs)")
+	readTimeout := flag.Duration("read-timeout", env.Duration("EVENTSSE_READ_TIMEOUT", 10*time.Second),
+		"max duration for reading a request on the public listener")
+	writeTimeout := flag.Duration("write-timeout", env.Duration("EVENTSSE_WRITE_TIMEOUT", 50*time.Second),
+		"max duration for writing a response on the public listener, except the SSE streams (0 for no timeout)")
+	idleTimeout := flag.Duration("idle-timeout", env.Duration("EVENTSSE_IDLE_TIMEOUT", 30*time.Second),
+		"max amount of time to wait for the next request on the public listener")
+	internalPort := flag.Int("internal-port", env.Int("EVENTSSE_INTERNAL_PORT", 8182),
+		"port of the internal listener (ingestion, admin)")
+	internalReadTimeout := flag.Duration("internal-read-timeout", env.Duration("EVENTSSE_INTERNAL_READ_TIMEOUT", 10*time.Second),
+		"max duration for reading a request on the internal listener")
+	internalWriteTimeout := flag.Duration("internal-write-timeout", env.Duration("EVENTSSE_INTERNAL_WRITE_TIMEOUT", 50*time.Second),
+		"max duration for writing a response on the internal listener")
+	internalIdleTimeout := flag.Duration("internal-idle-timeout", env.Duration("EVENTSSE_INTERNAL_IDLE_TIMEOUT", 30*time.Second),
+		"max amount of time to wait for the next request on the internal listener")
 	ttl := flag.Int("ttl", env.Int("EVENTSSE_TTL", 120), "stored event exipre time in seconds")
 	limit := flag.Int("limit", env.Int("EVENTSSE_GET_LIMIT", 100),
 		"limits the number of results to return from 'Get' request")
+	storeKind := flag.String("store", env.String("EVENTSSE_STORE", "etcd"), "where the events are stored (etcd, memory, bolt)")
+	maxEvents := flag.Int("store-max-events", env.Int("EVENTSSE_STORE_MAX_EVENTS", 10000),
+		"max number of events kept by the memory store")
+	boltPath := flag.String("bolt-path", env.String("EVENTSSE_BOLT_PATH", "/data/eventsse.db"),
+		"database file of the bolt store")
 	endpoints := flag.String("etcd-servers", env.String("EVENTSSE_ETCD_SERVERS", "localhost:2379"), "etcd endpoints")
+	leaseBuckets := flag.Int("lease-buckets", env.Int("EVENTSSE_LEASE_BUCKETS", 10),
+		"number of time windows the TTL is split in, the events written in the same window share an etcd lease")
+	migrate := flag.Bool("migrate-keys", env.Bool("EVENTSSE_MIGRATE_KEYS", true),
+		"move the events stored with the legacy key layout to the time ordered one at startup")
+	maxPerComposition := flag.Int("retention-max-per-composition", env.Int("EVENTSSE_RETENTION_MAX_PER_COMPOSITION", 0),
+		"max number of events kept for each composition (0 to disable)")
+	maxAge := flag.String("retention-max-age", env.String("EVENTSSE_RETENTION_MAX_AGE", ""),
+		"max age of the events by type, i.e. 'Warning=7d,Normal=1h,*=24h'")
+	maxSize := flag.Int("retention-max-size", env.Int("EVENTSSE_RETENTION_MAX_SIZE", 0),
+		"max size in MB of the stored events (0 to disable)")
+	retentionInterval := flag.Duration("retention-interval", env.Duration("EVENTSSE_RETENTION_INTERVAL", time.Minute),
+		"interval between two enforcements of the retention policy")
+	keepAlive := flag.Duration("sse-keepalive", env.Duration("EVENTSSE_SSE_KEEPALIVE", 15*time.Second),
+		"interval between keepalive comments sent on idle SSE streams (0 to disable)")
+	retry := flag.Duration("sse-retry", env.Duration("EVENTSSE_SSE_RETRY", 3*time.Second),
+		"reconnection time advertised to SSE clients (0 to disable)")
+	sseBuffer := flag.Int("sse-buffer", env.Int("EVENTSSE_SSE_BUFFER", 64),
+		"max number of events queued for each SSE client")
+	sseOverflow := flag.String("sse-overflow", env.String("EVENTSSE_SSE_OVERFLOW", broker.DropOldest.String()),
+		"what to do when an SSE client queue is full (drop-oldest, drop-newest, disconnect)")
+
+	hmacSecrets := flag.String("hmac-secrets", env.String("EVENTSSE_HMAC_SECRETS", ""),
+		"comma separated shared secrets the '/handle' requests must be signed with")
+	hmacSecretFile := flag.String("hmac-secret-file", env.String("EVENTSSE_HMAC_SECRET_FILE", ""),
+		"file holding the shared secrets (one per line) the '/handle' requests must be signed with")
+	hmacTolerance := flag.Duration("hmac-tolerance", env.Duration("EVENTSSE_HMAC_TOLERANCE", signature.DefaultTolerance),
+		"max difference between the signed requests timestamp and the local clock")
+
+	oidcIssuer := flag.String("oidc-issuer", env.String("EVENTSSE_OIDC_ISSUER", ""),
+		"issuer of the tokens required to read the events (empty to disable the authentication)")
+	oidcAudience := flag.String("oidc-audience", env.String("EVENTSSE_OIDC_AUDIENCE", ""),
+		"expected audience of the tokens (empty to skip the check)")
+	oidcJWKS := flag.String("oidc-jwks", env.String("EVENTSSE_OIDC_JWKS", ""),
+		"file or http(s) URL of the JSON Web Key Set the tokens are signed with")
+	oidcJWKSRefresh := flag.Duration("oidc-jwks-refresh", env.Duration("EVENTSSE_OIDC_JWKS_REFRESH", oidc.DefaultRefresh),
+		"how long the keys fetched from the JWKS URL are cached")
+	authCookie := flag.String("auth-cookie", env.String("EVENTSSE_AUTH_COOKIE", auth.DefaultCookie),
+		"name of the cookie holding the token, for the clients that cannot set headers")
+
+	authzEnabled := flag.Bool("authz", env.Bool("EVENTSSE_AUTHZ", false),
+		"only send the users the events of the compositions they can 'get' in Kubernetes (requires --oidc-issuer)")
+	authzGroup := flag.String("authz-composition-group", env.String("EVENTSSE_AUTHZ_COMPOSITION_GROUP", authz.DefaultGroup),
+		"API group of the compositions")
+	authzCacheTTL := flag.Duration("authz-cache-ttl", env.Duration("EVENTSSE_AUTHZ_CACHE_TTL", authz.DefaultCacheTTL),
+		"how long the access decisions are cached")
+	authzResync := flag.Duration("authz-resync", env.Duration("EVENTSSE_AUTHZ_RESYNC", time.Minute),
+		"how long the compositions found are cached")
+	usernameClaim := flag.String("oidc-username-claim", env.String("EVENTSSE_OIDC_USERNAME_CLAIM", "sub"),
+		"token claim holding the Kubernetes user name")
+	usernamePrefix := flag.String("oidc-username-prefix", env.String("EVENTSSE_OIDC_USERNAME_PREFIX", ""),
+		"prefix of the Kubernetes user name, as the API server --oidc-username-prefix")
+	groupsClaim := flag.String("oidc-groups-claim", env.String("EVENTSSE_OIDC_GROUPS_CLAIM", "groups"),
+		"token claim holding the Kubernetes user groups")
+	groupsPrefix := flag.String("oidc-groups-prefix", env.String("EVENTSSE_OIDC_GROUPS_PREFIX", ""),
+		"prefix of the Kubernetes user groups, as the API server --oidc-groups-prefix")
+
+	corsOrigins := flag.String("cors-allowed-origins", env.String("EVENTSSE_CORS_ALLOWED_ORIGINS", "*"),
+		"comma separated origins allowed to read the events (i.e. 'https://*.example.com', '*' for any, empty for none)")
+	corsMethods := flag.String("cors-allowed-methods", env.String("EVENTSSE_CORS_ALLOWED_METHODS", strings.Join(cors.DefaultMethods, ",")),
+		"comma separated methods of the allowed cross-origin requests")
+	corsHeaders := flag.String("cors-allowed-headers", env.String("EVENTSSE_CORS_ALLOWED_HEADERS", strings.Join(cors.DefaultHeaders, ",")),
+		"comma separated headers the cross-origin requests can set ('*' for any)")
+	corsCredentials := flag.Bool("cors-allow-credentials", env.Bool("EVENTSSE_CORS_ALLOW_CREDENTIALS", false),
+		"let the cross-origin requests carry the cookies")
+	corsMaxAge := flag.Duration("cors-max-age", env.Duration("EVENTSSE_CORS_MAX_AGE", 10*time.Minute),
+		"how long the browsers can cache the preflight responses")
+
+	readyTimeout := flag.Duration("ready-timeout", env.Duration("EVENTSSE_READY_TIMEOUT", health.DefaultReadyTimeout),
+		"max duration of each readiness check (i.e. the store connectivity)")
 
 	flag.Usage = func() {
 		fmt.Fprintln(flag.CommandLine.Output(), "Flags:")
@@ -64,9 +175,50 @@ func main() {
 		evt := log.Debug().
 			Str("debug", fmt.Sprintf("%t", *debugOn)).
 			Str("port", fmt.Sprintf("%d", *port)).
+			Str("read-timeout", readTimeout.String()).
+			Str("write-timeout", writeTimeout.String()).
+			Str("idle-timeout", idleTimeout.String()).
+			Str("internal-port", fmt.Sprintf("%d", *internalPort)).
+			Str("internal-read-timeout", internalReadTimeout.String()).
+			Str("internal-write-timeout", internalWriteTimeout.String()).
+			Str("internal-idle-timeout", internalIdleTimeout.String()).
 			Str("ttl", fmt.Sprintf("%d", *ttl)).
 			Str("limit", fmt.Sprintf("%d", *limit)).
-			Str("etcd-endpoints", *endpoints)
+			Str("store", *storeKind).
+			Str("store-max-events", fmt.Sprintf("%d", *maxEvents)).
+			Str("bolt-path", *boltPath).
+			Str("etcd-endpoints", *endpoints).
+			Str("lease-buckets", fmt.Sprintf("%d", *leaseBuckets)).
+			Str("retention-max-per-composition", fmt.Sprintf("%d", *maxPerComposition)).
+			Str("retention-max-age", *maxAge).
+			Str("retention-max-size", fmt.Sprintf("%d", *maxSize)).
+			Str("retention-interval", retentionInterval.String()).
+			Str("sse-keepalive", keepAlive.String()).
+			Str("sse-retry", retry.String()).
+			Str("sse-buffer", fmt.Sprintf("%d", *sseBuffer)).
+			Str("sse-overflow", *sseOverflow).
+			Bool("hmac-secrets", len(*hmacSecrets) > 0).
+			Str("hmac-secret-file", *hmacSecretFile).
+			Str("hmac-tolerance", hmacTolerance.String()).
+			Str("oidc-issuer", *oidcIssuer).
+			Str("oidc-audience", *oidcAudience).
+			Str("oidc-jwks", *oidcJWKS).
+			Str("oidc-jwks-refresh", oidcJWKSRefresh.String()).
+			Str("auth-cookie", *authCookie).
+			Str("authz", fmt.Sprintf("%t", *authzEnabled)).
+			Str("authz-composition-group", *authzGroup).
+			Str("authz-cache-ttl", authzCacheTTL.String()).
+			Str("authz-resync", authzResync.String()).
+			Str("oidc-username-claim", *usernameClaim).
+			Str("oidc-username-prefix", *usernamePrefix).
+			Str("oidc-groups-claim", *groupsClaim).
+			Str("oidc-groups-prefix", *groupsPrefix).
+			Str("cors-allowed-origins", *corsOrigins).
+			Str("cors-allowed-methods", *corsMethods).
+			Str("cors-allowed-headers", *corsHeaders).
+			Str("cors-allow-credentials", fmt.Sprintf("%t", *corsCredentials)).
+			Str("cors-max-age", corsMaxAge.String()).
+			Str("ready-timeout", readyTimeout.String())
 
 		if *dumpEnv {
 			evt = evt.Strs("env-vars", os.Environ())
@@ -75,16 +227,40 @@ func main() {
 		evt.Msg("configuration and env vars")
 	}
 
-	ttlCache := cache.NewTTL[string, corev1.Event]()
-	defer func() {
-		ttlCache.Clear()
-	}()
+	policy, err := broker.ParsePolicy(*sseOverflow)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid SSE overflow policy")
+	}
 
-	sto, err := store.NewClient(store.Options{
-		Endpoints: strings.Split(*endpoints, ","),
+	brk := broker.New(broker.Options{
+		BufferSize: *sseBuffer,
+		Policy:     policy,
 	})
-	if err != nil {
-		log.Fatal().Err(err).Msg("could not create ETCD client")
+	defer brk.Close()
+
+	var sto store.Store
+	switch *storeKind {
+	case "etcd":
+		sto, err = store.NewClient(store.Options{
+			Endpoints:    strings.Split(*endpoints, ","),
+			LeaseBuckets: *leaseBuckets,
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not create ETCD client")
+		}
+	case "memory":
+		sto = store.NewMemory(store.MemoryOptions{
+			MaxEvents: *maxEvents,
+		})
+	case "bolt":
+		sto, err = store.NewBolt(store.BoltOptions{
+			Path: *boltPath,
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not open bolt database")
+		}
+	default:
+		log.Fatal().Msgf("unknown store %q", *storeKind)
 	}
 	defer sto.Close()
 
@@ -92,28 +268,202 @@ func main() {
 		sto.SetTTL(*ttl)
 	}
 
-	mux := http.NewServeMux()
+	if m, ok := sto.(store.Migrator); ok && *migrate {
+		count, err := m.Migrate()
+		if err != nil {
+			log.Error().Err(err).Msg("could not migrate stored events keys")
+		} else if count > 0 {
+			log.Info().Msgf("[%d] stored events keys migrated", count)
+		}
+	}
+
+	sto = metrics.Store(sto)
+
+	bgCtx, bgCancel := context.WithCancel(context.Background())
+	defer bgCancel()
+
+	ages, err := retention.ParseMaxAge(*maxAge)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid retention policy")
+	}
+
+	keep := retention.Policy{
+		MaxPerComposition: *maxPerComposition,
+		MaxAge:            ages,
+		MaxBytes:          int64(*maxSize) * 1024 * 1024,
+	}
+	if !keep.IsEmpty() {
+		go retention.New(sto, keep).Run(bgCtx, *retentionInterval)
+	}
+
+	// Every replica streams the events stored by any of them.
+	go brk.Feed(bgCtx, sto)
+
+	var handle http.Handler = subscriber.Handle(subscriber.HandleOptions{
+		Store: sto,
+	})
+
+	verify := signature.Options{
+		SecretFile: *hmacSecretFile,
+		Tolerance:  *hmacTolerance,
+	}
+	if len(*hmacSecrets) > 0 {
+		verify.Secrets = strings.Split(*hmacSecrets, ",")
+	}
+	if !verify.IsEmpty() {
+		mw, err := signature.Verify(verify)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not load HMAC secrets")
+		}
+		handle = mw(handle)
+	} else {
+		log.Warn().Msg("no HMAC secrets, '/handle' accepts unsigned requests")
+	}
+
+	// Reading the events may require a token.
+	authenticate := func(next http.Handler) http.Handler { return next }
+	if len(*oidcIssuer) > 0 {
+		if len(*oidcJWKS) == 0 {
+			log.Fatal().Msg("the OIDC issuer is set, but the JWKS is not")
+		}
+
+		keys, err := oidc.NewKeySet(*oidcJWKS, *oidcJWKSRefresh)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not load the JWKS")
+		}
+
+		authenticate = auth.Authenticate(auth.Options{
+			Verifier: oidc.NewVerifier(oidc.Options{
+				Keys:     keys,
+				Issuer:   *oidcIssuer,
+				Audience: *oidcAudience,
+			}),
+			Cookie: *authCookie,
+		})
+	}
+
+	// And the events can be restricted to the compositions the user can get.
+	var authorizer authz.Authorizer
+	authzCached := func() int { return 0 }
+	if *authzEnabled {
+		if len(*oidcIssuer) == 0 {
+			log.Fatal().Msg("the authorization requires the OIDC authentication")
+		}
+
+		cfg, err := rest.InClusterConfig()
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not get the Kubernetes configuration")
+		}
+		cs, err := kubernetes.NewForConfig(cfg)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not create the Kubernetes client")
+		}
+		dyn, err := dynamic.NewForConfig(cfg)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not create the Kubernetes dynamic client")
+		}
+
+		reviewer := authz.NewReviewer(authz.ReviewerOptions{
+			Client: cs,
+			Resolver: authz.NewResolver(authz.ResolverOptions{
+				Discovery: cs.Discovery(),
+				Dynamic:   dyn,
+				Group:     *authzGroup,
+				Resync:    *authzResync,
+			}),
+			UsernameClaim:  *usernameClaim,
+			UsernamePrefix: *usernamePrefix,
+			GroupsClaim:    *groupsClaim,
+			GroupsPrefix:   *groupsPrefix,
+			CacheTTL:       *authzCacheTTL,
+		})
+		authorizer, authzCached = reviewer, reviewer.Cached
+	}
+	// Zero when disabled, so that the dashboards find it.
+	metrics.RegisterCache("authz", authzCached)
 
 	healthy := int32(0)
+	build := health.Build{Version: version, Commit: commit}
+
+	probes := []health.Probe{{
+		Name:  "broker",
+		Check: func(context.Context) error { return brk.Live() },
+	}}
+	if pinger, ok := sto.(store.Pinger); ok {
+		probes = append(probes, health.Probe{Name: "store", Check: pinger.Ping})
+	}
+	ready := health.Ready(health.ReadyOptions{
+		Healthy: &healthy,
+		Probes:  probes,
+		Timeout: *readyTimeout,
+	})
 
-	mux.Handle("GET /health", health.Check(&healthy, serviceName))
-	mux.Handle("POST /handle", subscriber.Handle(subscriber.HandleOptions{
-		TTLCache: ttlCache,
-		Store:    sto,
-	}))
-	mux.Handle("GET /notifications", publisher.SSE(ttlCache))
-	mux.Handle("GET /events", getter.Events(sto, *limit))
-	mux.Handle("GET /events/{composition}", getter.Events(sto, *limit))
-	mux.Handle("/swagger/", httpSwagger.WrapHandler)
+	metrics.RegisterBroker(brk)
 
-	server := &http.Server{
-		Addr:         fmt.Sprintf(":%d", *port),
-		Handler:      mux,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 50 * time.Second,
-		IdleTimeout:  30 * time.Second,
+	// Every route observes the duration of its requests.
+	route := func(mux *http.ServeMux, pattern string, handler http.Handler) {
+		mux.Handle(pattern, metrics.Route(pattern, handler))
 	}
 
+	// The internal listener is for the eventrouter and the
+	// admins only: it must not be exposed out of the cluster.
+	internalMux := http.NewServeMux()
+	route(internalMux, "GET /health", health.Check(&healthy, serviceName, build))
+	route(internalMux, "GET /ready", ready)
+	route(internalMux, "POST /handle", handle)
+	route(internalMux, "GET /notifications/stats", publisher.Stats(brk))
+	internalMux.Handle("GET /metrics", metrics.Handler())
+
+	publicMux := http.NewServeMux()
+	route(publicMux, "GET /health", health.Check(&healthy, serviceName, build))
+	route(publicMux, "GET /notifications", authenticate(publisher.SSE(publisher.SSEOptions{
+		Broker:     brk,
+		Store:      sto,
+		KeepAlive:  *keepAlive,
+		Retry:      *retry,
+		Authorizer: authorizer,
+	})))
+	route(publicMux, "GET /events", authenticate(getter.Events(sto, *limit, authorizer)))
+	route(publicMux, "GET /events/{composition}", authenticate(getter.Events(sto, *limit, authorizer)))
+	publicMux.Handle("/swagger/", httpSwagger.WrapHandler)
+
+	if *internalPort == *port {
+		log.Fatal().Msgf("the internal and the public listeners must use different ports (%d)", *port)
+	}
+
+	internalServer := server.New(internalMux, server.Options{
+		Port:         *internalPort,
+		ReadTimeout:  *internalReadTimeout,
+		WriteTimeout: *internalWriteTimeout,
+		IdleTimeout:  *internalIdleTimeout,
+		Middlewares:  []server.Middleware{logger.Logger(log)},
+	})
+
+	// The browsers read the events cross-origin: the preflight
+	// requests are answered before reaching the routes.
+	corsPolicy, err := cors.Handler(cors.Options{
+		AllowedOrigins:   strings.Split(*corsOrigins, ","),
+		AllowedMethods:   strings.Split(*corsMethods, ","),
+		AllowedHeaders:   strings.Split(*corsHeaders, ","),
+		ExposedHeaders:   []string{"Content-Type", getter.ContinueHeader},
+		AllowCredentials: *corsCredentials,
+		MaxAge:           *corsMaxAge,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid CORS policy")
+	}
+
+	publicServer := server.New(publicMux, server.Options{
+		Port:         *port,
+		ReadTimeout:  *readTimeout,
+		WriteTimeout: *writeTimeout,
+		IdleTimeout:  *idleTimeout,
+		Middlewares:  []server.Middleware{logger.Logger(log), corsPolicy},
+	})
+	// SSE streams never become idle by themselves: terminate
+	// them, so that the shutdown does not wait for the timeout.
+	publicServer.RegisterOnShutdown(brk.Close)
+
 	ctx, stop := signal.NotifyContext(context.Background(), []os.Signal{
 		os.Interrupt,
 		syscall.SIGINT,
@@ -124,15 +474,20 @@ func main() {
 	}...)
 	defer stop()
 
-	go func() {
-		atomic.StoreInt32(&healthy, 1)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatal().Err(err).Msgf("could not listen on %s", server.Addr)
-		}
-	}()
+	atomic.StoreInt32(&healthy, 1)
+	for _, srv := range []*http.Server{internalServer, publicServer} {
+		go func(srv *http.Server) {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatal().Err(err).Msgf("could not listen on %s", srv.Addr)
+			}
+		}(srv)
+	}
 
 	// Listen for the interrupt signal.
-	log.Info().Msgf("server is ready to handle requests at @ %s", server.Addr)
+	log.Info().
+		Str("version", version).
+		Str("commit", commit).
+		Msgf("server is ready to handle requests at @ %s (internal @ %s)", publicServer.Addr, internalServer.Addr)
 	<-ctx.Done()
 
 	// Restore default behavior on the interrupt signal and notify user of shutdown.
@@ -143,9 +498,13 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	server.SetKeepAlivesEnabled(false)
-	if err := server.Shutdown(ctx); err != nil {
-		log.Fatal().Err(err).Msg("server forced to shutdown")
+	// Stop the ingestion first: the events already received
+	// are still notified while the SSE streams are open.
+	for _, srv := range []*http.Server{internalServer, publicServer} {
+		srv.SetKeepAlivesEnabled(false)
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Fatal().Err(err).Msgf("server %s forced to shutdown", srv.Addr)
+		}
 	}
 
 	log.Info().Msg("server gracefully stopped")
@@ -1,5 +1,8 @@
 #!/bin/bash
 
+# Read by .ko.yaml to set the version reported by /health.
+export VERSION=$(git describe --tags --always --dirty)
+
 KO_DOCKER_REPO=kind.local ko build --base-import-paths .
 
 printf '\n\nList of current docker images loaded in KinD:\n'
@@ -4,6 +4,7 @@
 # Deploy EventRouter
 kubectl apply -f manifests/ns.yaml
 kubectl apply -f manifests/sa.yaml
+kubectl apply -f manifests/etcd.yaml
 kubectl apply -f manifests/deployment.yaml
 kubectl apply -f manifests/registration.yaml
 kubectl apply -f manifests/service.yaml
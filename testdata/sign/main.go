@@ -0,0 +1,59 @@
+// Command sign posts signed events to eventsse, the same
+// way the eventrouter does when HMAC secrets are set.
+//
+//	go run ./testdata/sign -secret $SECRET -url http://127.0.0.1:8182/handle testdata/event.sample1.json
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/krateoplatformops/eventsse/internal/middlewares/signature"
+)
+
+func main() {
+	secret := flag.String("secret", os.Getenv("EVENTSSE_HMAC_SECRET"), "shared secret")
+	url := flag.String("url", "http://127.0.0.1:8182/handle", "eventsse '/handle' endpoint")
+	contentType := flag.String("content-type", "application/json", "request content type")
+	skew := flag.Duration("skew", 0, "shifts the request timestamp (i.e. -10m to test the replay protection)")
+	flag.Parse()
+
+	if flag.NArg() != 1 || len(*secret) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: sign -secret SECRET [-url URL] FILE")
+		os.Exit(2)
+	}
+
+	body, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	ts := time.Now().Add(*skew)
+
+	req, err := http.NewRequest(http.MethodPost, *url, bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	req.Header.Set("Content-Type", *contentType)
+	req.Header.Set(signature.TimestampHeader, strconv.FormatInt(ts.Unix(), 10))
+	req.Header.Set(signature.SignatureHeader, signature.Sign([]byte(*secret), ts, body))
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer res.Body.Close()
+
+	fmt.Println(res.Status)
+	io.Copy(os.Stdout, res.Body)
+	fmt.Println()
+}